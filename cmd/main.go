@@ -2,16 +2,19 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"nosqlEngine/src/config"
 	"nosqlEngine/src/engine"
 )
-var CONFIG = config.GetConfig()
+var CONFIG config.Config
 
 const (
 	// ANSI Color codes for beautiful output
@@ -27,12 +30,36 @@ const (
 )
 
 func main() {
+	configPath := flag.String("config", "", "path to a JSON config file overriding the built-in defaults")
+	dataDir := flag.String("data", "", "directory holding the SSTables and WAL (defaults to DATA_DIR)")
+	flag.Parse()
+
 	printWelcome()
 
 	// Initialize and start the engine
 	fmt.Printf("%s[INFO]%s Starting NoSQL Engine...\n", ColorCyan, ColorReset)
-	eng := engine.NewEngine()
-	eng.Start()
+	var err error
+	CONFIG, err = config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Printf("%s[ERROR]%s %v\n", ColorRed, ColorReset, err)
+		os.Exit(1)
+	}
+	if *dataDir != "" {
+		CONFIG.DataDir = *dataDir
+	}
+	eng, err := engine.NewEngine(CONFIG)
+	if err != nil {
+		fmt.Printf("%s[ERROR]%s Failed to start engine: %v\n", ColorRed, ColorReset, err)
+		os.Exit(1)
+	}
+	report, err := eng.Start()
+	if err != nil {
+		fmt.Printf("%s[ERROR]%s Failed to recover engine: %v\n", ColorRed, ColorReset, err)
+		os.Exit(1)
+	}
+	if dropped := report.DroppedCount(); dropped > 0 {
+		fmt.Printf("%s[WARN]%s Recovered %d WAL records, dropped %d corrupted ones\n", ColorYellow, ColorReset, report.Replayed, dropped)
+	}
 	fmt.Printf("%s[SUCCESS]%s Engine started successfully!\n", ColorGreen, ColorReset)
 
 	// Create scanner for user input
@@ -74,6 +101,7 @@ func printHelp() {
 	fmt.Printf("  %s📝 PUT <key> <value>%s    - Store a key-value pair\n", ColorGreen, ColorReset)
 	fmt.Printf("  %s🔍 GET <key>%s           - Retrieve value for a key\n", ColorBlue, ColorReset)
 	fmt.Printf("  %s🗑️  DELETE <key>%s        - Delete a key-value pair\n", ColorRed, ColorReset)
+	fmt.Printf("  %s🗜️  COMPACT <start> <end>%s - Compact the SSTables holding keys from start to end\n", ColorPurple, ColorReset)
 	fmt.Printf("  %s📊 STATS%s              - Show engine statistics\n", ColorPurple, ColorReset)
 	fmt.Printf("  %s❓ HELP%s               - Show this help message\n", ColorCyan, ColorReset)
 	fmt.Printf("  %sPREFIX_SCAN <prefix> <pageNum> <pageSize>%s -Use prefix iterator\n", ColorWhite, ColorReset)
@@ -86,8 +114,49 @@ func printPrompt() {
 	fmt.Printf("%s%sNoSQL>%s ", ColorBold, ColorGreen, ColorReset)
 }
 
+// splitArgs splits input on whitespace. An argument in double quotes is a Go
+// string literal, so it may hold spaces and any byte, e.g. "user\x00id".
+func splitArgs(input string) ([]string, error) {
+	args := []string{}
+	for {
+		input = strings.TrimLeftFunc(input, unicode.IsSpace)
+		if input == "" {
+			return args, nil
+		}
+		if input[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(input)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted argument %s", input)
+			}
+			arg, _ := strconv.Unquote(quoted)
+			args = append(args, arg)
+			input = input[len(quoted):]
+			continue
+		}
+		end := strings.IndexFunc(input, unicode.IsSpace)
+		if end == -1 {
+			end = len(input)
+		}
+		args = append(args, input[:end])
+		input = input[end:]
+	}
+}
+
+// display prints keys and values as typed, or quoted when they hold bytes
+// that would not show, such as control characters or invalid UTF-8
+func display(s string) string {
+	if !utf8.ValidString(s) || strings.IndexFunc(s, func(r rune) bool { return !unicode.IsPrint(r) }) != -1 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
 func handleCommand(eng *engine.Engine, input string) {
-	parts := strings.Fields(input)
+	parts, err := splitArgs(input)
+	if err != nil {
+		fmt.Printf("%s[ERROR]%s %v\n", ColorRed, ColorReset, err)
+		return
+	}
 	if len(parts) == 0 {
 		return
 	}
@@ -101,6 +170,8 @@ func handleCommand(eng *engine.Engine, input string) {
 		handleGet(eng, parts)
 	case "DELETE", "DEL":
 		handleDelete(eng, parts)
+	case "COMPACT":
+		handleCompact(eng, parts)
 	case "STATS":
 		handleStats(eng)
 	case "HELP", "H":
@@ -141,9 +212,9 @@ func handlePut(eng *engine.Engine, parts []string) {
 
 	if err == nil {
 		fmt.Printf("%s[SUCCESS]%s ✅ PUT '%s' -> '%s' %s(%.2fms)%s\n",
-			ColorGreen, ColorReset, key, value, ColorYellow, float64(duration.Nanoseconds())/1e6, ColorReset)
+			ColorGreen, ColorReset, display(key), display(value), ColorYellow, float64(duration.Nanoseconds())/1e6, ColorReset)
 	} else {
-		fmt.Printf("%s[ERROR]%s ❌ Failed to store key '%s': %v\n", ColorRed, ColorReset, key, err)
+		fmt.Printf("%s[ERROR]%s ❌ Failed to store key '%s': %v\n", ColorRed, ColorReset, display(key), err)
 	}
 }
 
@@ -161,12 +232,12 @@ func handleGet(eng *engine.Engine, parts []string) {
 	duration := time.Since(start)
 
 	
- 	if found && value != CONFIG.Tombstone {
+ 	if found {
 		fmt.Printf("%s[SUCCESS]%s 🔍 GET '%s' -> '%s' %s(%.2fms)%s\n",
-			ColorGreen, ColorReset, key, value, ColorYellow, float64(duration.Nanoseconds())/1e6, ColorReset)
+			ColorGreen, ColorReset, display(key), display(value), ColorYellow, float64(duration.Nanoseconds())/1e6, ColorReset)
 	} else {
 		fmt.Printf("%s[NOT FOUND]%s 🚫 Key '%s' not found %s(%.2fms)%s\n",
-			ColorYellow, ColorReset, key, ColorYellow, float64(duration.Nanoseconds())/1e6, ColorReset)
+			ColorYellow, ColorReset, display(key), ColorYellow, float64(duration.Nanoseconds())/1e6, ColorReset)
 	}
 }
 
@@ -179,19 +250,33 @@ func handleDelete(eng *engine.Engine, parts []string) {
 	key := parts[1]
 	user := "default" // Default user for CLI
 
-	// Get the tombstone value from config
-	cfg := config.GetConfig()
-	tombstone := cfg.Tombstone
-
 	start := time.Now()
-	err := eng.Write(user, key, tombstone, false) // Delete by writing tombstone value
+	err := eng.Delete(user, key)
 	duration := time.Since(start)
 
 	if err == nil {
 		fmt.Printf("%s[SUCCESS]%s 🗑️ DELETE '%s' %s(%.2fms)%s\n",
-			ColorGreen, ColorReset, key, ColorYellow, float64(duration.Nanoseconds())/1e6, ColorReset)
+			ColorGreen, ColorReset, display(key), ColorYellow, float64(duration.Nanoseconds())/1e6, ColorReset)
+	} else {
+		fmt.Printf("%s[ERROR]%s ❌ Failed to delete key '%s': %v\n", ColorRed, ColorReset, display(key), err)
+	}
+}
+
+func handleCompact(eng *engine.Engine, parts []string) {
+	if len(parts) != 3 {
+		fmt.Printf("%s[ERROR]%s Usage: COMPACT <start> <end>\n", ColorRed, ColorReset)
+		return
+	}
+
+	start := time.Now()
+	err := eng.CompactRange(parts[1], parts[2])
+	duration := time.Since(start)
+
+	if err == nil {
+		fmt.Printf("%s[SUCCESS]%s 🗜️ COMPACT '%s'..'%s' %s(%.2fms)%s\n",
+			ColorGreen, ColorReset, display(parts[1]), display(parts[2]), ColorYellow, float64(duration.Nanoseconds())/1e6, ColorReset)
 	} else {
-		fmt.Printf("%s[ERROR]%s ❌ Failed to delete key '%s': %v\n", ColorRed, ColorReset, key, err)
+		fmt.Printf("%s[ERROR]%s ❌ Failed to compact: %v\n", ColorRed, ColorReset, err)
 	}
 }
 
@@ -199,9 +284,11 @@ func handleStats(eng *engine.Engine) {
 	fmt.Printf("%s%s📊 Engine Statistics:%s\n", ColorBold, ColorPurple, ColorReset)
 	fmt.Printf("  %s├─%s Status: %sRunning%s\n", ColorPurple, ColorReset, ColorGreen, ColorReset)
 	fmt.Printf("  %s├─%s Engine: %sActive%s\n", ColorPurple, ColorReset, ColorCyan, ColorReset)
-	fmt.Printf("  %s└─%s Version: %s1.0.0%s\n", ColorPurple, ColorReset, ColorBlue, ColorReset)
-	// Add more statistics as needed based on your engine implementation
-	_ = eng // Prevent unused parameter warning
+	fmt.Printf("  %s├─%s Version: %s1.0.0%s\n", ColorPurple, ColorReset, ColorBlue, ColorReset)
+	stats := eng.CompactionStats()
+	fmt.Printf("  %s├─%s Compactions: %s%d%s\n", ColorPurple, ColorReset, ColorCyan, stats.Compactions, ColorReset)
+	fmt.Printf("  %s├─%s Tombstones dropped: %s%d%s\n", ColorPurple, ColorReset, ColorCyan, stats.TombstonesDropped, ColorReset)
+	fmt.Printf("  %s└─%s Last compaction: %slvl%d, %d table(s) in, %d out, %d tombstone(s) dropped%s\n", ColorPurple, ColorReset, ColorBlue, stats.Last.Level, stats.Last.Inputs, stats.Last.Outputs, stats.Last.TombstonesDropped, ColorReset)
 }
 
 func handlePrefixScan(eng *engine.Engine, parts []string) {
@@ -211,7 +298,7 @@ func handlePrefixScan(eng *engine.Engine, parts []string) {
 	pageSize, _ := strconv.Atoi(parts[3])
 	results := eng.PrefixScan(user, prefix, pageNum, pageSize)
 	for i, record := range results {
-		fmt.Printf("%s[%d]%s Key: %s, Value: %s\n", ColorBlue, i+1, ColorReset, record[0], record[1])
+		fmt.Printf("%s[%d]%s Key: %s, Value: %s\n", ColorBlue, i+1, ColorReset, display(record[0]), display(record[1]))
 	}
 }
 
@@ -223,7 +310,7 @@ func handleRangeScan(eng *engine.Engine, parts []string) {
 	pageSize, _ := strconv.Atoi(parts[4])
 	results := eng.RangeScan(user, start, end, pageNum, pageSize)
 	for i, record := range results {
-		fmt.Printf("%s[%d]%s Key: %s, Value: %s\n", ColorBlue, i+1, ColorReset, record[0], record[1])
+		fmt.Printf("%s[%d]%s Key: %s, Value: %s\n", ColorBlue, i+1, ColorReset, display(record[0]), display(record[1]))
 	}
 }
 
@@ -236,25 +323,42 @@ func handlePrefixIterator(eng *engine.Engine, parts []string) {
 		return
 	}
 
-	fmt.Printf("Prefix iterator created for prefix '%s'. Use 'next' to get next record, 'stop' to terminate.\n", prefix)
+	fmt.Printf("Prefix iterator created for prefix '%s'. Use 'next' and 'prev' to move, 'stop' to terminate.\n", prefix)
+
+	defer iterator.Stop()
 
 	for {
-		var command string
+		var command, arg string
 		fmt.Print("Iterator> ")
-		fmt.Scanln(&command)
+		fmt.Scanln(&command, &arg)
 
 		switch command {
 		case "next":
 			key, value, hasNext := iterator.Next()
 			if key == "" && value == "" {
 				fmt.Println("No more records.")
-				return
+				continue
 			}
-			fmt.Printf("Key: %s, Value: %s\n", key, value)
+			fmt.Printf("Key: %s, Value: %s\n", display(key), display(value))
 			if !hasNext {
 				fmt.Println("This was the last record.")
-				return
 			}
+		case "prev":
+			key, value, hasPrev := iterator.Prev()
+			if key == "" && value == "" {
+				fmt.Println("No previous records.")
+				continue
+			}
+			fmt.Printf("Key: %s, Value: %s\n", display(key), display(value))
+			if !hasPrev {
+				fmt.Println("This was the first record.")
+			}
+		case "seek":
+			iterator.Seek(arg)
+			fmt.Printf("Iterator moved before the first key >= '%s'.\n", arg)
+		case "seek_for_prev":
+			iterator.SeekForPrev(arg)
+			fmt.Printf("Iterator moved before the last key <= '%s'.\n", arg)
 		case "stop":
 			iterator.Stop()
 			fmt.Println("Iterator stopped.")
@@ -269,7 +373,7 @@ func handlePrefixIterator(eng *engine.Engine, parts []string) {
 			iterator.Reset()
 			fmt.Println("Iterator reset to beginning.")
 		default:
-			fmt.Println("Unknown command. Available commands: next, stop, has_next, reset")
+			fmt.Println("Unknown command. Available commands: next, prev, seek <key>, seek_for_prev <key>, stop, has_next, reset")
 		}
 	}
 }
@@ -284,25 +388,42 @@ func handleRangeIterate(eng *engine.Engine, parts []string) {
 		return
 	}
 
-	fmt.Printf("Range iterator created for range '%s' to '%s'. Use 'next' to get next record, 'stop' to terminate.\n", start, end)
+	fmt.Printf("Range iterator created for range '%s' to '%s'. Use 'next' and 'prev' to move, 'stop' to terminate.\n", start, end)
+
+	defer iterator.Stop()
 
 	for {
-		var command string
+		var command, arg string
 		fmt.Print("Iterator> ")
-		fmt.Scanln(&command)
+		fmt.Scanln(&command, &arg)
 
 		switch command {
 		case "next":
 			key, value, hasNext := iterator.Next()
 			if key == "" && value == "" {
 				fmt.Println("No more records.")
-				return
+				continue
 			}
-			fmt.Printf("Key: %s, Value: %s\n", key, value)
+			fmt.Printf("Key: %s, Value: %s\n", display(key), display(value))
 			if !hasNext {
 				fmt.Println("This was the last record.")
-				return
 			}
+		case "prev":
+			key, value, hasPrev := iterator.Prev()
+			if key == "" && value == "" {
+				fmt.Println("No previous records.")
+				continue
+			}
+			fmt.Printf("Key: %s, Value: %s\n", display(key), display(value))
+			if !hasPrev {
+				fmt.Println("This was the first record.")
+			}
+		case "seek":
+			iterator.Seek(arg)
+			fmt.Printf("Iterator moved before the first key >= '%s'.\n", arg)
+		case "seek_for_prev":
+			iterator.SeekForPrev(arg)
+			fmt.Printf("Iterator moved before the last key <= '%s'.\n", arg)
 		case "stop":
 			iterator.Stop()
 			fmt.Println("Iterator stopped.")
@@ -317,7 +438,7 @@ func handleRangeIterate(eng *engine.Engine, parts []string) {
 			iterator.Reset()
 			fmt.Println("Iterator reset to beginning.")
 		default:
-			fmt.Println("Unknown command. Available commands: next, stop, has_next, reset")
+			fmt.Println("Unknown command. Available commands: next, prev, seek <key>, seek_for_prev <key>, stop, has_next, reset")
 		}
 	}
 }
@@ -4,4 +4,4 @@ go 1.23.2
 
 require github.com/google/uuid v1.6.0
 
-require github.com/cespare/xxhash/v2 v2.3.0 // indirect
+require github.com/cespare/xxhash/v2 v2.3.0
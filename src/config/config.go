@@ -1,18 +1,28 @@
 package config
 
 import (
+	"bytes"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"nosqlEngine/src/models/comparator"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 )
 
 //go:embed config.json
 var configData []byte
 
+// EnvPrefix is prepended to a setting's JSON name to override it from the
+// environment, e.g. NOSQL_MEMTABLE_SIZE=100
+const EnvPrefix = "NOSQL_"
+
 type Config struct {
 	BlockSize                    int     `json:"BLOCK_SIZE"`
 	SummaryStep                  int     `json:"SUMMARY_STEP"`
-	Tombstone                    string  `json:"TOMBSTONE"`
 	TokenRefillRate              float64 `json:"TOKEN_REFILL_RATE"`
 	MaxTokens                    int     `json:"MAX_TOKEN"`
 	MemtableType                 string  `json:"MEMTABLE_TYPE"`
@@ -20,22 +30,178 @@ type Config struct {
 	MemtableSize                 int     `json:"MEMTABLE_SIZE"`
 	WALBufferSize                int     `json:"WAL_BUFFER_SIZE"`
 	WALSegmentSize               int     `json:"WAL_SEGMENT_SIZE"`
+	WALSyncMode                  string  `json:"WAL_SYNC_MODE"`               // sync, group or periodic
+	WALGroupCommitLatency        int     `json:"WAL_GROUP_COMMIT_LATENCY_MS"` // longest a write waits for its group in group mode
+	WALSyncInterval              int     `json:"WAL_SYNC_INTERVAL_MS"`        // time between syncs in periodic mode
+	WALRecoveryMode              string  `json:"WAL_RECOVERY_MODE"`           // truncate, skip or strict
 	BloomFilterFalsePositiveRate float64 `json:"BLOOM_FILTER_FALSE_POSITIVE_RATE"`
 	BloomFilterExpectedElements  int     `json:"BLOOM_FILTER_EXPECTED_ELEMENTS"`
 	LSMLevels                    int     `json:"LSM_LEVELS"`
-	LSMBaseDir                   string  `json:"LSM_BASE_DIR"`
+	DataDir                      string  `json:"DATA_DIR"`     // root of every file the engine writes
+	LSMBaseDir                   string  `json:"LSM_BASE_DIR"` // SSTable directory, relative to DATA_DIR
 	MinPrefixLength              int     `json:"MIN_PREFIX_LENGTH"`
 	MaxPrefixLength              int     `json:"MAX_PREFIX_LENGTH"`
 	SkipListLevels               int     `json:"SKIP_LIST_LEVELS"`
+	BTreeDegree                  int     `json:"BTREE_DEGREE"`
 	CompactionThreshold          int     `json:"COMPACTION_THRESHOLD"`
+	CompactionStrategy           string  `json:"COMPACTION_STRATEGY"`   // size_tiered or leveled
+	LevelBaseSize                int     `json:"LEVEL_BASE_SIZE"`       // bytes lvl1 holds before leveled compaction moves tables down
+	LevelSizeMultiplier          int     `json:"LEVEL_SIZE_MULTIPLIER"` // each level below lvl1 holds this many times the level above
+	SSTableTargetSize            int     `json:"SSTABLE_TARGET_SIZE"`   // leveled compaction starts a new table once one reaches this many bytes
+	CompactionWorkers            int     `json:"COMPACTION_WORKERS"`    // compactions running at the same time
+	CompactionRateLimit          int     `json:"COMPACTION_RATE_LIMIT"` // bytes per second compactions read together, 0 for no limit
 	CacheCapacity                int     `json:"CACHE_CAPACITY"`
+	KeyComparator                string  `json:"KEY_COMPARATOR"` // name of a registered comparator ordering keys
 }
 
-func GetConfig() Config {
+// DefaultConfig returns the defaults embedded from config.json
+func DefaultConfig() Config {
 	var config Config
 	if err := json.Unmarshal(configData, &config); err != nil {
-		panic(fmt.Sprintf("failed to parse config file: %v", err))
+		panic(fmt.Sprintf("failed to parse embedded config file: %v", err))
 	}
+	return config
+}
 
+// LoadConfig starts from the defaults, overlays the JSON file at path (skipped
+// when path is empty) and then any NOSQL_* environment variables, and
+// validates the result.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read config file: %w", err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			return Config{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+	if err := config.applyEnv(); err != nil {
+		return Config{}, err
+	}
+	config = config.Normalized()
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Normalized returns config with the settings that may be left empty filled
+// in, so they have one spelling: an empty KEY_COMPARATOR is bytewise
+func (config Config) Normalized() Config {
+	if config.KeyComparator == "" {
+		config.KeyComparator = comparator.Bytewise
+	}
 	return config
 }
+
+// applyEnv overrides every setting whose NOSQL_<JSON name> variable is set
+func (config *Config) applyEnv() error {
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := EnvPrefix + value.Type().Field(i).Tag.Get("json")
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.Int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: expected an integer", name, raw)
+			}
+			field.SetInt(int64(n))
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: expected a number", name, raw)
+			}
+			field.SetFloat(f)
+		case reflect.String:
+			field.SetString(raw)
+		}
+	}
+	return nil
+}
+
+// Validate reports every setting that the engine cannot run with
+func (config Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	// a block must hold at least the section size (8), end notation (3) and jumbo flag (3)
+	check(config.BlockSize >= 16, "BLOCK_SIZE must be at least 16, got %d", config.BlockSize)
+	check(config.SummaryStep >= 1, "SUMMARY_STEP must be at least 1, got %d", config.SummaryStep)
+	check(config.TokenRefillRate >= 0, "TOKEN_REFILL_RATE must not be negative, got %v", config.TokenRefillRate)
+	check(config.MaxTokens >= 1, "MAX_TOKEN must be at least 1, got %d", config.MaxTokens)
+	check(config.MemtableType == "hashmap" || config.MemtableType == "skiplist" || config.MemtableType == "btree",
+		"MEMTABLE_TYPE must be one of hashmap, skiplist, btree, got %q", config.MemtableType)
+	check(config.MemtableCount >= 1, "MEMTABLE_COUNT must be at least 1, got %d", config.MemtableCount)
+	check(config.MemtableSize >= 1, "MEMTABLE_SIZE must be at least 1, got %d", config.MemtableSize)
+	check(config.WALBufferSize >= 1, "WAL_BUFFER_SIZE must be at least 1, got %d", config.WALBufferSize)
+	check(config.WALSegmentSize >= 1, "WAL_SEGMENT_SIZE must be at least 1, got %d", config.WALSegmentSize)
+	check(config.WALSyncMode == "sync" || config.WALSyncMode == "group" || config.WALSyncMode == "periodic",
+		"WAL_SYNC_MODE must be one of sync, group, periodic, got %q", config.WALSyncMode)
+	check(config.WALGroupCommitLatency >= 1, "WAL_GROUP_COMMIT_LATENCY_MS must be at least 1, got %d", config.WALGroupCommitLatency)
+	check(config.WALSyncInterval >= 1, "WAL_SYNC_INTERVAL_MS must be at least 1, got %d", config.WALSyncInterval)
+	check(config.WALRecoveryMode == "truncate" || config.WALRecoveryMode == "skip" || config.WALRecoveryMode == "strict",
+		"WAL_RECOVERY_MODE must be one of truncate, skip, strict, got %q", config.WALRecoveryMode)
+	check(config.BloomFilterFalsePositiveRate > 0 && config.BloomFilterFalsePositiveRate < 1,
+		"BLOOM_FILTER_FALSE_POSITIVE_RATE must be between 0 and 1, got %v", config.BloomFilterFalsePositiveRate)
+	check(config.BloomFilterExpectedElements >= 1, "BLOOM_FILTER_EXPECTED_ELEMENTS must be at least 1, got %d", config.BloomFilterExpectedElements)
+	check(config.LSMLevels >= 1, "LSM_LEVELS must be at least 1, got %d", config.LSMLevels)
+	check(config.DataDir != "", "DATA_DIR must not be empty")
+	check(config.LSMBaseDir != "" && !filepath.IsAbs(config.LSMBaseDir), "LSM_BASE_DIR must be a relative path, got %q", config.LSMBaseDir)
+	check(config.MinPrefixLength >= 1, "MIN_PREFIX_LENGTH must be at least 1, got %d", config.MinPrefixLength)
+	check(config.MaxPrefixLength >= config.MinPrefixLength,
+		"MAX_PREFIX_LENGTH must be at least MIN_PREFIX_LENGTH (%d), got %d", config.MinPrefixLength, config.MaxPrefixLength)
+	check(config.SkipListLevels >= 1, "SKIP_LIST_LEVELS must be at least 1, got %d", config.SkipListLevels)
+	check(config.BTreeDegree >= 2, "BTREE_DEGREE must be at least 2, got %d", config.BTreeDegree)
+	check(config.CompactionThreshold >= 2, "COMPACTION_THRESHOLD must be at least 2, got %d", config.CompactionThreshold)
+	check(config.CompactionStrategy == "size_tiered" || config.CompactionStrategy == "leveled",
+		"COMPACTION_STRATEGY must be one of size_tiered, leveled, got %q", config.CompactionStrategy)
+	check(config.LevelBaseSize >= 1, "LEVEL_BASE_SIZE must be at least 1, got %d", config.LevelBaseSize)
+	check(config.LevelSizeMultiplier >= 2, "LEVEL_SIZE_MULTIPLIER must be at least 2, got %d", config.LevelSizeMultiplier)
+	check(config.SSTableTargetSize >= 1, "SSTABLE_TARGET_SIZE must be at least 1, got %d", config.SSTableTargetSize)
+	check(config.CompactionWorkers >= 1, "COMPACTION_WORKERS must be at least 1, got %d", config.CompactionWorkers)
+	check(config.CompactionRateLimit >= 0, "COMPACTION_RATE_LIMIT must not be negative, got %d", config.CompactionRateLimit)
+	check(config.CacheCapacity >= 1, "CACHE_CAPACITY must be at least 1, got %d", config.CacheCapacity)
+	_, known := comparator.Lookup(config.KeyComparator)
+	check(known, "KEY_COMPARATOR must name a registered comparator, got %q", config.KeyComparator)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// SSTableDir is the directory holding the lvlN folders of SSTables
+func (config Config) SSTableDir() string {
+	return filepath.Join(config.DataDir, config.LSMBaseDir)
+}
+
+// LevelDir is the directory holding the SSTables of one LSM level
+func (config Config) LevelDir(level int) string {
+	return filepath.Join(config.SSTableDir(), fmt.Sprintf("lvl%d", level))
+}
+
+// WALDir is the directory holding the WAL segments
+func (config Config) WALDir() string {
+	return filepath.Join(config.DataDir, "wal")
+}
+
+// Comparator returns the comparator ordering keys. It panics on an unknown
+// name, which Validate rejects before an engine is created.
+func (config Config) Comparator() comparator.Comparator {
+	cmp, ok := comparator.Lookup(config.KeyComparator)
+	if !ok {
+		panic(fmt.Sprintf("unknown key comparator %q", config.KeyComparator))
+	}
+	return cmp
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"fmt"
+	"nosqlEngine/src/models/key_value"
+	"nosqlEngine/src/storage/wal"
+)
+
+// Batch collects puts and deletes that Engine.Apply writes atomically: after a
+// crash either all of them are recovered or none is, and readers never see
+// only part of a batch.
+type Batch struct {
+	entries []key_value.KeyValue
+}
+
+func NewBatch() *Batch {
+	return &Batch{entries: make([]key_value.KeyValue, 0)}
+}
+
+// Put adds a write of value under key
+func (batch *Batch) Put(key string, value string) {
+	batch.entries = append(batch.entries, key_value.NewKeyValue(key, value))
+}
+
+// Delete adds a tombstone for key
+func (batch *Batch) Delete(key string) {
+	batch.entries = append(batch.entries, key_value.NewTombstone(key))
+}
+
+// Len returns the number of operations in the batch
+func (batch *Batch) Len() int {
+	return len(batch.entries)
+}
+
+// Apply writes every operation of batch as one WAL record and, once it is
+// durable, to the memtable, in the order they were added. Later operations
+// on a key win.
+func (engine *Engine) Apply(user string, batch *Batch) error {
+	if ok, err := engine.userLimiter.CheckUserTokens(user); !ok {
+		return fmt.Errorf("user %s is not allowed to write: %w", user, err)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	engine.mem_lock.Lock()
+	if engine.failed != nil {
+		engine.mem_lock.Unlock()
+		return engine.failed
+	}
+	last := engine.appendBatch(batch)
+	engine.mem_lock.Unlock()
+
+	if err := engine.commit(last); err != nil {
+		return fmt.Errorf("failed to write batch to WAL: %w", err)
+	}
+	return nil
+}
+
+// appendBatch logs a non-empty batch as one WAL record and queues it for the
+// memtable. It returns the WAL ticket of the batch, the caller holds
+// mem_lock.
+func (engine *Engine) appendBatch(batch *Batch) uint64 {
+	ops := make([]wal.WALEntry, len(batch.entries))
+	for i, entry := range batch.entries {
+		if entry.IsTombstone() {
+			ops[i] = wal.NewDeleteEntry(entry.GetKey())
+		} else {
+			ops[i] = wal.NewPutEntry(entry.GetKey(), entry.GetValue())
+		}
+	}
+	last := engine.wal.Append(wal.NewBatchEntry(ops))
+	first := last - uint64(len(ops)) + 1
+	entries := make([]key_value.KeyValue, len(batch.entries))
+	for i, entry := range batch.entries {
+		entries[i] = entry.WithSeq(first + uint64(i))
+	}
+	engine.pending = append(engine.pending, pendingWrite{ticket: last, entries: entries})
+	return last
+}
@@ -0,0 +1,88 @@
+package engine
+
+// The []byte API stores keys and values as they are, so they may hold any
+// bytes: encoded integers, protobufs or composite keys. Keys sort by the
+// KEY_COMPARATOR of the engine, byte by byte unless another is registered.
+
+// WriteBytes writes value under key
+func (engine *Engine) WriteBytes(user string, key []byte, value []byte) error {
+	return engine.Write(user, string(key), string(value), false)
+}
+
+// ReadBytes returns the value stored under key and whether it was found
+func (engine *Engine) ReadBytes(user string, key []byte) ([]byte, bool, error) {
+	value, found, err := engine.Read(user, string(key))
+	if !found {
+		return nil, false, err
+	}
+	return []byte(value), true, err
+}
+
+// DeleteBytes writes a tombstone for key
+func (engine *Engine) DeleteBytes(user string, key []byte) error {
+	return engine.Delete(user, string(key))
+}
+
+// PutBytes adds a write of value under key
+func (batch *Batch) PutBytes(key []byte, value []byte) {
+	batch.Put(string(key), string(value))
+}
+
+// DeleteBytes adds a tombstone for key
+func (batch *Batch) DeleteBytes(key []byte) {
+	batch.Delete(string(key))
+}
+
+// RangeScanBytes is RangeScan over keys given as bytes, every entry of the
+// page holds a key and its value
+func (engine *Engine) RangeScanBytes(user string, start []byte, end []byte, pageNum int, pageSize int) [][][]byte {
+	return toBytes(engine.RangeScan(user, string(start), string(end), pageNum, pageSize))
+}
+
+// PrefixScanBytes is PrefixScan over keys given as bytes, every entry of the
+// page holds a key and its value
+func (engine *Engine) PrefixScanBytes(user string, prefix []byte, pageNum int, pageSize int) [][][]byte {
+	return toBytes(engine.PrefixScan(user, string(prefix), pageNum, pageSize))
+}
+
+// RangeIterateBytes is RangeIterate over keys given as bytes, the iterator
+// returns them as bytes through NextBytes and PrevBytes
+func (engine *Engine) RangeIterateBytes(user string, start []byte, end []byte) (*RangeIterator, error) {
+	return engine.RangeIterate(user, string(start), string(end))
+}
+
+// PrefixIterateBytes is PrefixIterate over keys given as bytes, the iterator
+// returns them as bytes through NextBytes and PrevBytes
+func (engine *Engine) PrefixIterateBytes(user string, prefix []byte) (*PrefixIterator, error) {
+	return engine.PrefixIterate(user, string(prefix))
+}
+
+// NextBytes is Next returning the key and value as bytes
+func (it *scanIterator) NextBytes() ([]byte, []byte, bool) {
+	key, value, hasNext := it.Next()
+	return []byte(key), []byte(value), hasNext
+}
+
+// PrevBytes is Prev returning the key and value as bytes
+func (it *scanIterator) PrevBytes() ([]byte, []byte, bool) {
+	key, value, hasPrev := it.Prev()
+	return []byte(key), []byte(value), hasPrev
+}
+
+// SeekBytes is Seek to a key given as bytes
+func (it *scanIterator) SeekBytes(key []byte) {
+	it.Seek(string(key))
+}
+
+// SeekForPrevBytes is SeekForPrev to a key given as bytes
+func (it *scanIterator) SeekForPrevBytes(key []byte) {
+	it.SeekForPrev(string(key))
+}
+
+func toBytes(page [][]string) [][][]byte {
+	entries := make([][][]byte, len(page))
+	for i, entry := range page {
+		entries[i] = [][]byte{[]byte(entry[0]), []byte(entry[1])}
+	}
+	return entries
+}
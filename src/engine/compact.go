@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"fmt"
+	"nosqlEngine/src/service/ss_compacter"
+)
+
+// CompactRange compacts the SSTables holding keys from start to end, both
+// included, down to the last level, so older versions and deleted keys in
+// the range stop taking space. It waits for the memtables frozen so far to be
+// flushed, runs on the compaction workers like any other compaction and
+// returns once done. Data still in the active memtable is not touched.
+func (engine *Engine) CompactRange(start string, end string) error {
+	if engine.compare(start, end) > 0 {
+		return fmt.Errorf("start %q is after end %q", start, end)
+	}
+	engine.mem_lock.Lock()
+	for frozen := engine.frozen_count; engine.flush_count < frozen; {
+		engine.flushed.Wait()
+	}
+	engine.mem_lock.Unlock()
+	return engine.compactions.CompactRange(start, end)
+}
+
+// CompactionStats returns how many compactions finished since the engine was
+// opened and how many tombstones they dropped
+func (engine *Engine) CompactionStats() ss_compacter.CompactionStats {
+	return engine.compactions.Stats()
+}
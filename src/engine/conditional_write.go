@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"nosqlEngine/src/models/key_value"
+	"nosqlEngine/src/service/retriever"
+	"time"
+)
+
+// CompareAndSwap writes newValue under key only if its current value is
+// expected. It reports whether the write happened.
+func (engine *Engine) CompareAndSwap(user string, key string, expected string, newValue string) (bool, error) {
+	return engine.writeIf(user, key_value.NewKeyValue(key, newValue), func(value string, found bool) bool {
+		return found && value == expected
+	})
+}
+
+// PutIfAbsent writes value under key only if the key does not exist or was
+// deleted. It reports whether the write happened.
+func (engine *Engine) PutIfAbsent(user string, key string, value string) (bool, error) {
+	return engine.writeIf(user, key_value.NewKeyValue(key, value), func(_ string, found bool) bool {
+		return !found
+	})
+}
+
+// DeleteIfEquals deletes key only if its current value is expected. It
+// reports whether the delete happened.
+func (engine *Engine) DeleteIfEquals(user string, key string, expected string) (bool, error) {
+	return engine.writeIf(user, key_value.NewTombstone(key), func(value string, found bool) bool {
+		return found && value == expected
+	})
+}
+
+// writeIf writes entry if cond holds for the current value of its key. The
+// check and the write happen under mem_lock, so no other write comes between.
+func (engine *Engine) writeIf(user string, entry key_value.KeyValue, cond func(value string, found bool) bool) (bool, error) {
+	if ok, err := engine.userLimiter.CheckUserTokens(user); !ok {
+		return false, fmt.Errorf("user %s is not allowed to write: %w", user, err)
+	}
+
+	engine.mem_lock.Lock()
+	if engine.failed != nil {
+		engine.mem_lock.Unlock()
+		return false, engine.failed
+	}
+	current, found, err := engine.latest(entry.GetKey())
+	if err != nil {
+		engine.mem_lock.Unlock()
+		return false, fmt.Errorf("failed to read the current value of %s: %w", entry.GetKey(), err)
+	}
+	found = found && isLive(current, time.Now())
+	if !cond(current.GetValue(), found) {
+		engine.mem_lock.Unlock()
+		return false, nil
+	}
+	ticket := engine.appendEntry(entry)
+	engine.mem_lock.Unlock()
+
+	if err := engine.commit(ticket); err != nil {
+		return false, fmt.Errorf("failed to write to WAL: %w", err)
+	}
+	return true, nil
+}
+
+// latest returns the newest version of key, which may be a tombstone. Writes
+// still waiting for the WAL count, they are ordered before any new one. The
+// caller holds mem_lock, so no write lands in between. A table that cannot be
+// read is an error, the key may still exist in it.
+func (engine *Engine) latest(key string) (key_value.KeyValue, bool, error) {
+	if entry, ok := engine.pendingEntry(key); ok {
+		return entry, true, nil
+	}
+	for _, mem := range engine.memtablesNewestFirst() {
+		if entry, ok := mem.Get(key); ok {
+			return entry, true, nil
+		}
+	}
+	engine.files_lock.RLock()
+	defer engine.files_lock.RUnlock()
+	tables, seq := engine.lookupTables(key, nil)
+	entry, found, err := retriever.NewEntryRetriever(engine.block_manager, engine.cfg).RetrieveEntryAt(key, tables, seq)
+	if errors.Is(err, retriever.ErrNotFound) {
+		return entry, false, nil
+	}
+	return entry, found, err
+}
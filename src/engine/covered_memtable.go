@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/models/key_value"
+	"nosqlEngine/src/storage/memtable"
+	"sync"
+)
+
+// coveredMemtable is a memtable together with the range of WAL sequence
+// numbers it holds. Once it is flushed, WAL segments up to lastSeq are no
+// longer needed for recovery, unless an older memtable still waits for flush.
+//
+// Snapshots read the memtable while it takes writes, so a version replaced
+// while a snapshot still sees it is kept aside for that snapshot.
+type coveredMemtable struct {
+	memtable.Memtable
+	firstSeq uint64
+	lastSeq  uint64
+	replaced map[string][]key_value.KeyValue // older versions kept for snapshots, oldest first
+}
+
+func newCoveredMemtable(cfg config.Config) *coveredMemtable {
+	return &coveredMemtable{Memtable: memtable.NewMemtable(cfg), replaced: make(map[string][]key_value.KeyValue)}
+}
+
+// add inserts entry and extends the covered range to its sequence number.
+// Entries that were not logged carry 0. The version entry replaces is kept
+// when seen reports that a live snapshot reads it.
+func (mem *coveredMemtable) add(entry key_value.KeyValue, seen func(seq uint64) bool) {
+	if old, ok := mem.Get(entry.GetKey()); ok && seen(old.GetSeq()) {
+		mem.replaced[entry.GetKey()] = append(mem.replaced[entry.GetKey()], old)
+	}
+	mem.Add(entry)
+	if entry.GetSeq() > mem.lastSeq {
+		mem.lastSeq = entry.GetSeq()
+	}
+	if entry.GetSeq() > 0 && (mem.firstSeq == 0 || entry.GetSeq() < mem.firstSeq) {
+		mem.firstSeq = entry.GetSeq()
+	}
+}
+
+// getAt returns the version of key a reader at seq sees, if the memtable
+// holds one
+func (mem *coveredMemtable) getAt(key string, seq uint64) (key_value.KeyValue, bool) {
+	entry, ok := mem.Get(key)
+	if !ok {
+		return entry, false
+	}
+	return mem.versionAt(entry, seq)
+}
+
+// versionAt returns the version a reader at seq sees of the key of entry,
+// the current one in the memtable
+func (mem *coveredMemtable) versionAt(entry key_value.KeyValue, seq uint64) (key_value.KeyValue, bool) {
+	if entry.GetSeq() <= seq {
+		return entry, true
+	}
+	versions := mem.replaced[entry.GetKey()]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].GetSeq() <= seq {
+			return versions[i], true
+		}
+	}
+	return key_value.KeyValue{}, false
+}
+
+// iterator returns a scan source over the memtable as a reader at seq sees
+// it. Each step reads the memtable under lock, since it may still take writes.
+func (mem *coveredMemtable) iterator(seq uint64, compare func(a, b string) int, lock *sync.RWMutex) *memtableIterator {
+	// hand over the plain memtable so that a sorted one is walked in order
+	return &memtableIterator{mem: mem, it: memtable.NewIterator(mem.Memtable, compare), seq: seq, lock: lock}
+}
+
+// memtableIterator skips the keys a reader at seq does not see yet and
+// returns the version it sees of the others
+type memtableIterator struct {
+	mem   *coveredMemtable
+	it    *memtable.Iterator
+	seq   uint64
+	lock  *sync.RWMutex
+	entry key_value.KeyValue
+	valid bool
+}
+
+func (it *memtableIterator) Seek(key string) {
+	it.step(true, func() { it.it.Seek(key) })
+}
+
+func (it *memtableIterator) SeekForPrev(key string) {
+	it.step(false, func() { it.it.SeekForPrev(key) })
+}
+
+func (it *memtableIterator) SeekToLast() {
+	it.step(false, it.it.SeekToLast)
+}
+
+func (it *memtableIterator) Next() {
+	it.step(true, it.it.Next)
+}
+
+func (it *memtableIterator) Prev() {
+	it.step(false, it.it.Prev)
+}
+
+func (it *memtableIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *memtableIterator) Entry() key_value.KeyValue {
+	return it.entry
+}
+
+func (it *memtableIterator) Err() error {
+	return nil
+}
+
+// step moves the memtable iterator with move and on in the given direction to
+// the first key the reader sees
+func (it *memtableIterator) step(forward bool, move func()) {
+	it.lock.RLock()
+	defer it.lock.RUnlock()
+	move()
+	for ; it.it.Valid(); it.advance(forward) {
+		if entry, ok := it.mem.versionAt(it.it.Entry(), it.seq); ok {
+			it.entry, it.valid = entry, true
+			return
+		}
+	}
+	it.valid = false
+}
+
+func (it *memtableIterator) advance(forward bool) {
+	if forward {
+		it.it.Next()
+	} else {
+		it.it.Prev()
+	}
+}
@@ -3,74 +3,336 @@ package engine
 import (
 	"fmt"
 	"nosqlEngine/src/config"
+	"nosqlEngine/src/models/key_value"
 	"nosqlEngine/src/service/block_manager"
 	"nosqlEngine/src/service/file_writer"
-	"nosqlEngine/src/service/retriever"
 	"nosqlEngine/src/service/ss_compacter"
 	"nosqlEngine/src/service/ss_parser"
+	"nosqlEngine/src/service/table_pins"
 	"nosqlEngine/src/service/user_limiter"
-	"nosqlEngine/src/storage/memtable"
+	"nosqlEngine/src/storage/manifest"
 	"nosqlEngine/src/storage/wal"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 )
 
-var CONFIG = config.GetConfig()
+// A failed flush is retried flushAttempts times in all, waiting flushBackoff
+// and then twice as long every time, before the engine stops taking writes
+const (
+	flushAttempts = 5
+	flushBackoff  = 50 * time.Millisecond
+)
+
+// comparatorFile records the KEY_COMPARATOR a data directory was created
+// with, since its SSTables are only readable in that order
+const comparatorFile = "COMPARATOR"
 
+// Engine is safe for use from many goroutines:
+//   - Write and Delete append to the WAL under mem_lock and then wait outside
+//     the lock until the WAL commits their entry as WAL_SYNC_MODE requires, so
+//     concurrent writers share one fsync. Only then is the entry added to the
+//     memtable, in WAL order, so readers never see a write that may be lost.
+//   - Read and the scans share mem_lock for the memtable part and files_lock
+//     for the SSTable part, so any number of them run in parallel.
+//   - A single background goroutine flushes frozen memtables and then hands
+//     over to the compaction scheduler, whose workers pause while a flush is
+//     in progress. New SSTables are written under a temporary name and
+//     renamed when complete, and only count once the manifest records them.
+//     Compacted tables are swapped in under files_lock, so readers only ever
+//     see whole SSTables. After a flush the WAL is
+//     checkpointed up to the newest entry that is no longer only in memory.
 type Engine struct {
-	userLimiter    *user_limiter.UserLimiter
-	memtables      []memtable.Memtable
-	curr_mem_index int
-	wal            *wal.WAL
-	ss_parser      ss_parser.SSParser
-	ss_compacter   *ss_compacter.SSCompacterST
-	entryRetriever *retriever.EntryRetriever
-	block_manager  *block_manager.BlockManager
-	flush_lock     *sync.Mutex
+	userLimiter   *user_limiter.UserLimiter
+	active        *coveredMemtable   // memtable taking new writes
+	immutables    []*coveredMemtable // frozen memtables waiting for flush, oldest first
+	pending       []pendingWrite     // logged to the WAL but not yet in the memtables, oldest first
+	snapshots     []uint64           // sequence numbers of the live snapshots
+	mem_lock      *sync.RWMutex      // guards active, immutables, pending, snapshots and the WAL append order
+	files_lock    *sync.RWMutex      // shared by SSTable readers, exclusive while tables are removed
+	flushed       *sync.Cond         // signalled on mem_lock whenever a frozen memtable is flushed
+	flush_queue   chan *coveredMemtable
+	frozen_count  uint64 // memtables handed to the flusher so far, guarded by mem_lock
+	flush_count   uint64 // flushes the flusher finished or gave up on so far, guarded by mem_lock
+	failed        error  // set once a memtable could not be flushed, writes are refused from then on, guarded by mem_lock
+	flusher_done  chan struct{}
+	wal           *wal.WAL
+	compactions   *ss_compacter.Scheduler
+	manifest      *manifest.Manifest    // the live SSTables every reader lists
+	pins          *table_pins.TablePins // SSTables held by snapshots
+	block_manager *block_manager.BlockManager
+	compare       func(a, b string) int // key order named by KEY_COMPARATOR
+	cfg           config.Config
+}
+
+// Open builds an engine whose SSTables, WAL segments and metadata all live
+// under dir, overriding cfg.DataDir.
+func Open(dir string, cfg config.Config) (*Engine, error) {
+	cfg.DataDir = dir
+	return NewEngine(cfg)
 }
 
-func NewEngine() *Engine {
-	bm := block_manager.NewBlockManager()
-	memtableCount := CONFIG.MemtableCount
-	memtables := make([]memtable.Memtable, memtableCount)
-	for i := 0; i < memtableCount; i++ {
-		memtables[i] = memtable.NewMemtable()
+// NewEngine builds an engine tuned by cfg, which is usually obtained from
+// config.LoadConfig. Invalid settings are reported as an error.
+func NewEngine(cfg config.Config) (*Engine, error) {
+	cfg = cfg.Normalized()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := createDataDirs(cfg); err != nil {
+		return nil, err
+	}
+	bm := block_manager.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	wal, err := wal.NewWAL(bm, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WAL: %w", err)
 	}
-	wal, err := wal.NewWAL(bm)
+	manifest, err := manifest.Open(bm, cfg)
 	if err != nil {
-		fmt.Println("Error creating WAL:", err)
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	mem_lock := &sync.RWMutex{}
+	files_lock := &sync.RWMutex{}
+	pins := table_pins.NewTablePins()
+	engine := &Engine{
+		userLimiter:   user_limiter.NewUserLimiter(cfg.MaxTokens, cfg.TokenRefillRate),
+		active:        newCoveredMemtable(cfg),
+		immutables:    make([]*coveredMemtable, 0, cfg.MemtableCount),
+		mem_lock:      mem_lock,
+		files_lock:    files_lock,
+		flushed:       sync.NewCond(mem_lock),
+		flush_queue:   make(chan *coveredMemtable, cfg.MemtableCount),
+		flusher_done:  make(chan struct{}),
+		compactions:   ss_compacter.NewScheduler(ss_compacter.NewCompacter(cfg, files_lock, pins, manifest), bm, cfg),
+		manifest:      manifest,
+		pins:          pins,
+		wal:           wal,
+		block_manager: bm,
+		compare:       cfg.Comparator().CompareStrings,
+		cfg:           cfg,
+	}
+	go engine.runFlusher()
+	return engine, nil
+}
+func createDataDirs(cfg config.Config) error {
+	dirs := []string{cfg.WALDir()}
+	for level := 0; level <= cfg.LSMLevels; level++ {
+		dirs = append(dirs, cfg.LevelDir(level))
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create data directory %s: %w", dir, err)
+		}
+	}
+	// tables kept for snapshots of an earlier run are not needed any more
+	if err := table_pins.RemoveRetired(dirs[1:]); err != nil {
+		return fmt.Errorf("failed to remove retired SSTables: %w", err)
+	}
+	return checkComparator(cfg)
+}
+
+// checkComparator refuses a data directory created with another comparator
+// and records the comparator of a new one
+func checkComparator(cfg config.Config) error {
+	name := cfg.KeyComparator
+	path := filepath.Join(cfg.DataDir, comparatorFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := os.WriteFile(path, []byte(name+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to record key comparator: %w", err)
+		}
 		return nil
 	}
-	return &Engine{
-		userLimiter:    user_limiter.NewUserLimiter(),
-		memtables:      memtables,
-		ss_parser:      ss_parser.NewSSParser(file_writer.NewFileWriter(bm, CONFIG.BlockSize, "")),
-		ss_compacter:   ss_compacter.NewSSCompacterST(),
-		entryRetriever: retriever.NewEntryRetriever(bm),
-		wal:            wal,
-		curr_mem_index: 0,
-		block_manager:  bm,
-		flush_lock:     &sync.Mutex{},
+	if err != nil {
+		return fmt.Errorf("failed to read key comparator: %w", err)
+	}
+	if recorded := strings.TrimSpace(string(data)); recorded != name {
+		return fmt.Errorf("data directory %s was created with key comparator %q, not %q", cfg.DataDir, recorded, name)
+	}
+	return nil
+}
+
+// freezeIfFull hands a full active memtable to the flusher and starts a fresh
+// one. When all MEMTABLE_COUNT slots are already waiting for flush it blocks
+// until one is flushed or flushing failed. The caller holds mem_lock.
+func (engine *Engine) freezeIfFull() {
+	// once flushing failed no slot frees up, the memtable grows instead
+	for engine.active.GetSize() >= engine.cfg.MemtableSize && engine.failed == nil {
+		if len(engine.immutables) < engine.cfg.MemtableCount {
+			frozen := engine.active
+			engine.immutables = append(engine.immutables, frozen)
+			engine.active = newCoveredMemtable(engine.cfg)
+			engine.flush_queue <- frozen // never blocks, the queue holds MEMTABLE_COUNT memtables
+			engine.frozen_count++
+			return
+		}
+		engine.flushed.Wait()
+	}
+}
+
+// runFlusher writes frozen memtables to SSTables in the order they were frozen.
+// A memtable stays readable until the manifest records its SSTable. Once one
+// cannot be flushed the engine fails, the memtables left are not flushed and
+// stay in the WAL for the next start.
+func (engine *Engine) runFlusher() {
+	defer close(engine.flusher_done)
+	for frozen := range engine.flush_queue {
+		engine.mem_lock.RLock()
+		failed := engine.failed
+		engine.mem_lock.RUnlock()
+		err := failed
+		if failed == nil {
+			err = engine.flushRetrying(frozen)
+		}
+
+		engine.mem_lock.Lock()
+		engine.flush_count++
+		if err != nil {
+			if engine.failed == nil {
+				engine.failed = fmt.Errorf("failed to flush memtable: %w", err)
+				fmt.Println("Error flushing memtable, the engine stops taking writes:", err)
+			}
+			// wake the writers waiting for a free slot, none comes
+			engine.flushed.Broadcast()
+			engine.mem_lock.Unlock()
+			continue
+		}
+		engine.removeImmutable(frozen)
+		checkpoint := engine.checkpointSeq(frozen)
+		engine.flushed.Broadcast()
+		engine.mem_lock.Unlock()
+
+		if checkpoint > 0 {
+			if err := engine.wal.Checkpoint(checkpoint); err != nil {
+				fmt.Println("Error checkpointing WAL:", err)
+			}
+		}
+
+		engine.compactions.Trigger()
+	}
+}
+
+// flushRetrying flushes frozen, retrying a failed flush after a growing pause
+// in case the cause passes, such as a full disk being cleaned up
+func (engine *Engine) flushRetrying(frozen *coveredMemtable) error {
+	backoff := flushBackoff
+	for attempt := 1; ; attempt++ {
+		engine.compactions.FlushStarted()
+		err := engine.flush(frozen)
+		engine.compactions.FlushDone()
+		if err == nil || attempt == flushAttempts {
+			return err
+		}
+		fmt.Printf("Error flushing memtable, retrying in %v: %v\n", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
 	}
 }
-func (engine *Engine) SetNextMemtable() {
-	engine.curr_mem_index = (engine.curr_mem_index + 1) % CONFIG.MemtableCount
+
+// flush writes a frozen memtable to a new lvl0 SSTable and records it in the
+// manifest. A table written but not recorded is deleted on the next start.
+func (engine *Engine) flush(frozen *coveredMemtable) error {
+	name := file_writer.TableName(0, engine.manifest.NextNumber())
+	fw := file_writer.NewStagedFileWriter(engine.block_manager, engine.cfg.BlockSize, engine.cfg.SSTableDir(), name)
+	path := fw.GetLocation()
+	if err := ss_parser.NewSSParser(fw, engine.cfg).FlushMemtable(frozen.ToRaw()); err != nil {
+		return err
+	}
+	meta, err := engine.manifest.Describe(path, 0)
+	if err != nil {
+		return err
+	}
+	return engine.manifest.Apply(manifest.VersionEdit{Added: []manifest.TableMeta{meta}})
 }
-func (engine *Engine) checkIfMemtableFull() bool {
-	return engine.memtables[engine.curr_mem_index].GetSize() >= CONFIG.MemtableSize
+
+// checkpointSeq returns the sequence number the WAL is covered up to once
+// flushed is, the last one before the oldest entry of the memtables still
+// waiting for flush. The caller holds mem_lock.
+func (engine *Engine) checkpointSeq(flushed *coveredMemtable) uint64 {
+	seq := flushed.lastSeq
+	for _, mem := range engine.immutables {
+		if mem.firstSeq > 0 && mem.firstSeq <= seq {
+			seq = mem.firstSeq - 1
+		}
+	}
+	return seq
 }
 
-func (engine *Engine) Start() {
-	recoveredEntries, err := wal.ReplayWAL(engine.block_manager)
+// removeImmutable drops a flushed memtable from the frozen list. Memtables that
+// failed to flush stay in it. The caller holds mem_lock.
+func (engine *Engine) removeImmutable(flushed *coveredMemtable) {
+	for i, mem := range engine.immutables {
+		if mem == flushed {
+			engine.immutables = append(engine.immutables[:i], engine.immutables[i+1:]...)
+			return
+		}
+	}
+}
+
+// memtablesNewestFirst lists the active memtable followed by the frozen ones,
+// newest first. The caller holds mem_lock for reading or writing.
+func (engine *Engine) memtablesNewestFirst() []*coveredMemtable {
+	mems := make([]*coveredMemtable, 0, len(engine.immutables)+1)
+	mems = append(mems, engine.active)
+	for i := len(engine.immutables) - 1; i >= 0; i-- {
+		mems = append(mems, engine.immutables[i])
+	}
+	return mems
+}
+
+// seenBySnapshot reports whether a live snapshot reads the version numbered
+// seq, if it is the newest one of its key. The caller holds mem_lock.
+func (engine *Engine) seenBySnapshot(seq uint64) bool {
+	for _, snap := range engine.snapshots {
+		if snap >= seq {
+			return true
+		}
+	}
+	return false
+}
+
+// Start replays the WAL into the memtables. Records dropped because of
+// corruption, as allowed by WAL_RECOVERY_MODE, are listed in the report.
+func (engine *Engine) Start() (wal.RecoveryReport, error) {
+	recoveredEntries, report, err := wal.ReplayWAL(engine.block_manager, engine.cfg)
 	if err != nil {
-		fmt.Println("Error replaying WAL:", err)
-		return
+		return report, fmt.Errorf("failed to replay WAL: %w", err)
+	}
+	for _, dropped := range report.Dropped {
+		fmt.Printf("WAL recovery dropped %d record(s) from %s at offset %d: %v\n", dropped.Count, dropped.Segment, dropped.Offset, dropped.Reason)
 	}
-	fmt.Print(recoveredEntries)
+	// keep numbering after the replayed records and remember which segment
+	// holds them, so the segments are deleted once the data is flushed
+	engine.wal.Resume(report)
 	for _, entry := range recoveredEntries {
-		engine.Write("", entry.Key, entry.Value, true)
+		kv := key_value.NewKeyValue(entry.Key, entry.Value).WithExpiry(entry.ExpiresAt)
+		if entry.Operation == "DELETE" {
+			kv = key_value.NewTombstone(entry.Key)
+		}
+		engine.mem_lock.Lock()
+		engine.apply(kv, entry.Seq)
+		engine.mem_lock.Unlock()
 	}
+	return report, nil
 }
+
+// Shut waits for frozen memtables to be flushed and for the compactions that
+// are running or due, and commits what is left in the WAL buffer. It reports
+// a failed flush. The engine must not be used afterwards.
 func (engine *Engine) Shut() error {
-	engine.wal.Flush()
-	return nil
+	close(engine.flush_queue)
+	<-engine.flusher_done
+	engine.compactions.Close()
+	if err := engine.manifest.Close(); err != nil {
+		return fmt.Errorf("failed to close manifest: %w", err)
+	}
+	if err := engine.wal.Close(); err != nil {
+		return err
+	}
+	// what could not be flushed is still in the WAL, the next start replays it
+	engine.mem_lock.RLock()
+	defer engine.mem_lock.RUnlock()
+	return engine.failed
 }
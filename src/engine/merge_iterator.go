@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"container/heap"
+	"nosqlEngine/src/models/key_value"
+)
+
+// entryIterator is one sorted source of a scan, a memtable or an SSTable
+type entryIterator interface {
+	Seek(key string)
+	SeekForPrev(key string)
+	SeekToLast()
+	Valid() bool
+	Entry() key_value.KeyValue
+	Next()
+	Prev()
+	Err() error
+}
+
+// mergeIterator merges sorted sources with a heap, holding one entry per
+// source. It walks in the direction of the last seek. For a key found in
+// several sources it returns the newest version no later than maxSeq,
+// tombstones included.
+type mergeIterator struct {
+	sources []entryIterator // newest first, ties between equal sequence numbers go to the newer source
+	heap    sourceHeap
+	maxSeq  uint64
+	err     error
+}
+
+func newMergeIterator(sources []entryIterator, compare func(a, b string) int, maxSeq uint64) *mergeIterator {
+	return &mergeIterator{sources: sources, heap: sourceHeap{sources: sources, compare: compare}, maxSeq: maxSeq}
+}
+
+// Seek positions every source at the first key >= key, Next then walks
+// towards larger keys
+func (m *mergeIterator) Seek(key string) {
+	m.position(false, func(source entryIterator) { source.Seek(key) })
+}
+
+// SeekForPrev positions every source at the last key <= key, Next then walks
+// towards smaller keys
+func (m *mergeIterator) SeekForPrev(key string) {
+	m.position(true, func(source entryIterator) { source.SeekForPrev(key) })
+}
+
+// SeekToLast positions every source at its last key, Next then walks towards
+// smaller keys
+func (m *mergeIterator) SeekToLast() {
+	m.position(true, func(source entryIterator) { source.SeekToLast() })
+}
+
+func (m *mergeIterator) position(reverse bool, seek func(source entryIterator)) {
+	m.heap.order = m.heap.order[:0]
+	m.heap.reverse = reverse
+	m.err = nil
+	for i, source := range m.sources {
+		seek(source)
+		if !m.check(source) {
+			return
+		}
+		if source.Valid() {
+			m.heap.order = append(m.heap.order, i)
+		}
+	}
+	heap.Init(&m.heap)
+}
+
+// check records the error of a source that stopped early
+func (m *mergeIterator) check(source entryIterator) bool {
+	if err := source.Err(); err != nil {
+		m.err = err
+		return false
+	}
+	return true
+}
+
+// Next returns the newest version of the next key in the direction of the
+// last seek, and false once every source is used up or one of them fails
+func (m *mergeIterator) Next() (key_value.KeyValue, bool) {
+	for m.err == nil && m.heap.Len() > 0 {
+		key := m.sources[m.heap.order[0]].Entry().GetKey()
+		var newest key_value.KeyValue
+		found := false
+		// the heap yields the sources holding key newest first
+		for m.heap.Len() > 0 && m.heap.compare(m.sources[m.heap.order[0]].Entry().GetKey(), key) == 0 {
+			i := m.heap.order[0]
+			if entry := m.sources[i].Entry(); entry.GetSeq() <= m.maxSeq && (!found || entry.IsNewerThan(newest)) {
+				newest, found = entry, true
+			}
+			if m.heap.reverse {
+				m.sources[i].Prev()
+			} else {
+				m.sources[i].Next()
+			}
+			if !m.check(m.sources[i]) {
+				return key_value.KeyValue{}, false
+			}
+			if m.sources[i].Valid() {
+				heap.Fix(&m.heap, 0)
+			} else {
+				heap.Pop(&m.heap)
+			}
+		}
+		if found {
+			return newest, true
+		}
+	}
+	return key_value.KeyValue{}, false
+}
+
+// Err returns the error of the source that made the merge stop early, if any
+func (m *mergeIterator) Err() error {
+	return m.err
+}
+
+// sourceHeap orders the positioned sources by their current key, largest
+// first when reverse, and sources on the same key newest first
+type sourceHeap struct {
+	sources []entryIterator
+	order   []int // indexes into sources
+	compare func(a, b string) int
+	reverse bool
+}
+
+func (h *sourceHeap) Len() int { return len(h.order) }
+
+func (h *sourceHeap) Less(i, j int) bool {
+	cmp := h.compare(h.sources[h.order[i]].Entry().GetKey(), h.sources[h.order[j]].Entry().GetKey())
+	if cmp != 0 {
+		return (cmp < 0) != h.reverse
+	}
+	return h.order[i] < h.order[j]
+}
+
+func (h *sourceHeap) Swap(i, j int) { h.order[i], h.order[j] = h.order[j], h.order[i] }
+
+func (h *sourceHeap) Push(x any) { h.order = append(h.order, x.(int)) }
+
+func (h *sourceHeap) Pop() any {
+	last := h.order[len(h.order)-1]
+	h.order = h.order[:len(h.order)-1]
+	return last
+}
@@ -2,109 +2,72 @@ package engine
 
 import (
 	"fmt"
-	"nosqlEngine/src/service/retriever"
-	"sort"
+	"nosqlEngine/src/models/comparator"
+	"strings"
 )
 
+// PrefixIterator streams the live keys starting with a prefix in key order,
+// or from the last one down in reverse scans
 type PrefixIterator struct {
-	data    [][]string
-	index   int
-	stopped bool
+	*scanIterator
 }
 
-func NewPrefixIterator(results map[string]string) *PrefixIterator {
-	iterator_data := SortKeysAndVals(results)
-	return &PrefixIterator{data: iterator_data, index: 0, stopped: false}
-}
-
-func (pi *PrefixIterator) Next() (string, string, bool) {
-	if pi.stopped || pi.index >= len(pi.data) {
-		return "", "", false
-	}
-
-	key := pi.data[pi.index][0]
-	value := pi.data[pi.index][1]
-	pi.index++
-
-	hasNext := pi.index < len(pi.data)
-	return key, value, hasNext
-}
-
-func (pi *PrefixIterator) Stop() {
-	pi.stopped = true
-}
-
-func (pi *PrefixIterator) Reset() {
-	pi.index = 0
-	pi.stopped = false
-}
-
-func (pi *PrefixIterator) HasNext() bool {
-	return !pi.stopped && pi.index < len(pi.data)
+func (engine *Engine) PrefixIterate(user string, prefix string) (*PrefixIterator, error) {
+	return engine.PrefixIterateWithOptions(user, prefix, ReadOptions{})
 }
 
-func (engine *Engine) PrefixIterate(user string, prefix string) (*PrefixIterator, error) {
+// PrefixIterateWithOptions is PrefixIterate with options, such as a snapshot to read from
+func (engine *Engine) PrefixIterateWithOptions(user string, prefix string, opts ReadOptions) (*PrefixIterator, error) {
 	if ok, err := engine.userLimiter.CheckUserTokens(user); !ok {
 		return nil, fmt.Errorf("user %s is not allowed to read: %w", user, err)
 	}
-	results, err := engine.findAllPrefixMatches(prefix)
+	it, err := engine.prefixScan(prefix, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find prefix matches: %w", err)
 	}
-	return NewPrefixIterator(results), nil
-}
-
-func SortKeysAndVals(data map[string]string) [][]string {
-	result_array := make([][]string, 0)
-	for key, value := range data {
-		tmp := []string{key, value}
-		result_array = append(result_array, tmp)
-	}
-
-	sort.Slice(result_array, func(i, j int) bool {
-		if len(result_array[i]) <= 1 || len(result_array[j]) <= 1 {
-			return len(result_array[i]) < len(result_array[j])
-		}
-		return result_array[i][0] < result_array[j][0]
-	})
-	return result_array
+	return &PrefixIterator{it}, nil
 }
 
 func (engine *Engine) PrefixScan(user string, prefix string, pageNum int, pageSize int) [][]string {
-	results, _ := engine.findAllPrefixMatches(prefix)
-
-	sorted := SortKeysAndVals(results)
-	return sorted[min(len(sorted), (pageNum-1)*pageSize):min(len(sorted), pageNum*pageSize)]
+	return engine.PrefixScanWithOptions(user, prefix, pageNum, pageSize, ReadOptions{})
 }
 
-func (engine *Engine) findAllPrefixMatches(prefix string) (map[string]string, error) {
-	results := make(map[string]string)
-
-	// Scan through memtables
-	for _, mem := range engine.memtables {
-		for _, kv := range mem.ToRaw() {
-			fmt.Println(kv.GetKey(), kv.GetValue())
-			if len(kv.GetKey()) >= len(prefix) && kv.GetKey()[:len(prefix)] == prefix {
-				results[kv.GetKey()] = kv.GetValue()
-			}
-		}
+// PrefixScanWithOptions is PrefixScan with options, such as a snapshot to read from
+func (engine *Engine) PrefixScanWithOptions(user string, prefix string, pageNum int, pageSize int, opts ReadOptions) [][]string {
+	it, err := engine.prefixScan(prefix, opts)
+	if err != nil {
+		return [][]string{}
 	}
+	return it.page(pageNum, pageSize)
+}
 
-	// If not found in memtables, read from SSTables
-
-	mretriever := retriever.NewMultiRetriever(engine.block_manager)
-
-	retriever_results, err := mretriever.GetPrefixEntries(prefix)
-	fmt.Print(retriever_results, results)
+// prefixScan seeks to prefix, the keys starting with it sort right after it
+func (engine *Engine) prefixScan(prefix string, opts ReadOptions) (*scanIterator, error) {
+	return engine.newScanIterator(engine.prefixBounds(prefix, opts.Scan), opts)
+}
 
-	if err != nil {
-		fmt.Print("Failed to retrieve results from SSTables")
+// prefixBounds covers the keys starting with prefix. In byte order they all
+// sort before the successor of prefix, the shortest key larger than every one
+// of them, other comparators start reverse scans from the last key.
+func (engine *Engine) prefixBounds(prefix string, scan ScanOptions) scanBounds {
+	bounds := scanBounds{lower: prefix, excludeLower: scan.ExcludeStart, contains: hasPrefix(prefix)}
+	if engine.cfg.KeyComparator != comparator.Bytewise {
+		return bounds
+	}
+	succ := []byte(prefix)
+	for len(succ) > 0 && succ[len(succ)-1] == 0xff {
+		succ = succ[:len(succ)-1]
 	}
+	if len(succ) > 0 {
+		succ[len(succ)-1]++
+		bounds.upper, bounds.hasUpper = string(succ), true
+	}
+	return bounds
+}
 
-	for key, value := range retriever_results {
-		if _, exists := results[key]; !exists {
-			results[key] = value
-		}
+// hasPrefix reports whether a key belongs to the scan of prefix
+func hasPrefix(prefix string) func(key string) bool {
+	return func(key string) bool {
+		return strings.HasPrefix(key, prefix)
 	}
-	return results, nil
-}
\ No newline at end of file
+}
@@ -2,92 +2,59 @@ package engine
 
 import (
 	"fmt"
-	"nosqlEngine/src/service/retriever"
 )
 
+// RangeIterator streams the live keys between two bounds in key order, or
+// from the last one down in reverse scans
 type RangeIterator struct {
-	data    [][]string
-	index   int
-	stopped bool
+	*scanIterator
 }
 
-func NewRangeIterator(results map[string]string) *RangeIterator {
-	iterator_data := SortKeysAndVals(results)
-	return &RangeIterator{data: iterator_data, index: 0, stopped: false}
-}
-
-func (ri *RangeIterator) Next() (string, string, bool) {
-	if ri.stopped || ri.index >= len(ri.data) {
-		return "", "", false
-	}
-
-	key := ri.data[ri.index][0]
-	value := ri.data[ri.index][1]
-	ri.index++
-
-	hasNext := ri.index < len(ri.data)
-	return key, value, hasNext
-}
-
-func (ri *RangeIterator) Stop() {
-	ri.stopped = true
-}
-
-func (ri *RangeIterator) Reset() {
-	ri.index = 0
-	ri.stopped = false
-}
-
-func (ri *RangeIterator) HasNext() bool {
-	return !ri.stopped && ri.index < len(ri.data)
+func (engine *Engine) RangeIterate(user string, start string, end string) (*RangeIterator, error) {
+	return engine.RangeIterateWithOptions(user, start, end, ReadOptions{})
 }
 
-func (engine *Engine) RangeIterate(user string, start string, end string) (*RangeIterator, error) {
+// RangeIterateWithOptions is RangeIterate with options, such as a snapshot to read from
+func (engine *Engine) RangeIterateWithOptions(user string, start string, end string, opts ReadOptions) (*RangeIterator, error) {
 	if ok, err := engine.userLimiter.CheckUserTokens(user); !ok {
 		return nil, fmt.Errorf("user %s is not allowed to read: %w", user, err)
 	}
-	results, err := engine.findAllRangeMatches(start, end)
+	it, err := engine.rangeScan(start, end, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find range matches: %w", err)
 	}
-	return NewRangeIterator(results), nil
+	return &RangeIterator{it}, nil
 }
 
 func (engine *Engine) RangeScan(user string, start string, end string, pageNum int, pageSize int) [][]string {
-	results, _ := engine.findAllRangeMatches(start, end)
-
-	sorted := SortKeysAndVals(results)
-	return sorted[min(len(sorted), (pageNum-1)*pageSize):min(len(sorted), pageNum*pageSize)]
+	return engine.RangeScanWithOptions(user, start, end, pageNum, pageSize, ReadOptions{})
 }
 
-func (engine *Engine) findAllRangeMatches(start string, end string) (map[string]string, error) {
-	results := make(map[string]string)
-
-	// Scan through memtables
-	for _, mem := range engine.memtables {
-		for _, kv := range mem.ToRaw() {
-			fmt.Println(kv.GetKey(), kv.GetValue())
-			if kv.GetKey() >= start && kv.GetKey() <= end {
-				results[kv.GetKey()] = kv.GetValue()
-			}
-		}
-	}
-
-	// If not found in memtables, read from SSTables
-
-	mretriever := retriever.NewMultiRetriever(engine.block_manager)
-
-	retriever_results, err := mretriever.GetRangeEntries(start, end)
-	fmt.Print(retriever_results, results)
-
+// RangeScanWithOptions is RangeScan with options, such as a snapshot to read from
+func (engine *Engine) RangeScanWithOptions(user string, start string, end string, pageNum int, pageSize int, opts ReadOptions) [][]string {
+	it, err := engine.rangeScan(start, end, opts)
 	if err != nil {
-		fmt.Print("Failed to retrieve results from SSTables")
+		return [][]string{}
 	}
+	return it.page(pageNum, pageSize)
+}
 
-	for key, value := range retriever_results {
-		if _, exists := results[key]; !exists {
-			results[key] = value
-		}
+// rangeScan covers the keys from start to end, both included unless
+// opts.Scan excludes them
+func (engine *Engine) rangeScan(start string, end string, opts ReadOptions) (*scanIterator, error) {
+	return engine.newScanIterator(engine.rangeBounds(start, end, opts.Scan), opts)
+}
+
+func (engine *Engine) rangeBounds(start string, end string, scan ScanOptions) scanBounds {
+	bounds := scanBounds{lower: start, excludeLower: scan.ExcludeStart, contains: func(key string) bool { return true }}
+	switch {
+	case scan.OpenEnd:
+	case scan.ExcludeEnd:
+		bounds.upper, bounds.hasUpper = end, true
+		bounds.contains = func(key string) bool { return engine.compare(key, end) < 0 }
+	default:
+		bounds.upper, bounds.hasUpper = end, true
+		bounds.contains = func(key string) bool { return engine.compare(key, end) <= 0 }
 	}
-	return results, nil
-}
\ No newline at end of file
+	return bounds
+}
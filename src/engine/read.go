@@ -1,19 +1,56 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
+	"nosqlEngine/src/models/key_value"
+	"nosqlEngine/src/service/retriever"
+	"time"
 )
 
 func (engine *Engine) Read(user string, key string) (string, bool, error) {
-	// Read from memtables
+	return engine.ReadWithOptions(user, key, ReadOptions{})
+}
+
+// ReadWithOptions is Read with options, such as a snapshot to read from
+func (engine *Engine) ReadWithOptions(user string, key string, opts ReadOptions) (string, bool, error) {
 	if ok, err := engine.userLimiter.CheckUserTokens(user); !ok {
 		return "", false, fmt.Errorf("user %s is not allowed to read: %w", user, err)
 	}
-	for _, mem := range engine.memtables {
-		if value, ok := mem.Get(key); ok {
-			// Found in memtable, return value
-			return value, true, nil
+	if err := opts.validate(); err != nil {
+		return "", false, err
+	}
+	// Read from memtables
+	engine.mem_lock.RLock()
+	mems, seq := engine.readMemtables(opts.Snapshot)
+	for _, mem := range mems {
+		if entry, ok := mem.getAt(key, seq); ok {
+			engine.mem_lock.RUnlock()
+			// Found in memtable, a tombstone means the key was deleted
+			if !isLive(entry, time.Now()) {
+				return "", false, nil
+			}
+			return entry.GetValue(), true, nil
 		}
 	}
-	return engine.entryRetriever.RetrieveEntry(key)
+	engine.mem_lock.RUnlock()
+
+	// a retriever keeps its position between SSTables, so each lookup gets its own
+	engine.files_lock.RLock()
+	defer engine.files_lock.RUnlock()
+	tables, seq := engine.lookupTables(key, opts.Snapshot)
+	entry, found, err := retriever.NewEntryRetriever(engine.block_manager, engine.cfg).RetrieveEntryAt(key, tables, seq)
+	if err != nil && !errors.Is(err, retriever.ErrNotFound) {
+		return "", false, err
+	}
+	if !found || !isLive(entry, time.Now()) {
+		return "", false, nil
+	}
+	return entry.GetValue(), true, nil
+}
+
+// isLive reports whether the newest version of a key still holds a value: it
+// is neither a tombstone nor expired at now
+func isLive(entry key_value.KeyValue, now time.Time) bool {
+	return !entry.IsTombstone() && !entry.IsExpired(now)
 }
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// ScanPage is one page of a paginated scan
+type ScanPage struct {
+	Entries [][]string // key and value pairs in the order of the scan
+	Token   string     // resumes the scan after the last entry, empty once the scan is complete
+}
+
+// cursorVersion is the first byte of every continuation token
+const cursorVersion = 1
+
+// cursor is the position a continuation token resumes a scan from
+type cursor struct {
+	after string // last key returned
+	seq   uint64 // sequence number of the snapshot the scan reads, 0 without one
+}
+
+// encode packs the cursor into an opaque token: the version, the snapshot
+// sequence number as a uvarint and the key
+func (c cursor) encode() string {
+	buf := binary.AppendUvarint([]byte{cursorVersion}, c.seq)
+	return base64.RawURLEncoding.EncodeToString(append(buf, c.after...))
+}
+
+func decodeCursor(token string) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) == 0 || data[0] != cursorVersion {
+		return cursor{}, fmt.Errorf("invalid continuation token")
+	}
+	seq, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return cursor{}, fmt.Errorf("invalid continuation token")
+	}
+	return cursor{after: string(data[1+n:]), seq: seq}, nil
+}
+
+// snapshotSeq identifies the snapshot a read uses in its tokens
+func (opts ReadOptions) snapshotSeq() uint64 {
+	if opts.Snapshot == nil {
+		return 0
+	}
+	return opts.Snapshot.Seq()
+}
+
+// RangeScanPage returns up to pageSize live entries from start to end, both
+// included. An empty token starts at start, the token of the previous page
+// continues right after its last key without reading the pages before it.
+func (engine *Engine) RangeScanPage(user string, start string, end string, token string, pageSize int) (ScanPage, error) {
+	return engine.RangeScanPageWithOptions(user, start, end, token, pageSize, ReadOptions{})
+}
+
+// RangeScanPageWithOptions is RangeScanPage with options. Pages read from a
+// snapshot only continue with that snapshot, so they all show the same data.
+// Reverse pages start at end and continue towards start, the limits of
+// opts.Scan apply to each page.
+func (engine *Engine) RangeScanPageWithOptions(user string, start string, end string, token string, pageSize int, opts ReadOptions) (ScanPage, error) {
+	return engine.scanPage(user, engine.rangeBounds(start, end, opts.Scan), token, pageSize, opts)
+}
+
+// PrefixScanPage is RangeScanPage over the keys starting with prefix
+func (engine *Engine) PrefixScanPage(user string, prefix string, token string, pageSize int) (ScanPage, error) {
+	return engine.PrefixScanPageWithOptions(user, prefix, token, pageSize, ReadOptions{})
+}
+
+// PrefixScanPageWithOptions is PrefixScanPage with options, such as a snapshot to read from
+func (engine *Engine) PrefixScanPageWithOptions(user string, prefix string, token string, pageSize int, opts ReadOptions) (ScanPage, error) {
+	return engine.scanPage(user, engine.prefixBounds(prefix, opts.Scan), token, pageSize, opts)
+}
+
+// scanPage reads the page of bounds that token points to
+func (engine *Engine) scanPage(user string, bounds scanBounds, token string, pageSize int, opts ReadOptions) (ScanPage, error) {
+	if ok, err := engine.userLimiter.CheckUserTokens(user); !ok {
+		return ScanPage{}, fmt.Errorf("user %s is not allowed to read: %w", user, err)
+	}
+	if pageSize < 1 {
+		return ScanPage{}, fmt.Errorf("page size must be at least 1, got %d", pageSize)
+	}
+	var resume *cursor
+	if token != "" {
+		c, err := decodeCursor(token)
+		if err != nil {
+			return ScanPage{}, err
+		}
+		if c.seq != opts.snapshotSeq() {
+			return ScanPage{}, fmt.Errorf("continuation token belongs to a scan of another snapshot")
+		}
+		resume = &c
+	}
+	it, err := engine.newScanIterator(bounds, opts)
+	if err != nil {
+		return ScanPage{}, err
+	}
+	defer it.Stop()
+	if resume != nil {
+		if opts.Reverse {
+			it.SeekForPrev(resume.after)
+		} else {
+			it.Seek(resume.after)
+		}
+		if it.HasNext() && engine.compare(it.next.GetKey(), resume.after) == 0 {
+			it.Next()
+		}
+		// the limits of opts.Scan apply to each page
+		it.items, it.bytes = 0, 0
+	}
+	page := ScanPage{Entries: [][]string{}}
+	for len(page.Entries) < pageSize && it.HasNext() {
+		key, value, _ := it.Next()
+		page.Entries = append(page.Entries, []string{key, value})
+	}
+	if it.Err() != nil {
+		return ScanPage{}, it.Err()
+	}
+	if it.hasNext && len(page.Entries) == 0 {
+		return ScanPage{}, fmt.Errorf("entry %q does not fit in a page of %d bytes", it.next.GetKey(), opts.Scan.MaxBytes)
+	}
+	if it.hasNext {
+		last := page.Entries[len(page.Entries)-1][0]
+		page.Token = cursor{after: last, seq: opts.snapshotSeq()}.encode()
+	}
+	return page, nil
+}
@@ -0,0 +1,289 @@
+package engine
+
+import (
+	"fmt"
+	"nosqlEngine/src/models/key_value"
+	"nosqlEngine/src/service/retriever"
+	"nosqlEngine/src/storage/manifest"
+	"time"
+)
+
+// scanBounds are the keys a scan covers
+type scanBounds struct {
+	lower        string                // smallest key of the scan
+	excludeLower bool                  // lower itself is not part of the scan
+	upper        string                // key at or after the largest key of the scan, reverse scans start there
+	hasUpper     bool                  // false when the scan runs to the last key
+	contains     func(key string) bool // reports whether a key past lower belongs to the scan
+}
+
+// below reports whether key sorts before the first key of the scan
+func (bounds scanBounds) below(key string, compare func(a, b string) int) bool {
+	cmp := compare(key, bounds.lower)
+	return cmp < 0 || (cmp == 0 && bounds.excludeLower)
+}
+
+// scanIterator streams the live entries of a scan in key order, or from the
+// largest key down in reverse scans. It merges the memtables and SSTables of a
+// snapshot, keeping one entry per source and at most one block per SSTable in
+// memory, and sees no write made after it was created.
+//
+// The iterator sits between two entries: Next returns the one after it and
+// moves past it, Prev returns the one before it and moves back, both in the
+// direction of the scan. The limits of the scan count what Next returned since
+// it started or was Reset, once one is reached the scan reads no further.
+type scanIterator struct {
+	engine   *Engine
+	snap     *Snapshot
+	own      bool // snap was taken for the iterator and is released by Stop
+	bounds   scanBounds
+	reverse  bool
+	limits   ScanOptions
+	items    int // entries Next returned since the scan started
+	bytes    int // size of their keys and values
+	merge    *mergeIterator
+	next     key_value.KeyValue
+	hasNext  bool // next holds an entry, which the limits may still keep Next from returning
+	detached bool // merge is not positioned right after next, it is sought again before moving on
+	stopped  bool
+	err      error
+}
+
+// newScanIterator starts a scan at the first key of bounds, or the last in
+// reverse. Without a snapshot in opts it pins the current state of the engine
+// until Stop is called.
+func (engine *Engine) newScanIterator(bounds scanBounds, opts ReadOptions) (*scanIterator, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	it := &scanIterator{engine: engine, snap: opts.Snapshot, bounds: bounds, reverse: opts.Reverse, limits: opts.Scan}
+	if err := it.open(); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// open builds the sources of the scan and moves to its first entry
+func (it *scanIterator) open() error {
+	engine := it.engine
+	if it.snap == nil || it.own {
+		it.snap, it.own = engine.Snapshot(), true
+	} else if err := (ReadOptions{Snapshot: it.snap}).validate(); err != nil {
+		return err
+	}
+	sources := make([]entryIterator, 0, len(it.snap.memtables)+len(it.snap.tables))
+	for _, mem := range it.snap.memtables {
+		sources = append(sources, mem.iterator(it.snap.seq, engine.compare, engine.mem_lock))
+	}
+	engine.files_lock.RLock()
+	defer engine.files_lock.RUnlock()
+	// the merge breaks ties between equal sequence numbers towards the newer
+	// source, so the tables follow the memtables newest first
+	tables := manifest.ReadOrder(append([]manifest.TableMeta(nil), it.snap.tables...))
+	for _, table := range engine.manifest.PathsOf(tables) {
+		source, err := retriever.NewTableIterator(engine.block_manager, engine.cfg, table, engine.pins.Resolve)
+		if err != nil {
+			if it.own {
+				// Release waits for files_lock, which is held until we return
+				defer it.snap.Release()
+			}
+			return fmt.Errorf("failed to open scan: %w", err)
+		}
+		sources = append(sources, source)
+	}
+	it.merge = newMergeIterator(sources, engine.compare, it.snap.seq)
+	it.stopped, it.err = false, nil
+	it.items, it.bytes = 0, 0
+	it.seekEdge(!it.reverse)
+	it.next, it.hasNext = it.walk(!it.reverse, nil)
+	it.detached = false
+	return nil
+}
+
+// seekEdge positions the merge at the end of the scan a walk in the given
+// direction starts from
+func (it *scanIterator) seekEdge(ascending bool) {
+	switch {
+	case ascending:
+		it.merge.Seek(it.bounds.lower)
+	case it.bounds.hasUpper:
+		it.merge.SeekForPrev(it.bounds.upper)
+	default:
+		it.merge.SeekToLast()
+	}
+}
+
+// seek positions the merge at key for a walk in the given direction, keys
+// outside the scan are moved to its nearest end
+func (it *scanIterator) seek(key string, ascending bool) {
+	compare := it.engine.compare
+	switch {
+	case ascending && compare(key, it.bounds.lower) < 0:
+		it.seekEdge(true)
+	case ascending:
+		it.merge.Seek(key)
+	case it.bounds.hasUpper && compare(key, it.bounds.upper) > 0:
+		it.seekEdge(false)
+	default:
+		it.merge.SeekForPrev(key)
+	}
+}
+
+// walk returns the next live entry of the scan the merge meets in the given
+// direction, passing over the entry of key skip. The caller holds files_lock
+// for reading, since SSTable blocks are read on the way.
+func (it *scanIterator) walk(ascending bool, skip *string) (key_value.KeyValue, bool) {
+	now := time.Now()
+	for {
+		entry, ok := it.merge.Next()
+		if !ok {
+			if it.err == nil {
+				it.err = it.merge.Err()
+			}
+			return key_value.KeyValue{}, false
+		}
+		key := entry.GetKey()
+		if skip != nil && it.engine.compare(key, *skip) == 0 {
+			continue
+		}
+		below := it.bounds.below(key, it.engine.compare)
+		inScan := !below && it.bounds.contains(key)
+		if !inScan {
+			// walking up the scan ends past its last key, walking down below its first
+			if ascending != below {
+				return key_value.KeyValue{}, false
+			}
+			continue
+		}
+		if isLive(entry, now) {
+			return entry, true
+		}
+	}
+}
+
+// withinLimits reports whether Next may still return entry
+func (it *scanIterator) withinLimits(entry key_value.KeyValue) bool {
+	if it.limits.MaxItems > 0 && it.items >= it.limits.MaxItems {
+		return false
+	}
+	size := len(entry.GetKey()) + len(entry.GetValue())
+	return it.limits.MaxBytes == 0 || it.bytes+size <= it.limits.MaxBytes
+}
+
+// Next returns the entry after the iterator and moves past it. hasNext
+// reports whether another entry follows, an iterator at the end of the scan
+// or at one of its limits returns empty strings and false.
+func (it *scanIterator) Next() (string, string, bool) {
+	if !it.HasNext() {
+		return "", "", false
+	}
+	current := it.next
+	it.items++
+	it.bytes += len(current.GetKey()) + len(current.GetValue())
+	it.engine.files_lock.RLock()
+	defer it.engine.files_lock.RUnlock()
+	if it.detached {
+		key := current.GetKey()
+		it.seek(key, !it.reverse)
+		it.next, it.hasNext = it.walk(!it.reverse, &key)
+		it.detached = false
+	} else {
+		it.next, it.hasNext = it.walk(!it.reverse, nil)
+	}
+	return current.GetKey(), current.GetValue(), it.HasNext()
+}
+
+// Prev returns the entry before the iterator and moves back before it, so the
+// following Next returns it again. hasPrev reports whether another entry
+// precedes it, an iterator at the start of the scan returns empty strings and
+// false.
+func (it *scanIterator) Prev() (string, string, bool) {
+	if it.stopped {
+		return "", "", false
+	}
+	it.engine.files_lock.RLock()
+	defer it.engine.files_lock.RUnlock()
+	var prev key_value.KeyValue
+	found := false
+	if it.hasNext {
+		key := it.next.GetKey()
+		it.seek(key, it.reverse)
+		prev, found = it.walk(it.reverse, &key)
+	} else {
+		it.seekEdge(it.reverse)
+		prev, found = it.walk(it.reverse, nil)
+	}
+	// the merge has moved away from next either way
+	it.detached = true
+	if !found {
+		return "", "", false
+	}
+	_, hasPrev := it.walk(it.reverse, nil)
+	it.next, it.hasNext = prev, true
+	return prev.GetKey(), prev.GetValue(), hasPrev
+}
+
+// Seek moves the iterator before the first key >= key of the scan
+func (it *scanIterator) Seek(key string) {
+	it.position(key, true)
+}
+
+// SeekForPrev moves the iterator before the last key <= key of the scan
+func (it *scanIterator) SeekForPrev(key string) {
+	it.position(key, false)
+}
+
+func (it *scanIterator) position(key string, ascending bool) {
+	if it.stopped {
+		return
+	}
+	it.engine.files_lock.RLock()
+	defer it.engine.files_lock.RUnlock()
+	it.seek(key, ascending)
+	it.next, it.hasNext = it.walk(ascending, nil)
+	// the merge is left after next when it walked the way of the scan
+	it.detached = ascending == it.reverse
+}
+
+// Stop ends the scan and releases what it holds
+func (it *scanIterator) Stop() {
+	if it.own {
+		it.snap.Release()
+	}
+	it.stopped, it.hasNext = true, false
+}
+
+// Reset starts the scan over. An iterator that took its own snapshot reads the
+// current state of the engine again.
+func (it *scanIterator) Reset() {
+	if it.own {
+		it.snap.Release()
+	}
+	if err := it.open(); err != nil {
+		it.stopped, it.hasNext, it.err = true, false, err
+	}
+}
+
+func (it *scanIterator) HasNext() bool {
+	return !it.stopped && it.hasNext && it.withinLimits(it.next)
+}
+
+// Err returns the error that ended the scan early, nil when it ran to its end
+func (it *scanIterator) Err() error {
+	return it.err
+}
+
+// page skips to the pageNum-th page of pageSize entries, returns it as key and
+// value pairs and stops the iterator
+func (it *scanIterator) page(pageNum int, pageSize int) [][]string {
+	defer it.Stop()
+	for skip := (pageNum - 1) * pageSize; skip > 0 && it.HasNext(); skip-- {
+		it.Next()
+	}
+	page := [][]string{}
+	for len(page) < pageSize && it.HasNext() {
+		key, value, _ := it.Next()
+		page = append(page, []string{key, value})
+	}
+	return page
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"nosqlEngine/src/storage/manifest"
+	"sync/atomic"
+)
+
+// Snapshot is a read-only view of the engine as of one sequence number. It
+// keeps the memtables and SSTables it reads from until Release is called, so
+// later writes, flushes and compactions do not change what it sees.
+type Snapshot struct {
+	engine    *Engine
+	seq       uint64               // newest write the snapshot sees
+	memtables []*coveredMemtable   // newest first, read as of seq
+	tables    []manifest.TableMeta // SSTables pinned for the snapshot
+	released  atomic.Bool
+}
+
+// ReadOptions tune a read, the zero value reads the latest data
+type ReadOptions struct {
+	// Snapshot makes the read see the engine as it was when the snapshot was taken
+	Snapshot *Snapshot
+	// Reverse makes scans return keys from the largest to the smallest, point reads ignore it
+	Reverse bool
+	// Scan bounds and limits the keys scans return, point reads ignore it
+	Scan ScanOptions
+}
+
+// ScanOptions bound and limit a scan, the zero value returns every key from
+// start to end, both included. The prefix of a prefix scan is its start, it
+// has no end.
+type ScanOptions struct {
+	ExcludeStart bool // start itself is not returned
+	ExcludeEnd   bool // end itself is not returned
+	OpenEnd      bool // the scan runs to the last key, end is ignored
+	MaxItems     int  // the scan stops after this many entries, 0 means no limit
+	MaxBytes     int  // the scan stops before its keys and values would exceed this many bytes, 0 means no limit
+}
+
+// Snapshot pins the current state of the engine. The snapshot must be
+// released once it is no longer used, or the SSTables it holds stay on disk.
+func (engine *Engine) Snapshot() *Snapshot {
+	engine.mem_lock.Lock()
+	defer engine.mem_lock.Unlock()
+
+	// the active memtable keeps taking writes, the snapshot skips the newer
+	// ones and the memtable keeps the versions they replace
+	seq := engine.visibleSeq()
+	engine.snapshots = append(engine.snapshots, seq)
+	mems := engine.memtablesNewestFirst()
+
+	// no memtable leaves the list while mem_lock is held, so every entry is in
+	// the memtables above or in an SSTable listed here
+	engine.files_lock.RLock()
+	tables := engine.manifest.Tables()
+	engine.pins.Pin(engine.manifest.PathsOf(tables))
+	engine.files_lock.RUnlock()
+
+	return &Snapshot{engine: engine, seq: seq, memtables: mems, tables: tables}
+}
+
+// Seq returns the sequence number of the newest write the snapshot sees
+func (snap *Snapshot) Seq() uint64 {
+	return snap.seq
+}
+
+// Release unpins the SSTables of the snapshot. Reads with a released snapshot
+// fail, releasing it twice does nothing.
+func (snap *Snapshot) Release() {
+	if snap.released.Swap(true) {
+		return
+	}
+	snap.engine.mem_lock.Lock()
+	for i, seq := range snap.engine.snapshots {
+		if seq == snap.seq {
+			snap.engine.snapshots = append(snap.engine.snapshots[:i], snap.engine.snapshots[i+1:]...)
+			break
+		}
+	}
+	snap.engine.mem_lock.Unlock()
+	// tables compacted away meanwhile are deleted here, wait for their readers
+	snap.engine.files_lock.Lock()
+	snap.engine.pins.Unpin(snap.engine.manifest.PathsOf(snap.tables))
+	snap.engine.files_lock.Unlock()
+}
+
+func (opts ReadOptions) validate() error {
+	if opts.Snapshot != nil && opts.Snapshot.released.Load() {
+		return fmt.Errorf("snapshot was released")
+	}
+	if opts.Scan.MaxItems < 0 || opts.Scan.MaxBytes < 0 {
+		return fmt.Errorf("scan limits must not be negative, got %d items and %d bytes", opts.Scan.MaxItems, opts.Scan.MaxBytes)
+	}
+	return nil
+}
+
+// readMemtables returns the memtables a read sees, newest first, and the
+// newest sequence number it may return from them. The caller holds mem_lock
+// for reading, since the memtables may still take writes.
+func (engine *Engine) readMemtables(snap *Snapshot) ([]*coveredMemtable, uint64) {
+	if snap != nil {
+		return snap.memtables, snap.seq
+	}
+	return engine.memtablesNewestFirst(), math.MaxUint64
+}
+
+// lookupTables returns the SSTables that may hold key in the order a lookup
+// reads them, and the newest sequence number it may return. The caller holds
+// files_lock for reading.
+func (engine *Engine) lookupTables(key string, snap *Snapshot) ([]string, uint64) {
+	if snap == nil {
+		return engine.manifest.PathsOf(manifest.SearchOrder(engine.manifest.Tables(), key, engine.compare)), math.MaxUint64
+	}
+	tables := engine.manifest.PathsOf(manifest.SearchOrder(snap.tables, key, engine.compare))
+	for i, table := range tables {
+		tables[i] = engine.pins.Resolve(table)
+	}
+	return tables, snap.seq
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"nosqlEngine/src/models/key_value"
+)
+
+// ErrTxnConflict is returned by Txn.Commit when a key the transaction read was
+// written by someone else meanwhile. Nothing was written, the transaction can
+// be retried from BeginTxn.
+var ErrTxnConflict = errors.New("transaction conflict")
+
+// Txn is an optimistic transaction. It reads from a snapshot taken when it
+// began and buffers its writes, which Commit applies as one atomic batch if
+// none of the keys it read changed since. A Txn is not safe for concurrent use.
+type Txn struct {
+	engine *Engine
+	user   string
+	snap   *Snapshot
+	reads  map[string]struct{}           // keys read from the engine
+	writes map[string]key_value.KeyValue // latest buffered write of each key
+	batch  *Batch
+	done   bool
+}
+
+// BeginTxn starts a transaction on behalf of user, who is charged for its
+// reads and its commit. It must end with Commit or Rollback.
+func (engine *Engine) BeginTxn(user string) *Txn {
+	return &Txn{
+		engine: engine,
+		user:   user,
+		snap:   engine.Snapshot(),
+		reads:  make(map[string]struct{}),
+		writes: make(map[string]key_value.KeyValue),
+		batch:  NewBatch(),
+	}
+}
+
+// Get reads key as of the start of the transaction, or its buffered write
+func (txn *Txn) Get(key string) (string, bool, error) {
+	if txn.done {
+		return "", false, fmt.Errorf("transaction already finished")
+	}
+	if entry, ok := txn.writes[key]; ok {
+		return entry.GetValue(), !entry.IsTombstone(), nil
+	}
+	txn.reads[key] = struct{}{}
+	return txn.engine.ReadWithOptions(txn.user, key, ReadOptions{Snapshot: txn.snap})
+}
+
+// Put buffers a write of value under key
+func (txn *Txn) Put(key string, value string) {
+	txn.batch.Put(key, value)
+	txn.writes[key] = key_value.NewKeyValue(key, value)
+}
+
+// Delete buffers a delete of key
+func (txn *Txn) Delete(key string) {
+	txn.batch.Delete(key)
+	txn.writes[key] = key_value.NewTombstone(key)
+}
+
+// Commit writes the buffered operations atomically. If a key read by the
+// transaction has a newer version than the transaction's snapshot, nothing is
+// written and an error wrapping ErrTxnConflict is returned. A key that cannot
+// be checked because its SSTable is unreadable fails the commit as well.
+func (txn *Txn) Commit() error {
+	if txn.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	txn.finish()
+	if txn.batch.Len() == 0 {
+		return nil
+	}
+	engine := txn.engine
+	if ok, err := engine.userLimiter.CheckUserTokens(txn.user); !ok {
+		return fmt.Errorf("user %s is not allowed to write: %w", txn.user, err)
+	}
+
+	// holding mem_lock keeps other writers out between the check and the append
+	engine.mem_lock.Lock()
+	if engine.failed != nil {
+		engine.mem_lock.Unlock()
+		return engine.failed
+	}
+	for key := range txn.reads {
+		seq, err := engine.latestSeq(key)
+		if err != nil {
+			engine.mem_lock.Unlock()
+			return fmt.Errorf("failed to check %s for conflicts: %w", key, err)
+		}
+		if seq > txn.snap.Seq() {
+			engine.mem_lock.Unlock()
+			return fmt.Errorf("%w: key %s was written after the transaction began", ErrTxnConflict, key)
+		}
+	}
+	last := engine.appendBatch(txn.batch)
+	engine.mem_lock.Unlock()
+
+	if err := engine.commit(last); err != nil {
+		return fmt.Errorf("failed to write transaction to WAL: %w", err)
+	}
+	return nil
+}
+
+// Rollback drops the buffered writes
+func (txn *Txn) Rollback() {
+	if !txn.done {
+		txn.finish()
+	}
+}
+
+func (txn *Txn) finish() {
+	txn.done = true
+	txn.snap.Release()
+}
+
+// latestSeq returns the sequence number of the newest version of key, 0 if it
+// was never written. The caller holds mem_lock.
+func (engine *Engine) latestSeq(key string) (uint64, error) {
+	entry, found, err := engine.latest(key)
+	if err != nil || !found {
+		return 0, err
+	}
+	return entry.GetSeq(), nil
+}
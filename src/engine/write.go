@@ -2,50 +2,140 @@ package engine
 
 import (
 	"fmt"
+	"nosqlEngine/src/models/key_value"
+	"nosqlEngine/src/storage/wal"
+	"time"
 )
 
 func (engine *Engine) Write(user string, key string, value string, fromWal bool) error {
-	// check if memory full
-	if engine.checkIfMemtableFull() {
-		engine.memtables[engine.curr_mem_index].Clear()
+	return engine.write(user, key_value.NewKeyValue(key, value), fromWal)
+}
+
+// Delete writes a tombstone for key, hiding every older value of it
+func (engine *Engine) Delete(user string, key string) error {
+	return engine.write(user, key_value.NewTombstone(key), false)
+}
+
+// PutWithTTL writes value under key for ttl. Once it expires the key reads as
+// missing, and compaction drops it from the SSTables.
+func (engine *Engine) PutWithTTL(user string, key string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive, got %v", ttl)
 	}
+	return engine.write(user, key_value.NewExpiringKeyValue(key, value, time.Now().Add(ttl)), false)
+}
 
+func (engine *Engine) write(user string, entry key_value.KeyValue, fromWal bool) error {
 	if !fromWal {
 		if ok, err := engine.userLimiter.CheckUserTokens(user); !ok {
 			return fmt.Errorf("user %s is not allowed to write: %w", user, err)
 		}
-		// write to WAL
-		var ok error
-		if value == CONFIG.Tombstone {
-			ok = engine.wal.WriteDelete(key)
-		} else {
-			ok = engine.wal.WritePut(key, value)
+	}
+
+	if fromWal {
+		// the WAL sequence number orders every write, entries without one sort oldest
+		engine.mem_lock.Lock()
+		engine.apply(entry, 0)
+		engine.mem_lock.Unlock()
+		return nil
+	}
+
+	engine.mem_lock.Lock()
+	if engine.failed != nil {
+		engine.mem_lock.Unlock()
+		return engine.failed
+	}
+	ticket := engine.appendEntry(entry)
+	engine.mem_lock.Unlock()
+	// wait for the configured durability without blocking other writers, so
+	// that concurrent writes share one WAL commit
+	if err := engine.commit(ticket); err != nil {
+		return fmt.Errorf("failed to write to WAL: %w", err)
+	}
+	return nil
+}
+
+// pendingWrite is a WAL record that is not durable yet, with its entries
+// numbered as in the WAL
+type pendingWrite struct {
+	ticket  uint64
+	entries []key_value.KeyValue
+}
+
+// appendEntry logs entry to the WAL and queues it for the memtable, so both
+// see writes in the same order. It returns the WAL ticket of the entry, the
+// caller holds mem_lock.
+func (engine *Engine) appendEntry(entry key_value.KeyValue) uint64 {
+	var ticket uint64
+	if entry.IsTombstone() {
+		ticket = engine.wal.Append(wal.NewDeleteEntry(entry.GetKey()))
+	} else {
+		put := wal.NewPutEntry(entry.GetKey(), entry.GetValue())
+		put.ExpiresAt = entry.GetExpiry()
+		ticket = engine.wal.Append(put)
+	}
+	engine.pending = append(engine.pending, pendingWrite{ticket: ticket, entries: []key_value.KeyValue{entry.WithSeq(ticket)}})
+	return ticket
+}
+
+// commit waits until the WAL record of ticket is durable and then adds it,
+// along with every older record still pending, to the active memtable in WAL
+// order. Readers never see a write that may still be lost, and a write whose
+// commit failed is dropped before it can reach an SSTable.
+func (engine *Engine) commit(ticket uint64) error {
+	err := engine.wal.WaitDurable(ticket)
+	engine.mem_lock.Lock()
+	defer engine.mem_lock.Unlock()
+	if err != nil {
+		// a failed commit fails every later one, so no newer record is applied
+		for i, write := range engine.pending {
+			if write.ticket == ticket {
+				engine.pending = append(engine.pending[:i], engine.pending[i+1:]...)
+				break
+			}
 		}
-		if ok != nil {
-			return fmt.Errorf("failed to write to WAL: %w", ok)
+		return err
+	}
+	// the WAL commits in order, every older record is durable as well
+	for len(engine.pending) > 0 && engine.pending[0].ticket <= ticket {
+		write := engine.pending[0]
+		engine.pending = engine.pending[1:]
+		// the whole record goes into the active memtable before it may be
+		// frozen, freezeIfFull can release mem_lock while it waits for a flush
+		for _, entry := range write.entries {
+			engine.active.add(entry, engine.seenBySnapshot)
 		}
+		engine.freezeIfFull()
 	}
+	return nil
+}
 
-	write_mem := engine.memtables[engine.curr_mem_index]
-	write_mem.Add(key, value)
-	if write_mem.GetSize() >= CONFIG.MemtableSize && !fromWal {
-		engine.SetNextMemtable()
-		done := make(chan struct{})
-		go func() {
-			engine.flush_lock.Lock()
-			defer engine.flush_lock.Unlock()
-
-			engine.ss_parser.FlushMemtable(write_mem.ToRaw())
-			if engine.curr_mem_index == 0 {
-				engine.wal.DeleteWALSegments()
+// pendingEntry returns the newest version of key that is logged but not yet
+// in the memtables. The caller holds mem_lock.
+func (engine *Engine) pendingEntry(key string) (key_value.KeyValue, bool) {
+	for i := len(engine.pending) - 1; i >= 0; i-- {
+		entries := engine.pending[i].entries
+		for j := len(entries) - 1; j >= 0; j-- {
+			if entries[j].GetKey() == key {
+				return entries[j], true
 			}
-			close(done) // signal that FlushMemtable is done
-		}()
+		}
+	}
+	return key_value.KeyValue{}, false
+}
 
-		go func() {
-			<-done // wait for FlushMemtable to finish
-			engine.ss_compacter.CheckCompactionConditions(engine.block_manager)
-		}()
+// visibleSeq returns the newest sequence number readers may see, the one
+// before the oldest pending write. The caller holds mem_lock.
+func (engine *Engine) visibleSeq() uint64 {
+	if len(engine.pending) > 0 {
+		return engine.pending[0].entries[0].GetSeq() - 1
 	}
-	return nil
+	return engine.wal.LastSeq()
+}
+
+// apply inserts an entry numbered seq in the WAL into the active memtable.
+// The caller holds mem_lock.
+func (engine *Engine) apply(entry key_value.KeyValue, seq uint64) {
+	engine.active.add(entry.WithSeq(seq), engine.seenBySnapshot)
+	engine.freezeIfFull()
 }
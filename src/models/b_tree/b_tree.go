@@ -2,55 +2,72 @@ package b_tree
 
 import (
 	"encoding/gob"
-	"nosqlEngine/src/config"
 	"nosqlEngine/src/models/key_value"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 type BTreeNode struct {
 	Keys     []string
-	Values   []string
+	Values   []key_value.KeyValue
 	Children []*BTreeNode
 	IsLeaf   bool
 }
 
 type BTree struct {
-	Root *BTreeNode
-	T    int
-	Size int
+	Root     *BTreeNode
+	T        int
+	Size     int
+	dataSize int      // bytes of keys and values added, used as the memtable size
+	order    ordering // key order, byte order when its compare is nil
 }
 
-var CONFIG = config.GetConfig()
+// ordering compares keys for the nodes of one tree
+type ordering struct {
+	compare func(a, b string) int
+}
 
-func NewBTree(t int) *BTree {
-	return &BTree{Root: &BTreeNode{IsLeaf: true}, T: t}
+func (o ordering) less(a, b string) bool {
+	if o.compare == nil {
+		return a < b
+	}
+	return o.compare(a, b) < 0
 }
 
-func (tree *BTree) Get(key string) (string, bool) {
-	return tree.Root.search(key)
+// NewBTree builds a B-tree of minimum degree t ordered by compare, a nil
+// compare orders keys byte by byte
+func NewBTree(t int, compare func(a, b string) int) *BTree {
+	if t < 2 {
+		t = 2
+	}
+	return &BTree{Root: &BTreeNode{IsLeaf: true}, T: t, order: ordering{compare: compare}}
 }
 
-func (node *BTreeNode) search(key string) (string, bool) {
+// Get returns the entry stored for key, tombstones included
+func (tree *BTree) Get(key string) (key_value.KeyValue, bool) {
+	return tree.Root.search(key, tree.order)
+}
+
+func (node *BTreeNode) search(key string, order ordering) (key_value.KeyValue, bool) {
 	i := 0
-	for i < len(node.Keys) && key > node.Keys[i] {
+	for i < len(node.Keys) && order.less(node.Keys[i], key) {
 		i++
 	}
 	if i < len(node.Keys) && key == node.Keys[i] {
-		if node.Values[i] == CONFIG.Tombstone {
-			return "", false
-		}
 		return node.Values[i], true
 	}
 	if node.IsLeaf {
-		return "", false
+		return key_value.KeyValue{}, false
 	}
-	return node.Children[i].search(key)
+	return node.Children[i].search(key, order)
 }
 
-func (tree *BTree) Add(key, value string) {
-	if tree.updateExistingKey(key, value) {
-		return
+func (tree *BTree) Add(entry key_value.KeyValue) bool {
+	key := entry.GetKey()
+	tree.dataSize += len(key) + len(entry.GetValue())
+	if tree.updateExistingKey(key, entry) {
+		return true
 	}
 
 	tree.Size++
@@ -60,19 +77,20 @@ func (tree *BTree) Add(key, value string) {
 		s := &BTreeNode{IsLeaf: false, Children: []*BTreeNode{root}}
 		tree.Root = s
 		s.splitChild(0, tree.T)
-		s.addNonFull(key, value, tree.T)
+		s.addNonFull(entry, tree.T, tree.order)
 	} else {
-		root.addNonFull(key, value, tree.T)
+		root.addNonFull(entry, tree.T, tree.order)
 	}
+	return true
 }
 
-func (tree *BTree) updateExistingKey(key, value string) bool {
-	return tree.Root.updateExistingKeyRecursive(key, value)
+func (tree *BTree) updateExistingKey(key string, value key_value.KeyValue) bool {
+	return tree.Root.updateExistingKeyRecursive(key, value, tree.order)
 }
 
-func (node *BTreeNode) updateExistingKeyRecursive(key, value string) bool {
+func (node *BTreeNode) updateExistingKeyRecursive(key string, value key_value.KeyValue, order ordering) bool {
 	i := 0
-	for i < len(node.Keys) && key > node.Keys[i] {
+	for i < len(node.Keys) && order.less(node.Keys[i], key) {
 		i++
 	}
 	if i < len(node.Keys) && key == node.Keys[i] {
@@ -80,42 +98,44 @@ func (node *BTreeNode) updateExistingKeyRecursive(key, value string) bool {
 		return true
 	}
 	if !node.IsLeaf {
-		return node.Children[i].updateExistingKeyRecursive(key, value)
+		return node.Children[i].updateExistingKeyRecursive(key, value, order)
 	}
 	return false
 }
 
-func (node *BTreeNode) addNonFull(key, value string, t int) {
+func (node *BTreeNode) addNonFull(entry key_value.KeyValue, t int, order ordering) {
+	key := entry.GetKey()
 	i := len(node.Keys) - 1
 	if node.IsLeaf {
 		node.Keys = append(node.Keys, "")
-		node.Values = append(node.Values, "")
-		for i >= 0 && key < node.Keys[i] {
+		node.Values = append(node.Values, key_value.KeyValue{})
+		for i >= 0 && order.less(key, node.Keys[i]) {
 			node.Keys[i+1] = node.Keys[i]
 			node.Values[i+1] = node.Values[i]
 			i--
 		}
 		node.Keys[i+1] = key
-		node.Values[i+1] = value
+		node.Values[i+1] = entry
 		return
 	}
 
-	for i >= 0 && key < node.Keys[i] {
+	for i >= 0 && order.less(key, node.Keys[i]) {
 		i--
 	}
 	i++
 	if len(node.Children[i].Keys) == 2*t-1 {
 		node.splitChild(i, t)
-		if key > node.Keys[i] {
+		if order.less(node.Keys[i], key) {
 			i++
 		}
 	}
-	node.Children[i].addNonFull(key, value, t)
+	node.Children[i].addNonFull(entry, t, order)
 }
 
 func (node *BTreeNode) splitChild(i, t int) {
 	y := node.Children[i]
 	z := &BTreeNode{IsLeaf: y.IsLeaf}
+	midKey, midValue := y.Keys[t-1], y.Values[t-1]
 	z.Keys = append(z.Keys, y.Keys[t:]...)
 	z.Values = append(z.Values, y.Values[t:]...)
 	y.Keys = y.Keys[:t-1]
@@ -128,30 +148,16 @@ func (node *BTreeNode) splitChild(i, t int) {
 	copy(node.Children[i+2:], node.Children[i+1:])
 	node.Children[i+1] = z
 	node.Keys = append(node.Keys, "")
-	node.Values = append(node.Values, "")
+	node.Values = append(node.Values, key_value.KeyValue{})
 	copy(node.Keys[i+1:], node.Keys[i:])
 	copy(node.Values[i+1:], node.Values[i:])
-	node.Keys[i] = y.Keys[t-1]
-	node.Values[i] = y.Values[t-1]
+	node.Keys[i] = midKey
+	node.Values[i] = midValue
 }
 
+// Remove marks key as deleted by storing a tombstone for it
 func (tree *BTree) Remove(key string) {
-	tree.Root.Remove(key)
-}
-
-func (node *BTreeNode) Remove(key string) {
-	i := 0
-	for i < len(node.Keys) && key > node.Keys[i] {
-		i++
-	}
-	if i < len(node.Keys) && key == node.Keys[i] {
-		node.Values[i] = CONFIG.Tombstone
-		return
-	}
-	if node.IsLeaf {
-		return
-	}
-	node.Children[i].Remove(key)
+	tree.Add(key_value.NewTombstone(key))
 }
 
 type KeyValuePair struct {
@@ -159,28 +165,46 @@ type KeyValuePair struct {
 	Value string
 }
 
-func (node *BTreeNode) collectKeyValues(pairs *[]key_value.KeyValue) {
+// ascendFrom visits keys >= start in order, all keys when start is nil,
+// returning false once visit asks to stop
+func (node *BTreeNode) ascendFrom(start *string, order ordering, visit func(entry key_value.KeyValue) bool) bool {
 	i := 0
-	for i < len(node.Keys) {
-		if !node.IsLeaf {
-			node.Children[i].collectKeyValues(pairs)
+	if start != nil {
+		i = sort.Search(len(node.Keys), func(j int) bool { return !order.less(node.Keys[j], *start) })
+	}
+	for ; i < len(node.Keys); i++ {
+		if !node.IsLeaf && !node.Children[i].ascendFrom(start, order, visit) {
+			return false
 		}
-		if node.Values[i] != CONFIG.Tombstone {
-			*pairs = append(*pairs, key_value.NewKeyValue(node.Keys[i], node.Values[i]))
+		if !visit(node.Values[i]) {
+			return false
 		}
-		i++
 	}
 	if !node.IsLeaf {
-		node.Children[i].collectKeyValues(pairs)
+		return node.Children[i].ascendFrom(start, order, visit)
 	}
+	return true
 }
 
+// ToRaw returns all entries, tombstones included, in ascending key order
 func (tree *BTree) ToRaw() []key_value.KeyValue {
-	pairs := []key_value.KeyValue{}
-	tree.Root.collectKeyValues(&pairs)
+	pairs := make([]key_value.KeyValue, 0, tree.Size)
+	tree.Root.ascendFrom(nil, tree.order, func(entry key_value.KeyValue) bool {
+		pairs = append(pairs, entry)
+		return true
+	})
 	return pairs
 }
 
+// ScanFrom visits entries with key >= start in ascending order until visit returns false
+func (tree *BTree) ScanFrom(start string, visit func(entry key_value.KeyValue) bool) {
+	tree.Root.ascendFrom(&start, tree.order, visit)
+}
+
+func (tree *BTree) GetSize() int {
+	return tree.dataSize
+}
+
 func (tree *BTree) Serialize(filename string) error {
 	path := filepath.Join("src/models/serialized", filename)
 	file, err := os.Create(path)
@@ -207,7 +231,9 @@ func Deserialize(filename string) (*BTree, error) {
 	return &tree, nil
 }
 
-func (tree *BTree) Clear() {
+func (tree *BTree) Clear() bool {
 	tree.Root = &BTreeNode{IsLeaf: true}
 	tree.Size = 0
+	tree.dataSize = 0
+	return true
 }
\ No newline at end of file
@@ -3,13 +3,10 @@ package bloom_filter
 import (
 	"bytes"
 	"encoding/binary"
-	"nosqlEngine/src/config"
 	"os"
 	"path/filepath"
 )
 
-var CONFIG = config.GetConfig()
-
 type BloomFilter struct {
 	K      int32
 	M      int32
@@ -24,14 +21,6 @@ func (filter *BloomFilter) calculateParams(expectedElements int, falsePositiveRa
 	filter.M = int32(m)
 }
 
-func NewBloomFilter() *BloomFilter {
-	filter := &BloomFilter{}
-	filter.calculateParams(CONFIG.BloomFilterExpectedElements, CONFIG.BloomFilterFalsePositiveRate)
-	filter.Array = make([]byte, filter.M)
-	filter.Hashes = CreateHashFunctions(uint32(filter.K))
-	return filter
-}
-
 func NewBloomFilterWithParams(expectedElements int, falsePositiveRate float64) *BloomFilter {
 	filter := &BloomFilter{}
 	filter.calculateParams(expectedElements, falsePositiveRate)
@@ -6,14 +6,14 @@ type PrefixBloomFilter struct {
 	maxLength int // maksimalna dužina prefiksa
 }
 
-func NewPrefixBloomFilter() *PrefixBloomFilter {
+func NewPrefixBloomFilter(expectedElements int, falsePositiveRate float64, minLength int, maxLength int) *PrefixBloomFilter {
 	// Proceni broj prefiksa: za svaki ključ, imamo (maxLen - minLen + 1) prefiksa
-	estimatedPrefixes := CONFIG.BloomFilterExpectedElements * (CONFIG.MaxPrefixLength - CONFIG.MinPrefixLength + 1)
+	estimatedPrefixes := expectedElements * (maxLength - minLength + 1)
 
 	return &PrefixBloomFilter{
-		filter:    NewBloomFilterWithParams(estimatedPrefixes, CONFIG.BloomFilterFalsePositiveRate),
-		minLength: CONFIG.MinPrefixLength,
-		maxLength: CONFIG.MaxPrefixLength,
+		filter:    NewBloomFilterWithParams(estimatedPrefixes, falsePositiveRate),
+		minLength: minLength,
+		maxLength: maxLength,
 	}
 }
 
@@ -21,7 +21,7 @@ func (pf *PrefixBloomFilter) SerializeToByteArray() ([]byte, error) {
 	return pf.filter.SerializeToByteArray()
 }
 
-func DeserializePrefixBloomFilter(data []byte) (*PrefixBloomFilter, error) {
+func DeserializePrefixBloomFilter(data []byte, minLength int, maxLength int) (*PrefixBloomFilter, error) {
 	filter, err := DeserializeFromByteArray(data)
 	if err != nil {
 		return nil, err
@@ -29,8 +29,8 @@ func DeserializePrefixBloomFilter(data []byte) (*PrefixBloomFilter, error) {
 
 	return &PrefixBloomFilter{
 		filter:    filter,
-		minLength: CONFIG.MinPrefixLength,
-		maxLength: CONFIG.MaxPrefixLength,
+		minLength: minLength,
+		maxLength: maxLength,
 	}, nil
 }
 
@@ -0,0 +1,57 @@
+package comparator
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// Comparator orders keys: it returns a negative number when a sorts before b,
+// zero when they are equal and a positive number otherwise. It must not modify
+// or keep its arguments.
+//
+// Prefix scans expect the keys that start with a prefix to sort together,
+// from the prefix itself on, as they do in byte order.
+type Comparator func(a, b []byte) int
+
+// Bytewise names the default comparator, which orders keys byte by byte
+const Bytewise = "bytewise"
+
+var (
+	lock     sync.RWMutex
+	registry = map[string]Comparator{Bytewise: bytes.Compare}
+)
+
+// Register makes cmp selectable by name through KEY_COMPARATOR. It must be
+// called before an engine using it is created, and a database has to be
+// opened with the comparator it was created with.
+func Register(name string, cmp Comparator) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if name == "" {
+		return fmt.Errorf("comparator name must not be empty")
+	}
+	if _, exists := registry[name]; exists {
+		return fmt.Errorf("comparator %q is already registered", name)
+	}
+	registry[name] = cmp
+	return nil
+}
+
+// Lookup returns the comparator registered under name, an empty name is the
+// bytewise one
+func Lookup(name string) (Comparator, bool) {
+	if name == "" {
+		name = Bytewise
+	}
+	lock.RLock()
+	defer lock.RUnlock()
+	cmp, ok := registry[name]
+	return cmp, ok
+}
+
+// CompareStrings compares keys held as strings without copying them
+func (cmp Comparator) CompareStrings(a, b string) int {
+	return cmp(unsafe.Slice(unsafe.StringData(a), len(a)), unsafe.Slice(unsafe.StringData(b), len(b)))
+}
@@ -5,7 +5,7 @@ import (
 )
 
 type HashMap struct {
-	data map[string]string
+	data map[string]key_value.KeyValue
 	size int
 }
 
@@ -15,31 +15,31 @@ func (hmap *HashMap) GetSize() int {
 }
 
 func NewHashMap() *HashMap {
-	return &HashMap{data: make(map[string]string), size: 0}
+	return &HashMap{data: make(map[string]key_value.KeyValue), size: 0}
 }
 
-func (hmap *HashMap) Add(key string, value string) bool {
-	hmap.data[key] = value
-	hmap.size += (len(key) + len(value))
+func (hmap *HashMap) Add(entry key_value.KeyValue) bool {
+	hmap.data[entry.GetKey()] = entry
+	hmap.size += (len(entry.GetKey()) + len(entry.GetValue()))
 	return true
 }
-func (hmap *HashMap) Get(key string) (string, bool) {
-	value, ok := hmap.data[key]
-	return value, ok
+func (hmap *HashMap) Get(key string) (key_value.KeyValue, bool) {
+	entry, ok := hmap.data[key]
+	return entry, ok
 }
 
 func (hmap *HashMap) ToRaw() []key_value.KeyValue {
 
 	ret := make([]key_value.KeyValue, 0, len(hmap.data))
 
-	for k, v := range hmap.data {
-		ret = append(ret, key_value.NewKeyValue(k, v))
+	for _, entry := range hmap.data {
+		ret = append(ret, entry)
 	}
 	return ret
 }
 
 func (hmap *HashMap) Clear() bool {
-	hmap.data = make(map[string]string)
+	hmap.data = make(map[string]key_value.KeyValue)
 	hmap.size = 0
 	return true
 }
\ No newline at end of file
@@ -1,21 +1,100 @@
 package key_value
 
-import "sort"
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+	"time"
+)
 
 type KeyValue struct {
-	key   string
-	value string
+	key       string
+	value     string
+	tombstone bool
+	seq       uint64 // position of the write in the global write order, 0 if unknown
+	expires   int64  // unix nanoseconds after which the entry is gone, 0 if never
 }
 
 func NewKeyValue(key string, value string) KeyValue {
 	return KeyValue{key: key, value: value}
 }
+
+// NewExpiringKeyValue returns an entry that disappears at expiresAt
+func NewExpiringKeyValue(key string, value string, expiresAt time.Time) KeyValue {
+	return KeyValue{key: key, value: value, expires: expiresAt.UnixNano()}
+}
+
+// NewTombstone returns the entry that marks key as deleted
+func NewTombstone(key string) KeyValue {
+	return KeyValue{key: key, tombstone: true}
+}
 func (kv KeyValue) GetKey() string {
 	return kv.key
 }
 func (kv KeyValue) GetValue() string {
 	return kv.value
 }
+func (kv KeyValue) IsTombstone() bool {
+	return kv.tombstone
+}
+
+// GetExpiry returns the expiry time in unix nanoseconds, 0 if the entry never expires
+func (kv KeyValue) GetExpiry() int64 {
+	return kv.expires
+}
+
+// WithExpiry returns a copy of the entry expiring at unix nanoseconds expires,
+// 0 for never
+func (kv KeyValue) WithExpiry(expires int64) KeyValue {
+	kv.expires = expires
+	return kv
+}
+
+// IsExpired reports whether the entry has an expiry that is not after now
+func (kv KeyValue) IsExpired(now time.Time) bool {
+	return kv.expires != 0 && kv.expires <= now.UnixNano()
+}
+
+// GetSeq returns the sequence number of the write that produced the entry
+func (kv KeyValue) GetSeq() uint64 {
+	return kv.seq
+}
+
+// WithSeq returns a copy of the entry numbered seq
+func (kv KeyValue) WithSeq(seq uint64) KeyValue {
+	kv.seq = seq
+	return kv
+}
+
+// IsNewerThan reports whether kv was written after other
+func (kv KeyValue) IsNewerThan(other KeyValue) bool {
+	return kv.seq > other.seq
+}
+
+// gobKeyValue mirrors KeyValue with exported fields so the models that
+// embed entries keep serializing with gob
+type gobKeyValue struct {
+	Key       string
+	Value     string
+	Tombstone bool
+	Seq       uint64
+	Expires   int64
+}
+
+func (kv KeyValue) GobEncode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(gobKeyValue{Key: kv.key, Value: kv.value, Tombstone: kv.tombstone, Seq: kv.seq, Expires: kv.expires})
+	return buf.Bytes(), err
+}
+
+func (kv *KeyValue) GobDecode(data []byte) error {
+	var decoded gobKeyValue
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded); err != nil {
+		return err
+	}
+	kv.key, kv.value, kv.tombstone, kv.seq, kv.expires = decoded.Key, decoded.Value, decoded.Tombstone, decoded.Seq, decoded.Expires
+	return nil
+}
 func GetKeys(data []KeyValue) []string {
 	keys := make([]string, 0, len(data))
 	for i := 0; i < len(data); i++ {
@@ -30,8 +109,13 @@ func GetValues(data []KeyValue) []string {
 	}
 	return values
 }
-func SortByKeys(data *[]KeyValue) {
+func IsSortedByKeys(data []KeyValue, compare func(a, b string) int) bool {
+	return sort.SliceIsSorted(data, func(i, j int) bool {
+		return compare(data[i].GetKey(), data[j].GetKey()) < 0
+	})
+}
+func SortByKeys(data *[]KeyValue, compare func(a, b string) int) {
 	sort.Slice(*data, func(i, j int) bool {
-		return (*data)[i].GetKey() < (*data)[j].GetKey()
+		return compare((*data)[i].GetKey(), (*data)[j].GetKey()) < 0
 	})
 }
@@ -4,7 +4,6 @@ import (
 	"encoding/gob"
 	"fmt"
 	"math/rand"
-	"nosqlEngine/src/config"
 	"nosqlEngine/src/models/key_value"
 	"os"
 	"path/filepath"
@@ -13,19 +12,19 @@ import (
 
 type Node struct {
 	Key   string
-	Value string
+	Value key_value.KeyValue
 	Right *Node
 	Below *Node
 }
 
 type SkipList struct {
-	Head   *Node
-	Levels int
-	Size   int
+	Head     *Node
+	Levels   int
+	Size     int
+	dataSize int                   // bytes of keys and values added, used as the memtable size
+	compare  func(a, b string) int // key order, byte order when nil
 }
 
-var CONFIG = config.GetConfig()
-
 func (list *SkipList) initialize() {
 	list.Head = &Node{Key: ""}
 	tmp := list.Head
@@ -35,70 +34,52 @@ func (list *SkipList) initialize() {
 	}
 }
 
-func NewSkipList(levels int) *SkipList {
+// NewSkipList builds a skip list ordered by compare, a nil compare orders keys
+// byte by byte
+func NewSkipList(levels int, compare func(a, b string) int) *SkipList {
 	if levels < 1 {
 		levels = 1
 	}
-	list := &SkipList{Levels: levels}
+	list := &SkipList{Levels: levels, compare: compare}
 	list.initialize()
 	return list
 }
 
-func (list *SkipList) Get(key string) (string, bool) {
-	tmp := list.Head
-
-	for {
-		if tmp.Key == key {
-			if tmp.Value == CONFIG.Tombstone {
-				return "", false
-			}
-			return tmp.Value, true
-		}
-		if tmp.Right == nil {
-			if tmp.Below == nil {
-				return "", false
-			}
-			tmp = tmp.Below
-		} else {
-			if tmp.Right.Key > key {
-				return "", false
-			}
-			tmp = tmp.Right
-		}
+// less reports whether a sorts before b
+func (list *SkipList) less(a, b string) bool {
+	if list.compare == nil {
+		return a < b
 	}
+	return list.compare(a, b) < 0
 }
 
-func (list *SkipList) Remove(key string) bool {
-	node, exists := list.findToRemove(key)
-	if !exists {
-		return false
+// Get returns the entry stored for key, tombstones included
+func (list *SkipList) Get(key string) (key_value.KeyValue, bool) {
+	node := list.find(key)
+	if node == nil {
+		return key_value.KeyValue{}, false
 	}
-
-	for node != nil {
-		node.Value = CONFIG.Tombstone
-		node = node.Below
-	}
-
-	return true
+	return node.Value, true
 }
 
-func (list *SkipList) findToRemove(key string) (*Node, bool) {
+// find returns the top-most node of the tower holding key, or nil
+func (list *SkipList) find(key string) *Node {
 	tmp := list.Head
-
-	for {
-		if tmp.Right != nil && tmp.Right.Key == key {
-			return tmp.Right, true
-		}
-		if tmp.Right == nil && tmp.Below == nil {
-			return nil, false
-		}
-
-		if tmp.Right == nil || tmp.Right.Key > key {
-			tmp = tmp.Below
-		} else {
+	for tmp != nil {
+		for tmp.Right != nil && list.less(tmp.Right.Key, key) {
 			tmp = tmp.Right
 		}
+		if tmp.Right != nil && tmp.Right.Key == key {
+			return tmp.Right
+		}
+		tmp = tmp.Below
 	}
+	return nil
+}
+
+// Remove marks key as deleted by storing a tombstone for it
+func (list *SkipList) Remove(key string) bool {
+	return list.Add(key_value.NewTombstone(key))
 }
 
 func (list *SkipList) findToAdd(key string) []*Node {
@@ -109,11 +90,11 @@ func (list *SkipList) findToAdd(key string) []*Node {
 		if node.Right == nil && node.Below == nil {
 			return append(lefts, node)
 		}
-		if node.Right != nil && node.Right.Key > key && node.Below == nil {
+		if node.Right != nil && list.less(key, node.Right.Key) && node.Below == nil {
 			return append(lefts, node)
 		}
 
-		if node.Right == nil || node.Right.Key > key {
+		if node.Right == nil || list.less(key, node.Right.Key) {
 			lefts = append(lefts, node)
 			node = node.Below
 		} else {
@@ -130,14 +111,15 @@ func coinFlip(r *rand.Rand) string {
 	return "Tails"
 }
 
-func (list *SkipList) Add(key string, value string) bool {
+func (list *SkipList) Add(entry key_value.KeyValue) bool {
 	if list.Head == nil {
 		list.initialize()
 	}
 
-	_, exists := list.Get(key)
-	if exists {
-		return list.updateExistingKey(key, value)
+	key := entry.GetKey()
+	list.dataSize += len(key) + len(entry.GetValue())
+	if list.updateExistingKey(key, entry) {
+		return true
 	}
 
 	times_to_add := 1
@@ -156,7 +138,7 @@ func (list *SkipList) Add(key string, value string) bool {
 		if times_to_add == 0 {
 			break
 		}
-		node := &Node{Key: key, Value: value}
+		node := &Node{Key: key, Value: entry}
 		tmp := lefts[i].Right
 		node.Right = tmp
 		lefts[i].Right = node
@@ -172,23 +154,16 @@ func (list *SkipList) Add(key string, value string) bool {
 	return true
 }
 
-func (list *SkipList) updateExistingKey(key string, value string) bool {
-	node := list.Head
-	updated := false
-
+func (list *SkipList) updateExistingKey(key string, value key_value.KeyValue) bool {
+	node := list.find(key)
+	if node == nil {
+		return false
+	}
 	for node != nil {
-		tmp := node
-		for tmp != nil {
-			if tmp.Key == key {
-				tmp.Value = value
-				updated = true
-			}
-			tmp = tmp.Right
-		}
+		node.Value = value
 		node = node.Below
 	}
-
-	return updated
+	return true
 }
 
 func (list *SkipList) Print() {
@@ -199,7 +174,7 @@ func (list *SkipList) Print() {
 		tmp := node
 		for tmp != nil {
 			if tmp.Key != "" {
-				fmt.Printf("(%s,%s) ", tmp.Key, tmp.Value)
+				fmt.Printf("(%s,%s) ", tmp.Key, tmp.Value.GetValue())
 			}
 			tmp = tmp.Right
 		}
@@ -234,31 +209,51 @@ func Deserialize(filename string) (*SkipList, error) {
 	return &list, nil
 }
 
+// ToRaw returns all entries in ascending key order
 func (list *SkipList) ToRaw() []key_value.KeyValue {
-	ret := make([]key_value.KeyValue, 0)
+	ret := make([]key_value.KeyValue, 0, list.Size)
+	if list.Head == nil {
+		return ret
+	}
 	node := list.Head
-	for node != nil && node.Below != nil {
+	for node.Below != nil {
 		node = node.Below
 	}
-	for node != nil {
-		tmp := node.Right
-		for tmp != nil {
-			if tmp.Key != "" {
-				ret = append(ret, key_value.NewKeyValue(tmp.Key, tmp.Value))
-			}
-			tmp = tmp.Right
-		}
+	for tmp := node.Right; tmp != nil; tmp = tmp.Right {
+		ret = append(ret, tmp.Value)
 	}
 	return ret
 }
-func (list *SkipList) Clear() bool {
-	list.Head = &Node{Key: ""}
-	tmp := list.Head
-	for i := 0; i < list.Levels; i++ {
-		tmp.Below = &Node{Key: ""}
-		tmp = tmp.Below
+
+// ScanFrom visits entries with key >= start in ascending order until visit returns false
+func (list *SkipList) ScanFrom(start string, visit func(entry key_value.KeyValue) bool) {
+	if list.Head == nil {
+		return
+	}
+	node := list.Head
+	for {
+		for node.Right != nil && list.less(node.Right.Key, start) {
+			node = node.Right
+		}
+		if node.Below == nil {
+			break
+		}
+		node = node.Below
 	}
+	for tmp := node.Right; tmp != nil; tmp = tmp.Right {
+		if !visit(tmp.Value) {
+			return
+		}
+	}
+}
+
+func (list *SkipList) GetSize() int {
+	return list.dataSize
+}
+
+func (list *SkipList) Clear() bool {
+	list.initialize()
 	list.Size = 0
-	list.Levels = 1
+	list.dataSize = 0
 	return true
-}
\ No newline at end of file
+}
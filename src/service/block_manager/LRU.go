@@ -2,15 +2,17 @@ package block_manager
 
 import (
 	"fmt"
-	cfg "nosqlEngine/src/config"
 	doublyll "nosqlEngine/src/models/doubly_ll"
+	"sync"
 )
 
+// LRUCache is safe for concurrent use, block managers copied into readers share it
 type LRUCache struct {
 	capacity int
 	cache    map[doublyll.BlockKey]*doublyll.Block
 	lruList  *doublyll.DoublyLinkedList
 	evicted  *EvictedBlock
+	lock     sync.Mutex
 }
 
 type EvictedBlock struct {
@@ -18,8 +20,7 @@ type EvictedBlock struct {
 	data     []byte
 }
 
-func NewLRUCache() *LRUCache {
-	capacity := cfg.GetConfig().CacheCapacity
+func NewLRUCache(capacity int) *LRUCache {
 	return &LRUCache{
 		capacity: capacity,
 		cache:    make(map[doublyll.BlockKey]*doublyll.Block),
@@ -29,6 +30,8 @@ func NewLRUCache() *LRUCache {
 }
 
 func (c *LRUCache) Put(filePath string, blockID int, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	key := doublyll.NewBlockKey(blockID, filePath)
 	if elem, found := c.cache[key]; found {
 		c.lruList.MoveToFront(elem)
@@ -59,6 +62,8 @@ func (c *LRUCache) evict() {
 }
 
 func (c *LRUCache) Get(filePath string, blockID int) ([]byte, error) {
+	c.lock.Lock() // a hit reorders the list
+	defer c.lock.Unlock()
 	key := doublyll.NewBlockKey(blockID, filePath)
 	fmt.Print("Fetching block from cache:", key)
 	if elem, found := c.cache[key]; found {
@@ -69,6 +74,8 @@ func (c *LRUCache) Get(filePath string, blockID int) ([]byte, error) {
 }
 
 func (c *LRUCache) GetEvictedBlock() (doublyll.BlockKey, []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	if c.evicted == nil {
 		return 0, nil
 	}
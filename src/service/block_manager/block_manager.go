@@ -3,36 +3,40 @@ package block_manager
 import (
 	"fmt"
 	"io"
-	"nosqlEngine/src/config"
 	"os"
+	"path/filepath"
 )
 
-var CONFIG = config.GetConfig()
-
 type BlockManager struct {
 	block_size int
 	lruCache   *LRUCache
 }
 
-func NewBlockManager() *BlockManager {
+func NewBlockManager(blockSize int, cacheCapacity int) *BlockManager {
 	return &BlockManager{
-		block_size: CONFIG.BlockSize,
-		lruCache:   NewLRUCache(),
+		block_size: blockSize,
+		lruCache:   NewLRUCache(cacheCapacity),
 	}
 }
 
 func (bm *BlockManager) WriteBlock(location string, blockNumber int, data []byte) error {
-	if len(data) > CONFIG.BlockSize {
+	if len(data) > bm.block_size {
 		return fmt.Errorf("data size exceeds block size")
 	}
 
 	file, err := os.OpenFile(location, os.O_WRONLY|os.O_CREATE, 0644)
+	if os.IsNotExist(err) {
+		// first write into a fresh data directory, create the missing folders
+		if err = os.MkdirAll(filepath.Dir(location), 0755); err == nil {
+			file, err = os.OpenFile(location, os.O_WRONLY|os.O_CREATE, 0644)
+		}
+	}
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	offset := int64(CONFIG.BlockSize * blockNumber)
+	offset := int64(bm.block_size * blockNumber)
 	_, err = file.Seek(offset, 0)
 	if err != nil {
 		return err
@@ -59,7 +63,7 @@ func (bm *BlockManager) ReadBlock(location string, blockNumber int, direction bo
 			fmt.Println("Error getting file info:", err, " for forwardBlockNumber:", forwardBlockNumber)
 			return nil, err
 		}
-		totalBlocks := int(fileInfo.Size()) / CONFIG.BlockSize
+		totalBlocks := int(fileInfo.Size()) / bm.block_size
 		if blockNumber >= totalBlocks {
 			return nil, io.EOF
 		}
@@ -84,16 +88,16 @@ func (bm *BlockManager) ReadBlock(location string, blockNumber int, direction bo
 	}
 
 	if direction { // true: read from start
-		offset = int64(CONFIG.BlockSize * blockNumber)
+		offset = int64(bm.block_size * blockNumber)
 		if offset >= fileInfo.Size() {
 			return nil, io.EOF
 		}
 	} else { // false: read from end
-		totalBlocks := int(fileInfo.Size()) / CONFIG.BlockSize
+		totalBlocks := int(fileInfo.Size()) / bm.block_size
 		if blockNumber >= totalBlocks {
 			return nil, io.EOF
 		}
-		offset = int64(CONFIG.BlockSize * (totalBlocks - 1 - blockNumber))
+		offset = int64(bm.block_size * (totalBlocks - 1 - blockNumber))
 		if offset < 0 {
 			offset = 0
 		}
@@ -103,7 +107,7 @@ func (bm *BlockManager) ReadBlock(location string, blockNumber int, direction bo
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]byte, CONFIG.BlockSize)
+	buf := make([]byte, bm.block_size)
 	n, err := file.Read(buf)
 	if err != nil {
 		return nil, err
@@ -118,6 +122,17 @@ func (bm *BlockManager) ReadBlock(location string, blockNumber int, direction bo
 	return data, nil
 }
 
+// Sync flushes the blocks written to location to stable storage. Syncing a
+// directory makes the files created or renamed in it durable.
+func (bm *BlockManager) Sync(location string) error {
+	file, err := os.Open(location)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
 func (bm *BlockManager) GetFileSize(location string) (int64, error) {
 	fileInfo, err := os.Stat(location)
 	if err != nil {
@@ -134,11 +149,11 @@ func (bm *BlockManager) GetFileSizeBlocks(location string) (int, error) {
 	if size == 0 {
 		return 0, nil
 	}
-	return int(size) / CONFIG.BlockSize, nil
+	return int(size) / bm.block_size, nil
 }
 
 func (bm *BlockManager) ClearCache() {
-	bm.lruCache = NewLRUCache()
+	bm.lruCache = NewLRUCache(bm.lruCache.capacity)
 }
 
 func (bm *BlockManager) IsCached(location string, blockNumber int) bool {
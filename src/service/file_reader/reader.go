@@ -86,9 +86,17 @@ func (fr *FileReader) readJumboForward(startBlockNum int, initialFlag byte) ([]b
 	var jumboData []byte
 	currentBlockNum := startBlockNum
 
-	// First block should be JumboStart
-	if initialFlag != JumboStart {
-		return nil, 0, fmt.Errorf("expected JumboStart flag, got %d", initialFlag)
+	// Entries are addressed by any block of their sequence, walk back to the start
+	for initialFlag != JumboStart {
+		if currentBlockNum == 0 {
+			return nil, 0, fmt.Errorf("expected JumboStart flag, got %d", initialFlag)
+		}
+		currentBlockNum--
+		block, err := fr.block_manager.ReadBlock(fr.location, currentBlockNum, fr.direction)
+		if err != nil {
+			return nil, 0, err
+		}
+		initialFlag = block[len(block)-1]
 	}
 	readBlocks := 0
 	for {
@@ -116,9 +124,18 @@ func (fr *FileReader) readJumboBackward(startBlockNum int, initialFlag byte) ([]
 	var jumboData []byte
 	currentBlockNum := startBlockNum
 
-	// First block should be JumboEnd when reading backward
-	if initialFlag != JumboEnd {
-		return nil, 0, fmt.Errorf("expected JumboEnd flag when reading backward, got %d", initialFlag)
+	// Entries are addressed by any block of their sequence, when reading backward
+	// walk towards the end of the file until the last block of the sequence
+	for initialFlag != JumboEnd {
+		if currentBlockNum == 0 {
+			return nil, 0, fmt.Errorf("expected JumboEnd flag when reading backward, got %d", initialFlag)
+		}
+		currentBlockNum--
+		block, err := fr.block_manager.ReadBlock(fr.location, currentBlockNum, fr.direction)
+		if err != nil {
+			return nil, 0, err
+		}
+		initialFlag = block[len(block)-1]
 	}
 
 	// Collect blocks in reverse order
@@ -158,9 +175,10 @@ func (fr *FileReader) cleanBlockData(block []byte) []byte {
 	// Remove the last 3 bytes (jumbo flag)
 	dataWithNotation := block[:len(block)-3]
 
-	// Find the notation "<!>" to separate data from padding
+	// Find the notation "<!>" to separate data from padding. Keys and values
+	// may contain it too, but only zero padding follows the real one.
 	notation := []byte("<!>")
-	notationIndex := bytes.Index(dataWithNotation, notation)
+	notationIndex := bytes.LastIndex(dataWithNotation, notation)
 
 	if notationIndex == -1 {
 		// No notation found, return all data (shouldn't happen in normal cases)
@@ -3,38 +3,40 @@ package file_writer
 import (
 	"fmt"
 	"nosqlEngine/src/service/block_manager"
+	"os"
 	"path/filepath"
-	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
 type FileWriter struct {
 	block_manager   block_manager.BlockManager
+	dir             string // directory that file names are relative to
 	location        string
 	currentBlock    []byte
 	currentBlockNum int
 	blockSize       int
 	offsetInBlock   int
 	allDataWritten  []byte
+	staged          bool  // blocks go to location+TmpSuffix until Commit
+	err             error // first block that failed to reach the file, see Err
 }
 
-func getProjectRoot() string {
-	_, filename, _, _ := runtime.Caller(0)
-	// Go up from src/service/file_writer/writer.go to project root
-	projectRoot := filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(filename))))
-	return projectRoot
-}
+// TmpSuffix is appended to a staged file while it is being written, so
+// readers looking for finished files by extension never see it
+const TmpSuffix = ".tmp"
 
-func NewFileWriter(bm *block_manager.BlockManager, blockSize int, name string) *FileWriter {
+// NewFileWriter writes the file dir/name, an empty name picks a new lvl0 SSTable
+func NewFileWriter(bm *block_manager.BlockManager, blockSize int, dir string, name string) *FileWriter {
 	if name == "" {
 		name = generateFileName(0) // Default name if not provided
 	}
-	projectRoot := getProjectRoot()
-	dataPath := filepath.Join(projectRoot, "data")
-	location := filepath.Join(dataPath, name)
+	location := filepath.Join(dir, name)
 	return &FileWriter{
 		block_manager:   *bm,
+		dir:             dir,
 		location:        location,
 		currentBlock:    make([]byte, 0, blockSize),
 		currentBlockNum: 0,
@@ -44,8 +46,82 @@ func NewFileWriter(bm *block_manager.BlockManager, blockSize int, name string) *
 	}
 }
 
+// NewStagedFileWriter is NewFileWriter for files that must appear complete or
+// not at all, the file only shows up at dir/name once Commit is called
+func NewStagedFileWriter(bm *block_manager.BlockManager, blockSize int, dir string, name string) *FileWriter {
+	fw := NewFileWriter(bm, blockSize, dir, name)
+	fw.staged = true
+	return fw
+}
+
+// writeLocation is the path blocks are written to
+func (fw *FileWriter) writeLocation() string {
+	if fw.staged {
+		return fw.location + TmpSuffix
+	}
+	return fw.location
+}
+
+// Err returns the first error writing a block, Write and the flushes keep
+// going after it but the file is incomplete
+func (fw *FileWriter) Err() error {
+	return fw.err
+}
+
+// writeBlock writes block number of the file and remembers the first failure
+func (fw *FileWriter) writeBlock(number int, data []byte) error {
+	err := fw.block_manager.WriteBlock(fw.writeLocation(), number, data)
+	if err != nil && fw.err == nil {
+		fw.err = fmt.Errorf("failed to write block %d of %s: %w", number, fw.location, err)
+	}
+	return err
+}
+
+// Commit makes a staged file durable and atomically moves it to its final
+// location. A file missing a block is never committed.
+func (fw *FileWriter) Commit() error {
+	if fw.err != nil {
+		return fw.err
+	}
+	if !fw.staged {
+		return nil
+	}
+	if err := fw.block_manager.Sync(fw.writeLocation()); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", fw.location, err)
+	}
+	if err := os.Rename(fw.writeLocation(), fw.location); err != nil {
+		return fmt.Errorf("failed to commit %s: %w", fw.location, err)
+	}
+	return fw.block_manager.Sync(filepath.Dir(fw.location))
+}
+
+// Discard deletes a staged file that will not be committed
+func (fw *FileWriter) Discard() error {
+	if !fw.staged {
+		return nil
+	}
+	return os.Remove(fw.writeLocation())
+}
+
 func generateFileName(level int) string {
-	return fmt.Sprintf("sstable/lvl%d/sstable_%s.db", level, uuid.New().String())
+	return fmt.Sprintf("lvl%d/sstable_%s.db", level, uuid.New().String())
+}
+
+// TableName names the SSTable of level with file number, numbers grow with
+// every table written so the newer of two tables has the larger one
+func TableName(level int, number uint64) string {
+	return fmt.Sprintf("lvl%d/sstable_%06d.db", level, number)
+}
+
+// TableNumber returns the file number in the name of the SSTable at path, 0
+// for tables named before file numbers existed
+func TableNumber(path string) uint64 {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "sstable_"), ".db")
+	number, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return number
 }
 
 func (fw *FileWriter) Write(data []byte, sectionEnd bool, size []byte) int {
@@ -59,8 +135,11 @@ func (fw *FileWriter) Write(data []byte, sectionEnd bool, size []byte) int {
 	}
 
 	if fw.IsJumbo(len(data)) {
+		// return the first block of the sequence, like for data that fits a block
+		fw.FlushCurrentBlock()
+		start := fw.currentBlockNum
 		fw.WriteJumboData(data)
-		return fw.currentBlockNum
+		return start
 	}
 
 	if !fw.CanWrite(len(data)) {
@@ -165,7 +244,7 @@ func (fw *FileWriter) WriteJumboData(data []byte) {
 		wrData = append(wrData, jumboFlag...)
 
 		fw.allDataWritten = append(fw.allDataWritten, wrData...)
-		err := fw.block_manager.WriteBlock(fw.location, fw.currentBlockNum, wrData)
+		err := fw.writeBlock(fw.currentBlockNum, wrData)
 
 		if err != nil {
 			fmt.Printf("Error writing jumbo block %d: %v\n", fw.currentBlockNum, err)
@@ -178,13 +257,15 @@ func (fw *FileWriter) WriteJumboData(data []byte) {
 	}
 }
 
-// CanWrite checks if the data can fit in the current block (reserving 3 bytes for jumbo flag)
+// CanWrite checks if the data can fit in the current block (reserving 3 bytes for
+// the <!> notation and 3 bytes for jumbo flag)
 func (fw *FileWriter) CanWrite(dataLen int) bool {
-	return fw.offsetInBlock+dataLen+3 <= fw.blockSize // Reserve 3 bytes for jumbo flag
+	return fw.offsetInBlock+dataLen+6 <= fw.blockSize // Reserve 6 bytes for notation and jumbo flag
 }
 
-// FlushCurrentBlock writes the current block to disk and starts a new block
-func (fw *FileWriter) FlushCurrentBlock() {
+// FlushCurrentBlock writes the current block to disk and starts a new block.
+// It returns the first error of any block written so far.
+func (fw *FileWriter) FlushCurrentBlock() error {
 	// when flushing we add a flag at the end of data to indicate that the rest is padding
 	if len(fw.currentBlock) > 0 {
 		// Add jumbo flag (0 = not jumbo)
@@ -193,7 +274,7 @@ func (fw *FileWriter) FlushCurrentBlock() {
 		notation := "<!>"       //data end notation
 		notationBytes := []byte(notation)
 		//add padding to ensure block size (accounting for 3-byte jumbo flag)
-		if len(fw.currentBlock)+3+3 < fw.blockSize {
+		if len(fw.currentBlock)+3+3 <= fw.blockSize {
 			padding := make([]byte, fw.blockSize-len(fw.currentBlock)-3-3)
 			fw.currentBlock = append(fw.currentBlock, notationBytes...)
 			fw.currentBlock = append(fw.currentBlock, padding...)
@@ -202,11 +283,12 @@ func (fw *FileWriter) FlushCurrentBlock() {
 		// Add jumbo flag at the end
 		fw.currentBlock = append(fw.currentBlock, jumboFlag...)
 		fw.allDataWritten = append(fw.allDataWritten, fw.currentBlock...)
-		fw.block_manager.WriteBlock(fw.location, fw.currentBlockNum, fw.currentBlock)
+		fw.writeBlock(fw.currentBlockNum, fw.currentBlock)
 		fw.currentBlockNum++
 		fw.currentBlock = make([]byte, 0, fw.blockSize)
 		fw.offsetInBlock = 0
 	}
+	return fw.err
 }
 
 func (fw *FileWriter) FlushWithSize(size []byte) {
@@ -230,7 +312,7 @@ func (fw *FileWriter) FlushWithSize(size []byte) {
 	// If the current block is already full, we need to flush it first
 
 	fw.allDataWritten = append(fw.allDataWritten, fw.currentBlock...)
-	fw.block_manager.WriteBlock(fw.location, fw.currentBlockNum, fw.currentBlock)
+	fw.writeBlock(fw.currentBlockNum, fw.currentBlock)
 	fw.currentBlockNum++
 	fw.currentBlock = make([]byte, 0, fw.blockSize)
 	fw.offsetInBlock = 0
@@ -252,12 +334,11 @@ func (fw *FileWriter) ResetFileWriter(name string) {
 	if name == "" {
 		name = generateFileName(0) // Default name if not provided
 	}
-	projectRoot := getProjectRoot()
-	dataPath := filepath.Join(projectRoot, "data")
-	location := filepath.Join(dataPath, name)
+	location := filepath.Join(fw.dir, name)
 	fw.currentBlock = make([]byte, 0, fw.blockSize)
 	fw.currentBlockNum = 0
 	fw.offsetInBlock = 0
 	fw.allDataWritten = make([]byte, 0)
 	fw.location = location
+	fw.err = nil
 }
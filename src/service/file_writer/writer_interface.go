@@ -3,4 +3,5 @@ package file_writer
 type FileWriterInterface interface {
 	Write(data []byte, sectionEnd bool, size []byte) int
 	ResetFileWriter(name string)
+	Commit() error
 }
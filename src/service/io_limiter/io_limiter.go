@@ -0,0 +1,36 @@
+package io_limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// IOLimiter paces reads or writes to a number of bytes per second. It is
+// shared by all its callers, who together stay under the rate.
+type IOLimiter struct {
+	lock sync.Mutex
+	rate int       // bytes per second, 0 for no limit
+	next time.Time // when the bytes granted so far are used up
+}
+
+// NewIOLimiter builds a limiter of bytesPerSecond, 0 never waits
+func NewIOLimiter(bytesPerSecond int) *IOLimiter {
+	return &IOLimiter{rate: bytesPerSecond}
+}
+
+// Wait blocks until n more bytes fit the rate
+func (l *IOLimiter) Wait(n int) {
+	if l.rate <= 0 || n <= 0 {
+		return
+	}
+	l.lock.Lock()
+	now := time.Now()
+	// time left unused does not add up to a burst later
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	l.lock.Unlock()
+	time.Sleep(wait)
+}
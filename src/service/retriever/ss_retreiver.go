@@ -1,20 +1,22 @@
 package retriever
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"nosqlEngine/src/config"
 	"nosqlEngine/src/models/bloom_filter"
+	"nosqlEngine/src/models/key_value"
 	"nosqlEngine/src/service/block_manager"
 	"nosqlEngine/src/service/file_reader"
-	"nosqlEngine/src/utils"
+	"nosqlEngine/src/service/ss_parser"
 )
 
-var CONFIG = config.GetConfig()
-
 type EntryRetriever struct {
 	fileReader   file_reader.FileReader
 	sstablePaths []string
 	currentIndex int
+	cfg          config.Config
 
 }
 
@@ -27,6 +29,7 @@ type EntryRetrieverPool struct {
 	currentBlocks  []int64  // Current block index for each reader
 	blockPositions []int    // Current position within block for each reader
 	cachedBlocks   [][]byte // Cached cleaned block data for each reader
+	cfg            config.Config
 }
 
 
@@ -36,29 +39,18 @@ type EntryRetrieverPool struct {
 
 
 
-func NewEntryRetriever(bm *block_manager.BlockManager) *EntryRetriever {
-	// Initialize SSTable pool by scanning for sstable files
-	sstablePaths := getFilesFromLevel(0)
-
-	// Create a single block manager and file reader instance
-	var fileReader file_reader.FileReader
-
-	if len(sstablePaths) > 0 {
-		// Initialize with the first SSTable if available
-		fileReader = *file_reader.NewFileReader(sstablePaths[0], CONFIG.BlockSize, *bm)
-	} else {
-		// Initialize with empty path if no SSTables found
-		fileReader = *file_reader.NewFileReader("", CONFIG.BlockSize, *bm)
-	}
-
+// NewEntryRetriever builds a retriever for point lookups. It lists no tables
+// itself, every lookup gets the tables to read from the caller, usually from
+// the current version of the manifest.
+func NewEntryRetriever(bm *block_manager.BlockManager, cfg config.Config) *EntryRetriever {
 	return &EntryRetriever{
-		fileReader:   fileReader,
-		sstablePaths: sstablePaths,
+		fileReader:   *file_reader.NewFileReader("", cfg.BlockSize, *bm),
 		currentIndex: 0,
+		cfg:          cfg,
 	}
 }
 
-func NewEntryRetrieverPool(bm *block_manager.BlockManager, tables []string) *EntryRetrieverPool {
+func NewEntryRetrieverPool(bm *block_manager.BlockManager, tables []string, cfg config.Config) *EntryRetrieverPool {
 
 	fileReaders := make([]file_reader.FileReader, len(tables))
 	readersPerMetadata := make([]Metadata, len(tables))
@@ -68,9 +60,9 @@ func NewEntryRetrieverPool(bm *block_manager.BlockManager, tables []string) *Ent
 	cachedBlocks := make([][]byte, len(tables))
 
 	for i, table := range tables {
-		fileReaders[i] = *file_reader.NewFileReader(table, CONFIG.BlockSize, *bm)
+		fileReaders[i] = *file_reader.NewFileReader(table, cfg.BlockSize, *bm)
 		fileReaders[i].SetDirection(false) // Set default direction to forward
-		md, err := deserializeMetadataOnly(fileReaders[i])
+		md, err := deserializeMetadataOnly(fileReaders[i], cfg.BlockSize)
 		if err != nil {
 			readersPerMetadata[i] = Metadata{}
 		} else {
@@ -93,6 +85,7 @@ func NewEntryRetrieverPool(bm *block_manager.BlockManager, tables []string) *Ent
 		currentBlocks:  currentBlocks,
 		blockPositions: blockPositions,
 		cachedBlocks:   cachedBlocks,
+		cfg:            cfg,
 	}
 }
 
@@ -103,21 +96,21 @@ func (ep *EntryRetrieverPool) GetMetadata(index int) *Metadata {
 	return &ep.metadata[index]
 }
 
-func (r *EntryRetrieverPool) ReadNextVal(readerIndex int) (string, string, bool, error) {
+func (r *EntryRetrieverPool) ReadNextVal(readerIndex int) (key_value.KeyValue, error) {
 
 	//check the if there is a cached block
 	if r.cachedBlocks[readerIndex] == nil || r.blockPositions[readerIndex] >= len(r.cachedBlocks[readerIndex])-16 {
 		err := r.loadNextBlock(readerIndex)
 		if err != nil {
-			return "", "", false, fmt.Errorf("error loading next block: %v", err)
+			return key_value.KeyValue{}, fmt.Errorf("error loading next block: %v", err)
 		}
 	}
-	key, value, bytesRead, err := readDataEntry(r.cachedBlocks[readerIndex][r.blockPositions[readerIndex]:])
+	entry, bytesRead, err := readDataEntry(r.cachedBlocks[readerIndex][r.blockPositions[readerIndex]:])
 	r.blockPositions[readerIndex] += bytesRead
 	if err != nil {
-		return "", "", false, fmt.Errorf("error reading data entry: %v", err)
+		return key_value.KeyValue{}, fmt.Errorf("error reading data entry: %v", err)
 	}
-	return key, value, false, nil
+	return entry, nil
 }
 
 func (r *EntryRetrieverPool) loadNextBlock(readerIndex int) error {
@@ -139,85 +132,78 @@ func (r *EntryRetrieverPool) loadNextBlock(readerIndex int) error {
 	return nil
 }
 
-func (r *EntryRetriever) resetToNextSSTable() bool {
-	r.currentIndex++
-	if r.currentIndex >= len(r.sstablePaths) {
-		return false
+// ErrNotFound is returned by RetrieveEntryAt when no table holds a version of
+// the key it may return. Any other error means a table could not be read.
+var ErrNotFound = errors.New("key not found in any SSTable")
+
+// RetrieveEntryAt looks key up in tables and returns its newest version
+// written up to maxSeq. Tables are read in the order given, newest first, and
+// the first version found is returned without reading the rest. A found
+// tombstone is returned as is, so the caller can tell a deleted key from one
+// that was never written. The lookup moves on to the next table only when the
+// bloom filter, the key range or the index rule the key out. A table that
+// cannot be read fails it, since an older table may hold a stale version.
+func (r *EntryRetriever) RetrieveEntryAt(key string, tables []string, maxSeq uint64) (key_value.KeyValue, bool, error) {
+	r.sstablePaths = tables
+	for r.currentIndex = 0; r.currentIndex < len(r.sstablePaths); r.currentIndex++ {
+		r.fileReader.ResetReader(r.sstablePaths[r.currentIndex], false)
+		entry, err := r.retrieveFromTable(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return key_value.KeyValue{}, false, fmt.Errorf("failed to look up key %s in %s: %w", key, r.sstablePaths[r.currentIndex], err)
+		}
+		// a table holds one version of a key, one written after maxSeq
+		// means an older table may hold the version to return
+		if entry.GetSeq() <= maxSeq {
+			return entry, true, nil
+		}
 	}
-
-	r.fileReader.ResetReader(r.sstablePaths[r.currentIndex], false)
-	return true
+	return key_value.KeyValue{}, false, ErrNotFound
 }
 
-func (r *EntryRetriever) RetrieveEntry(key string) (string, bool, error) {
-
-	r.currentIndex = 0 // Reset to first SSTable
-	r.sstablePaths = []string{}
-	for i := 0; i <= CONFIG.LSMLevels; i++ {
-		r.sstablePaths = append(r.sstablePaths, utils.GetPaths("data/sstable/lvl"+fmt.Sprint(i), ".db")...)
+// retrieveFromTable reads the version of key in the current table, an error
+// wrapping ErrNotFound means the table does not hold the key
+func (r *EntryRetriever) retrieveFromTable(key string) (key_value.KeyValue, error) {
+	r.fileReader.SetDirection(false)
+	md, err := r.deserializeMetadata(key)
+	if err != nil {
+		return key_value.KeyValue{}, err
 	}
-	if len(r.sstablePaths) == 0 {
-		return "", false, fmt.Errorf("no SSTables found")
+	sumArray, err := r.deserializeSummary(md)
+	if err != nil {
+		return key_value.KeyValue{}, err
 	}
-	r.fileReader.ResetReader(r.sstablePaths[r.currentIndex], false)
 
-	for {
-		r.fileReader.SetDirection(false)
-		md, err := r.deserializeMetadata(key)
-		if err != nil {
-			if !r.resetToNextSSTable() {
-				return "",false, fmt.Errorf("key %s not found in any SSTable", key)
+	compare := r.cfg.Comparator().CompareStrings
+	for i := 0; i < len(sumArray); i++ {
+		next := i + 1
+		if next == len(sumArray) {
+			if i > 0 {
+				break
 			}
-			continue
+			next = i // a table with a single index entry has a single summary entry
 		}
-
-		sumArray, errSum := r.deserializeSummary(md)
-		if errSum != nil {
-			if !r.resetToNextSSTable() {
-				return "",false, fmt.Errorf("key %s not found in any SSTable", key)
-			}
+		if compare(key, sumArray[i].getKey()) < 0 || compare(key, sumArray[next].getKey()) > 0 {
 			continue
 		}
-
-		found := false
-		for i := 0; i < len(sumArray)-1; i++ {
-			if key >= sumArray[i].getKey() && key <= sumArray[i+1].getKey() {
-				found = true
-				// Key found, read the entry from the file
-				//search the offsets
-				//ending offset is sumArray[i].getOffset()
-				//starting offset is sumArray[i+1].getOffset()
-				totalBlocks, _ := r.fileReader.GetFileSizeBlocks()
-				endOffset := sumArray[i].getOffset()
-				endOffset = int64(totalBlocks) - endOffset
-				startOffset := sumArray[i+1].getOffset()
-				startOffset = int64(totalBlocks) - startOffset - 1
-
-				offset, err := r.searchIndex(startOffset, endOffset, key)
-				if err != nil {
-					break // Break inner loop, try next SSTable
-				}
-
-				dataOffset := int64(totalBlocks) - offset - 1
-
-				value, dataErr := r.searchData(dataOffset, key)
-				if dataErr != nil {
-					fmt.Printf("Error searching data in %s: %v\n", r.sstablePaths[r.currentIndex], dataErr)
-					break // Break inner loop, try next SSTable
-				}
-				return value, true, nil // Found the key, return the value
-			}
-		}
-
-		if !found {
-			fmt.Printf("Key %s not found in range in %s\n", key, r.sstablePaths[r.currentIndex])
+		// the summary entries point at the index blocks counted from the end
+		// of the file, which holds the key between them
+		totalBlocks, err := r.fileReader.GetFileSizeBlocks()
+		if err != nil {
+			return key_value.KeyValue{}, fmt.Errorf("error getting file size blocks: %v", err)
 		}
+		endOffset := int64(totalBlocks) - sumArray[i].getOffset()
+		startOffset := int64(totalBlocks) - sumArray[next].getOffset() - 1
 
-		// Try next SSTable
-		if !r.resetToNextSSTable() {
-			return "", false, fmt.Errorf("key %s not found in any SSTable", key)
+		offset, err := r.searchIndex(startOffset, endOffset, key)
+		if err != nil {
+			return key_value.KeyValue{}, err
 		}
+		return r.searchData(int64(totalBlocks)-offset-1, key)
 	}
+	return key_value.KeyValue{}, fmt.Errorf("%w: key %s is outside the key range", ErrNotFound, key)
 }
 
 func (r *EntryRetriever) deserializeMetadata(key string) (Metadata, error) {
@@ -236,7 +222,7 @@ func (r *EntryRetriever) deserializeMetadata(key string) (Metadata, error) {
 		return Metadata{}, fmt.Errorf("error getting file size blocks: %v", err)
 	}
 	numOfBlocks := int64(totalBlocks) - mdOffset
-	completedBlocks := make([]byte, 0, int(numOfBlocks)*CONFIG.BlockSize)
+	completedBlocks := make([]byte, 0, int(numOfBlocks)*r.cfg.BlockSize)
 	completedBlocks = append(completedBlocks, initial...)
 	for i < int(numOfBlocks) {
 		block, readBlocks, err := r.fileReader.ReadEntry(i)
@@ -267,7 +253,7 @@ func (r *EntryRetriever) deserializeMetadata(key string) (Metadata, error) {
 
 	ex := b.Check(key)
 	if !ex {
-		return Metadata{}, fmt.Errorf("key %s not found in bloom filter", key)
+		return Metadata{}, fmt.Errorf("%w: key %s not in bloom filter", ErrNotFound, key)
 	}
 
 	sum_start_offset := bytesToInt(completedBlocks[offsetInBlock : offsetInBlock+8])
@@ -372,32 +358,32 @@ func (r *EntryRetriever) searchIndex(start int64, end int64, key string) (int64,
 		i += int64(readBlocks)
 	}
 
-	return 0, fmt.Errorf("key %s not found in index", key)
+	return 0, fmt.Errorf("%w: key %s not in index", ErrNotFound, key)
 }
 
-func (r *EntryRetriever) searchData(offset int64, key string) (string, error) {
+func (r *EntryRetriever) searchData(offset int64, key string) (key_value.KeyValue, error) {
 	data, _, err := r.fileReader.ReadEntry(int(offset))
 	if err != nil {
-		return "", fmt.Errorf("error reading data at offset %d: %v", offset, err)
+		return key_value.KeyValue{}, fmt.Errorf("error reading data at offset %d: %v", offset, err)
 	}
 	offsetInBlock := 0
 	for offsetInBlock < len(data) {
 
-		keyRetrieved, value, off, err := readDataEntry(data[offsetInBlock:])
+		entry, off, err := readDataEntry(data[offsetInBlock:])
 		offsetInBlock += off
 		if err != nil {
-			return "", fmt.Errorf("error reading summary entry: %v", err)
+			return key_value.KeyValue{}, fmt.Errorf("error reading summary entry: %v", err)
 		}
-		if keyRetrieved == key {
-			return value, nil // Found the key, return the offset
+		if entry.GetKey() == key {
+			return entry, nil // Found the key, return the entry
 		}
 	}
-	return "", fmt.Errorf("data not found for key %s at offset %d", key, offset)
+	return key_value.KeyValue{}, fmt.Errorf("data not found for key %s at offset %d", key, offset)
 }
 
-func readDataEntry(data []byte) (string, string, int, error) {
-	if len(data) < 16 {
-		return "", "", 0, fmt.Errorf("invalid data entry")
+func readDataEntry(data []byte) (key_value.KeyValue, int, error) {
+	if len(data) < 18 {
+		return key_value.KeyValue{}, 0, fmt.Errorf("invalid data entry")
 	}
 	off := 0
 	keySize := bytesToInt(data[:8])
@@ -408,6 +394,24 @@ func readDataEntry(data []byte) (string, string, int, error) {
 	off += 8
 	value := data[16+keySize : 16+keySize+valueSize]
 	off += int(valueSize)
-	return string(key), string(value), off, nil
+	flags := data[off]
+	off += 1
+	seq, n := binary.Uvarint(data[off:])
+	if n <= 0 {
+		return key_value.KeyValue{}, 0, fmt.Errorf("invalid sequence number in data entry")
+	}
+	off += n
+	if flags&ss_parser.FlagTombstone != 0 {
+		return key_value.NewTombstone(string(key)).WithSeq(seq), off, nil
+	}
+	entry := key_value.NewKeyValue(string(key), string(value)).WithSeq(seq)
+	if flags&ss_parser.FlagExpires != 0 {
+		if len(data)-off < 8 {
+			return key_value.KeyValue{}, 0, fmt.Errorf("invalid expiry in data entry")
+		}
+		entry = entry.WithExpiry(bytesToInt(data[off : off+8]))
+		off += 8
+	}
+	return entry, off, nil
 }
 
@@ -4,10 +4,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"nosqlEngine/src/service/file_reader"
-	"os"
-	"path/filepath"
-	"runtime"
-	"strings"
 )
 
 type Metadata struct {
@@ -58,7 +54,7 @@ func (metadata *Metadata) GetMerkleData() []byte {
 func (metadata *Metadata) GetBloomFilter() []byte {
 	return metadata.bf_data
 }
-func deserializeMetadataOnly(reader file_reader.FileReader) (Metadata, error) {
+func deserializeMetadataOnly(reader file_reader.FileReader, blockSize int) (Metadata, error) {
 	i := 0
 	initial, readBlocks, err := reader.ReadEntry(i)
 	if err != nil {
@@ -74,7 +70,7 @@ func deserializeMetadataOnly(reader file_reader.FileReader) (Metadata, error) {
 	}
 
 	numOfBlocks := int64(totalBlocks) - mdOffset
-	completedBlocks := make([]byte, 0, int(numOfBlocks)*CONFIG.BlockSize)
+	completedBlocks := make([]byte, 0, int(numOfBlocks)*blockSize)
 	completedBlocks = append(completedBlocks, initial...)
 	for i < int(numOfBlocks) {
 		block, readBlocks, err := reader.ReadEntry(i)
@@ -132,29 +128,6 @@ func deserializeMetadataOnly(reader file_reader.FileReader) (Metadata, error) {
 
 	return md, nil
 }
-func getProjectRoot() string {
-	_, filename, _, _ := runtime.Caller(0)
-	// Go up from src/service/file_writer/writer.go to project root
-	projectRoot := filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(filename))))
-	return projectRoot
-}
-func getFilesFromLevel(level int) []string {
-	var sstablePaths []string
-
-	sstableDir := filepath.ToSlash(filepath.Join(getProjectRoot(), "data/sstable"))
-	sstablePaths = make([]string, 0)
-
-	files, _ := os.ReadDir(sstableDir + "/lvl" + fmt.Sprint(level))
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".db") {
-			continue
-		}
-		sstablePaths = append(sstablePaths, filepath.Join(sstableDir+"/lvl"+fmt.Sprint(level), file.Name()))
-	}
-
-	return sstablePaths
-}
-
 func bytesToInt(buf []byte) int64 {
 	return int64(binary.BigEndian.Uint64(buf))
 
@@ -0,0 +1,228 @@
+package retriever
+
+import (
+	"fmt"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/models/key_value"
+	"nosqlEngine/src/service/block_manager"
+	"nosqlEngine/src/service/file_reader"
+	"sort"
+)
+
+// TableIterator walks the entries of one SSTable in key order, either way. It
+// keeps the summary of the table and the decoded entries of one data block in
+// memory, and reads a neighbouring block only once it walks past the current one.
+type TableIterator struct {
+	reader     file_reader.FileReader
+	table      string
+	resolve    func(table string) string // where the table can be read now, a pinned table may be moved
+	compare    func(a, b string) int
+	summary    []KeyOffset          // every SUMMARY_STEP-th index entry, in key order
+	indexEnd   int64                // first block after the index
+	dataEnd    int64                // first block after the data section
+	entries    []key_value.KeyValue // entries of the current data block
+	pos        int
+	blockFirst int64 // first block of the current data block, more than one for jumbo entries
+	blockNext  int64 // first block after it
+	valid      bool
+	err        error
+}
+
+// NewTableIterator opens table for iteration, reading its metadata and
+// summary. The iterator is not positioned until Seek is called. resolve maps
+// the table to where it can be read before every block, a nil resolve reads
+// it where it is.
+func NewTableIterator(bm *block_manager.BlockManager, cfg config.Config, table string, resolve func(table string) string) (*TableIterator, error) {
+	if resolve == nil {
+		resolve = func(table string) string { return table }
+	}
+	reader := *file_reader.NewFileReader(resolve(table), cfg.BlockSize, *bm)
+	reader.SetDirection(false)
+	md, err := deserializeMetadataOnly(reader, cfg.BlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata of %s: %w", table, err)
+	}
+	totalBlocks, err := reader.GetFileSizeBlocks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read size of %s: %w", table, err)
+	}
+	it := &TableIterator{
+		reader:   reader,
+		table:    table,
+		resolve:  resolve,
+		compare:  cfg.Comparator().CompareStrings,
+		indexEnd: int64(totalBlocks) - md.summary_end,
+	}
+	it.reader.SetDirection(true)
+	if md.num_of_items == 0 {
+		return it, nil
+	}
+	// the summary is stored from the end of the file backward, read it forward
+	for block := it.indexEnd; block < int64(totalBlocks)-md.summary_start; {
+		data, readBlocks, err := it.reader.Read(int(block))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read summary of %s: %w", table, err)
+		}
+		for len(data) > 0 {
+			key, offset, n, err := readSummaryIndexEntry(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read summary of %s: %w", table, err)
+			}
+			it.summary = append(it.summary, KeyOffset{key: key, offset: offset})
+			data = data[n:]
+		}
+		block += int64(readBlocks)
+	}
+	if len(it.summary) > 0 {
+		// the first index entry follows the last data block
+		it.dataEnd = it.summary[0].offset
+	}
+	return it, nil
+}
+
+// Seek positions the iterator at the first entry with a key >= key
+func (it *TableIterator) Seek(key string) {
+	it.valid, it.err = false, nil
+	if it.dataEnd == 0 {
+		return
+	}
+	start := int64(0)
+	// the last summary entry at or before key points to the index block to search
+	if i := sort.Search(len(it.summary), func(i int) bool { return it.compare(it.summary[i].key, key) > 0 }) - 1; i >= 0 {
+		block, err := it.seekIndex(it.summary[i].offset, key)
+		if err != nil {
+			it.err = err
+			return
+		}
+		start = block
+	}
+	if !it.load(start, false) {
+		return
+	}
+	it.pos, it.valid = 0, true
+	for it.valid && it.compare(it.Entry().GetKey(), key) < 0 {
+		it.Next()
+	}
+}
+
+// SeekForPrev positions the iterator at the last entry with a key <= key
+func (it *TableIterator) SeekForPrev(key string) {
+	it.Seek(key)
+	if it.err != nil {
+		return
+	}
+	if !it.valid {
+		it.SeekToLast()
+	} else if it.compare(it.Entry().GetKey(), key) > 0 {
+		it.Prev()
+	}
+}
+
+// SeekToFirst positions the iterator at the first entry of the table
+func (it *TableIterator) SeekToFirst() {
+	it.valid, it.err = false, nil
+	if it.dataEnd == 0 || !it.load(0, false) {
+		return
+	}
+	it.pos, it.valid = 0, true
+}
+
+// SeekToLast positions the iterator at the last entry of the table
+func (it *TableIterator) SeekToLast() {
+	it.valid, it.err = false, nil
+	if it.dataEnd == 0 || !it.load(it.dataEnd-1, true) {
+		return
+	}
+	it.pos, it.valid = len(it.entries)-1, true
+}
+
+// seekIndex returns the data block of the last index entry at or before key,
+// searching the index from block on
+func (it *TableIterator) seekIndex(block int64, key string) (int64, error) {
+	found := int64(0)
+	for block < it.indexEnd {
+		it.reader.SetLocation(it.resolve(it.table))
+		data, readBlocks, err := it.reader.Read(int(block))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read index of %s: %w", it.table, err)
+		}
+		for len(data) > 0 {
+			indexKey, offset, n, err := readSummaryIndexEntry(data)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read index of %s: %w", it.table, err)
+			}
+			if it.compare(indexKey, key) > 0 {
+				return found, nil
+			}
+			found = offset
+			data = data[n:]
+		}
+		block += int64(readBlocks)
+	}
+	return found, nil
+}
+
+// load decodes the data block that starts at block, or that ends at block
+// when walking backward
+func (it *TableIterator) load(block int64, backward bool) bool {
+	it.reader.SetLocation(it.resolve(it.table))
+	data, readBlocks, err := it.reader.Read(int(block))
+	if err != nil {
+		it.valid, it.err = false, fmt.Errorf("failed to read block %d of %s: %w", block, it.table, err)
+		return false
+	}
+	if backward {
+		block -= int64(readBlocks) - 1
+	}
+	it.entries = it.entries[:0]
+	for len(data) > 0 {
+		entry, n, err := readDataEntry(data)
+		if err != nil {
+			it.valid, it.err = false, fmt.Errorf("failed to read entry of %s: %w", it.table, err)
+			return false
+		}
+		it.entries = append(it.entries, entry)
+		data = data[n:]
+	}
+	it.blockFirst, it.blockNext = block, block+int64(readBlocks)
+	return len(it.entries) > 0
+}
+
+// Valid reports whether the iterator is positioned at an entry
+func (it *TableIterator) Valid() bool {
+	return it.valid
+}
+
+// Entry returns the current entry, tombstones included
+func (it *TableIterator) Entry() key_value.KeyValue {
+	return it.entries[it.pos]
+}
+
+// Next moves to the following entry
+func (it *TableIterator) Next() {
+	if !it.valid {
+		return
+	}
+	if it.pos++; it.pos < len(it.entries) {
+		return
+	}
+	it.valid = it.blockNext < it.dataEnd && it.load(it.blockNext, false)
+	it.pos = 0
+}
+
+// Prev moves to the preceding entry
+func (it *TableIterator) Prev() {
+	if !it.valid {
+		return
+	}
+	if it.pos--; it.pos >= 0 {
+		return
+	}
+	it.valid = it.blockFirst > 0 && it.load(it.blockFirst-1, true)
+	it.pos = len(it.entries) - 1
+}
+
+// Err returns the error that made the iterator stop early, if any
+func (it *TableIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,69 @@
+package ss_compacter
+
+import (
+	"fmt"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/service/block_manager"
+	"nosqlEngine/src/service/table_pins"
+	"nosqlEngine/src/storage/manifest"
+	"sync"
+)
+
+// Compacter merges SSTables after flushes to keep reads and disk usage in check
+type Compacter interface {
+	// CheckCompactionConditions runs every compaction that is due and reports
+	// whether any table was compacted
+	CheckCompactionConditions(bm *block_manager.BlockManager) bool
+	// pick returns the compaction due first that reads and writes no level in
+	// busy, nil when there is none
+	pick(busy map[int]bool) *job
+	// pickRange returns the compaction moving the tables of level that hold
+	// keys from start to end into the next level, nil when there are none
+	pickRange(level int, start, end string) *job
+	// compact runs j, calling pace, when set, with the size of every entry read
+	compact(j *job, bm *block_manager.BlockManager, pace func(bytes int)) (CompactionResult, error)
+}
+
+// CompactionResult describes one finished compaction
+type CompactionResult struct {
+	Level             int // the level read, the output went to the next one
+	Inputs            int // tables merged
+	Outputs           int // tables written
+	TombstonesDropped int // tombstones left out, along with the versions they hid
+}
+
+// job is one compaction, merging inputs of level into level+1
+type job struct {
+	level  int
+	inputs []manifest.TableMeta
+}
+
+// NewCompacter builds the compacter COMPACTION_STRATEGY names
+func NewCompacter(cfg config.Config, filesLock *sync.RWMutex, pins *table_pins.TablePins, m *manifest.Manifest) Compacter {
+	if cfg.CompactionStrategy == "leveled" {
+		return NewLeveledCompacter(cfg, filesLock, pins, m)
+	}
+	return NewSSCompacterST(cfg, filesLock, pins, m)
+}
+
+// runDue runs the compactions of c that are due one after another, as
+// CheckCompactionConditions does
+func runDue(c Compacter, bm *block_manager.BlockManager) bool {
+	compacted := false
+	for {
+		j := c.pick(nil)
+		if j == nil {
+			return compacted
+		}
+		if _, err := c.compact(j, bm, nil); err != nil {
+			fmt.Printf("Error compacting lvl%d: %v\n", j.level, err)
+			return compacted
+		}
+		compacted = true
+	}
+}
+
+// overlaps reports whether the keys of table meet the range from start to end
+func overlaps(table manifest.TableMeta, start, end string, compare func(a, b string) int) bool {
+	return table.Entries > 0 && compare(table.FirstKey, end) <= 0 && compare(table.LastKey, start) >= 0
+}
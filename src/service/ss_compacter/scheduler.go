@@ -0,0 +1,205 @@
+package ss_compacter
+
+import (
+	"fmt"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/service/block_manager"
+	"nosqlEngine/src/service/io_limiter"
+	"sync"
+)
+
+// Scheduler runs the compactions of a compacter in the background, up to
+// COMPACTION_WORKERS of them at a time. A compaction reserves the level it
+// reads and the level it writes, so two compactions never share a table.
+// Running compactions pause while a flush is in progress and together read
+// at most COMPACTION_RATE_LIMIT bytes per second.
+type Scheduler struct {
+	compacter Compacter
+	bm        *block_manager.BlockManager
+	limiter   *io_limiter.IOLimiter
+	cfg       config.Config
+	lock      sync.Mutex
+	changed   *sync.Cond   // signalled on lock whenever a job or flush ends, or compaction may be due
+	busy      map[int]bool // levels reserved by running jobs
+	running   int
+	flushing  int
+	paused    bool // a job failed, nothing is picked until the next Trigger
+	closed    bool
+	stats     CompactionStats
+	done      chan struct{}
+}
+
+// CompactionStats sums up the compactions a scheduler finished
+type CompactionStats struct {
+	Compactions       int
+	TombstonesDropped int
+	Last              CompactionResult // the latest compaction, zero before the first
+}
+
+// NewScheduler starts scheduling the compactions of compacter
+func NewScheduler(compacter Compacter, bm *block_manager.BlockManager, cfg config.Config) *Scheduler {
+	s := &Scheduler{
+		compacter: compacter,
+		bm:        bm,
+		limiter:   io_limiter.NewIOLimiter(cfg.CompactionRateLimit),
+		cfg:       cfg,
+		busy:      map[int]bool{},
+		done:      make(chan struct{}),
+	}
+	s.changed = sync.NewCond(&s.lock)
+	go s.run()
+	return s
+}
+
+// run starts every compaction that is due while workers are free. Once the
+// scheduler is closed it finishes what is due and returns.
+func (s *Scheduler) run() {
+	defer close(s.done)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for {
+		for !s.paused && s.running < s.cfg.CompactionWorkers {
+			j := s.compacter.pick(s.busy)
+			if j == nil {
+				break
+			}
+			s.reserve(j)
+			go s.work(j)
+		}
+		if s.closed && s.running == 0 {
+			return
+		}
+		s.changed.Wait()
+	}
+}
+
+func (s *Scheduler) work(j *job) {
+	result, err := s.compacter.compact(j, s.bm, s.pace)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err != nil {
+		// the inputs stay, retrying right away would likely fail the same way
+		fmt.Printf("Error compacting lvl%d: %v\n", j.level, err)
+		s.paused = true
+	} else {
+		s.record(result)
+	}
+	s.release(j)
+}
+
+// record adds a finished compaction to the stats. The caller holds lock.
+func (s *Scheduler) record(result CompactionResult) {
+	s.stats.Compactions++
+	s.stats.TombstonesDropped += result.TombstonesDropped
+	s.stats.Last = result
+}
+
+// reserve marks the levels of j as busy. The caller holds lock.
+func (s *Scheduler) reserve(j *job) {
+	s.busy[j.level], s.busy[j.level+1] = true, true
+	s.running++
+}
+
+// release frees the levels of j. The caller holds lock.
+func (s *Scheduler) release(j *job) {
+	delete(s.busy, j.level)
+	delete(s.busy, j.level+1)
+	s.running--
+	s.changed.Broadcast()
+}
+
+// pace holds a running job back while a flush is in progress and keeps it to
+// the rate limit
+func (s *Scheduler) pace(bytes int) {
+	s.lock.Lock()
+	for s.flushing > 0 {
+		s.changed.Wait()
+	}
+	s.lock.Unlock()
+	s.limiter.Wait(bytes)
+}
+
+// Trigger checks whether compaction is due, after a flush added a table
+func (s *Scheduler) Trigger() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paused = false
+	s.changed.Broadcast()
+}
+
+// FlushStarted pauses compactions until the matching FlushDone
+func (s *Scheduler) FlushStarted() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flushing++
+}
+
+// FlushDone lets compactions paused by FlushStarted go on
+func (s *Scheduler) FlushDone() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flushing--
+	s.changed.Broadcast()
+}
+
+// CompactRange moves the tables holding keys from start to end down one level
+// at a time until they reach the last level. It waits for a free worker and
+// for compactions of the same levels to end, and returns once done.
+func (s *Scheduler) CompactRange(start, end string) error {
+	defer s.Trigger()
+	for level := 0; level < s.cfg.LSMLevels; level++ {
+		j, err := s.reserveRange(level, start, end)
+		if err != nil {
+			return err
+		}
+		if j == nil {
+			continue
+		}
+		result, err := s.compacter.compact(j, s.bm, s.pace)
+		s.lock.Lock()
+		if err == nil {
+			s.record(result)
+		}
+		s.release(j)
+		s.lock.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to compact lvl%d: %w", level, err)
+		}
+	}
+	return nil
+}
+
+// reserveRange waits until level can be compacted and reserves the job moving
+// its tables from start to end down, nil when it holds none
+func (s *Scheduler) reserveRange(level int, start, end string) (*job, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for !s.closed && (s.busy[level] || s.busy[level+1] || s.running >= s.cfg.CompactionWorkers) {
+		s.changed.Wait()
+	}
+	if s.closed {
+		return nil, fmt.Errorf("compaction scheduler is closed")
+	}
+	j := s.compacter.pickRange(level, start, end)
+	if j != nil {
+		s.reserve(j)
+	}
+	return j, nil
+}
+
+// Stats returns the stats of the compactions finished so far
+func (s *Scheduler) Stats() CompactionStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stats
+}
+
+// Close runs the compactions that are still due, waits for every running one
+// and stops the scheduler. Compactions must not be triggered afterwards.
+func (s *Scheduler) Close() {
+	s.lock.Lock()
+	s.closed = true
+	s.changed.Broadcast()
+	s.lock.Unlock()
+	<-s.done
+}
@@ -0,0 +1,199 @@
+package ss_compacter
+
+import (
+	"fmt"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/models/key_value"
+	"nosqlEngine/src/service/block_manager"
+	"nosqlEngine/src/service/file_writer"
+	"nosqlEngine/src/service/table_pins"
+	"nosqlEngine/src/storage/manifest"
+	"sort"
+	"sync"
+)
+
+// LeveledCompacter keeps the key ranges of the tables in lvl1 and below
+// apart. Flushed tables gather in lvl0 until there are COMPACTION_THRESHOLD of
+// them, then all of them are merged into lvl1. Every level below holds up to
+// LEVEL_BASE_SIZE bytes times LEVEL_SIZE_MULTIPLIER per level, a level past
+// its size moves one table into the next level at a time. A compaction only
+// rewrites the tables of the next level whose key range overlaps its input,
+// and splits its output into tables of about SSTABLE_TARGET_SIZE bytes.
+type LeveledCompacter struct {
+	cfg        config.Config
+	files_lock *sync.RWMutex // held exclusively while compacted tables are swapped in
+	pins       *table_pins.TablePins
+	compare    func(a, b string) int
+	manifest   *manifest.Manifest
+	next_key   map[int]string // per level, the next table moved down is the first past this key
+}
+
+// NewLeveledCompacter builds a leveled compacter sharing filesLock, pins and
+// the manifest with the readers, as NewSSCompacterST does
+func NewLeveledCompacter(cfg config.Config, filesLock *sync.RWMutex, pins *table_pins.TablePins, m *manifest.Manifest) *LeveledCompacter {
+	return &LeveledCompacter{
+		cfg:        cfg,
+		files_lock: filesLock,
+		pins:       pins,
+		manifest:   m,
+		compare:    cfg.Comparator().CompareStrings,
+		next_key:   map[int]string{},
+	}
+}
+
+func (lc *LeveledCompacter) CheckCompactionConditions(bm *block_manager.BlockManager) bool {
+	return runDue(lc, bm)
+}
+
+// levelTarget is the number of bytes a level below lvl0 holds
+func (lc *LeveledCompacter) levelTarget(level int) int64 {
+	target := int64(lc.cfg.LevelBaseSize)
+	for i := 1; i < level; i++ {
+		target *= int64(lc.cfg.LevelSizeMultiplier)
+	}
+	return target
+}
+
+func (lc *LeveledCompacter) pick(busy map[int]bool) *job {
+	if tables := lc.manifest.Level(0); !busy[0] && !busy[1] && len(tables) >= lc.cfg.CompactionThreshold {
+		// lvl0 tables overlap each other, they move down together
+		return &job{level: 0, inputs: tables}
+	}
+	// the last level is never compacted further
+	for level := 1; level < lc.cfg.LSMLevels; level++ {
+		if busy[level] || busy[level+1] {
+			continue
+		}
+		tables := lc.manifest.Level(level)
+		size := int64(0)
+		for _, table := range tables {
+			size += table.Size
+		}
+		if size <= lc.levelTarget(level) {
+			continue
+		}
+		sort.Slice(tables, func(i, j int) bool { return lc.compare(tables[i].FirstKey, tables[j].FirstKey) < 0 })
+		// take turns over the key range, so every table eventually moves down
+		next, ok := lc.next_key[level]
+		picked := tables[0]
+		for _, table := range tables {
+			if !ok || lc.compare(table.FirstKey, next) > 0 {
+				picked = table
+				break
+			}
+		}
+		lc.next_key[level] = picked.LastKey
+		return &job{level: level, inputs: []manifest.TableMeta{picked}}
+	}
+	return nil
+}
+
+// pickRange takes every lvl0 table once one of them holds keys in the range,
+// as pick does, and only the tables holding keys in it from the levels below
+func (lc *LeveledCompacter) pickRange(level int, start, end string) *job {
+	tables := lc.manifest.Level(level)
+	inputs := []manifest.TableMeta{}
+	for _, table := range tables {
+		if overlaps(table, start, end, lc.compare) {
+			inputs = append(inputs, table)
+		}
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+	if level == 0 {
+		inputs = tables
+	}
+	return &job{level: level, inputs: inputs}
+}
+
+// compact merges the inputs of j with the tables of the next level that
+// overlap them, and replaces all of them with the output in the next level
+func (lc *LeveledCompacter) compact(j *job, bm *block_manager.BlockManager, pace func(bytes int)) (CompactionResult, error) {
+	level, inputs := j.level, j.inputs
+	var first, last string
+	bounded := false
+	for _, input := range inputs {
+		if input.Entries == 0 {
+			continue
+		}
+		if !bounded || lc.compare(input.FirstKey, first) < 0 {
+			first = input.FirstKey
+		}
+		if !bounded || lc.compare(input.LastKey, last) > 0 {
+			last = input.LastKey
+		}
+		bounded = true
+	}
+	for _, table := range lc.manifest.Level(level + 1) {
+		if bounded && overlaps(table, first, last, lc.compare) {
+			inputs = append(inputs, table)
+		}
+	}
+	tables := lc.manifest.PathsOf(inputs)
+
+	edit := manifest.VersionEdit{Removed: tables}
+	outputs, dropped, err := lc.writeTables(tables, level+1, bm, pace)
+	result := CompactionResult{Level: level, Inputs: len(tables), Outputs: len(outputs), TombstonesDropped: dropped}
+	if err != nil {
+		return result, err
+	}
+	for _, fw := range outputs {
+		meta, err := commitTable(fw, level+1, lc.manifest)
+		if err != nil {
+			// the inputs stay, a restart deletes what was committed so far
+			return result, fmt.Errorf("failed to commit compacted table: %w", err)
+		}
+		edit.Added = append(edit.Added, meta)
+	}
+
+	lc.files_lock.Lock()
+	defer lc.files_lock.Unlock()
+	if err := lc.manifest.Apply(edit); err != nil {
+		return result, fmt.Errorf("failed to record compaction: %w", err)
+	}
+	for _, table := range tables {
+		if err := lc.pins.Remove(table); err != nil {
+			fmt.Printf("Error removing compacted table %s: %v\n", table, err)
+		}
+	}
+	return result, nil
+}
+
+// writeTables merges tables into new tables of level, starting a new one
+// whenever the current one reaches SSTABLE_TARGET_SIZE, and returns their
+// writers still staged along with the number of tombstones dropped. When the
+// merge fails every output is discarded.
+func (lc *LeveledCompacter) writeTables(tables []string, level int, bm *block_manager.BlockManager, pace func(bytes int)) ([]*file_writer.FileWriter, int, error) {
+	merge, err := newTableMerge(tables, lc.manifest, lc.files_lock, bm, lc.cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	outputs := []*file_writer.FileWriter{}
+	var builder *tableBuilder
+	err = merge.run(func(entry key_value.KeyValue) {
+		if builder != nil && builder.dataSize() >= lc.cfg.SSTableTargetSize {
+			builder.finish()
+			builder = nil
+		}
+		if builder == nil {
+			name := file_writer.TableName(level, lc.manifest.NextNumber())
+			fw := file_writer.NewStagedFileWriter(bm, lc.cfg.BlockSize, lc.cfg.SSTableDir(), name)
+			outputs = append(outputs, fw)
+			builder = newTableBuilder(fw, merge.total, lc.cfg)
+		}
+		builder.add(entry)
+	}, pace)
+	if builder != nil {
+		builder.finish()
+	}
+	if err != nil {
+		for _, fw := range outputs {
+			if err := fw.Discard(); err != nil {
+				fmt.Printf("Error discarding compacted table: %v\n", err)
+			}
+		}
+		return nil, 0, err
+	}
+	return outputs, merge.dropped, nil
+}
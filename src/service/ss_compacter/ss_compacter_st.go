@@ -3,115 +3,113 @@ package ss_compacter
 import (
 	"fmt"
 	"nosqlEngine/src/config"
-	"nosqlEngine/src/models/bloom_filter"
-	"nosqlEngine/src/models/merkle_tree"
 	"nosqlEngine/src/service/block_manager"
 	"nosqlEngine/src/service/file_writer"
-	"nosqlEngine/src/service/retriever"
-	"nosqlEngine/src/service/ss_parser"
-	"os"
-	"path/filepath"
-	"runtime"
-	"strings"
-
-	"github.com/google/uuid"
+	"nosqlEngine/src/service/table_pins"
+	"nosqlEngine/src/storage/manifest"
+	"sync"
 )
 
-var CONFIG = config.GetConfig()
-
 type SSCompacterST struct {
+	cfg        config.Config
+	files_lock *sync.RWMutex // held exclusively while compacted tables are swapped in
+	pins       *table_pins.TablePins
+	manifest   *manifest.Manifest
 }
 
-func NewSSCompacterST() *SSCompacterST {
-	return &SSCompacterST{}
+// NewSSCompacterST builds a size-tiered compacter. Readers of SSTables share
+// filesLock, the compacter only takes it to publish its output and remove the
+// inputs, so a reader never loses a table halfway through a lookup. Inputs
+// pinned by a snapshot are kept until the snapshot is released. Tables are
+// picked from the manifest, which every compaction updates in one edit.
+func NewSSCompacterST(cfg config.Config, filesLock *sync.RWMutex, pins *table_pins.TablePins, m *manifest.Manifest) *SSCompacterST {
+	return &SSCompacterST{cfg: cfg, files_lock: filesLock, pins: pins, manifest: m}
 }
 
-func getProjectRoot() string {
-	_, filename, _, _ := runtime.Caller(0)
-	// Go up from src/service/file_writer/writer.go to project root
-	projectRoot := filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(filename))))
-	return projectRoot
+func (sc *SSCompacterST) CheckCompactionConditions(bm *block_manager.BlockManager) bool {
+	return runDue(sc, bm)
 }
 
-func getFilesFromLevel(level int) []string {
-	var sstablePaths []string
-
-	sstableDir := filepath.ToSlash(filepath.Join(getProjectRoot(), "data/sstable"))
-	sstablePaths = make([]string, 0)
-
-	files, _ := os.ReadDir(sstableDir + "/lvl" + fmt.Sprint(level))
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".db") {
+func (sc *SSCompacterST) pick(busy map[int]bool) *job {
+	for level := 0; level < sc.cfg.LSMLevels; level++ {
+		if busy[level] || busy[level+1] {
 			continue
 		}
-		sstablePaths = append(sstablePaths, filepath.Join(sstableDir+"/lvl"+fmt.Sprint(level), file.Name()))
+		// the oldest tables go first, so a level only ever holds data newer
+		// than the level below and lookups can stop at the first version
+		if tables := sc.manifest.Level(level); len(tables) >= sc.cfg.CompactionThreshold {
+			return &job{level: level, inputs: tables[:sc.cfg.CompactionThreshold]}
+		}
 	}
-
-	return sstablePaths
+	return nil
 }
-func (sc *SSCompacterST) CheckCompactionConditions(bm *block_manager.BlockManager) bool {
-	level := 0
-	compacted := false
-	for level < CONFIG.LSMLevels {
-		sstFiles := getFilesFromLevel(level)
 
-		for len(sstFiles) >= CONFIG.CompactionThreshold {
-			toCompact := sstFiles[:CONFIG.CompactionThreshold]
-			sstFiles = sstFiles[CONFIG.CompactionThreshold:]
-			lvlDir := fmt.Sprintf("lvl%d", level+1)
-			fw := file_writer.NewFileWriter(bm, CONFIG.BlockSize, "sstable/"+lvlDir+"/sstable_"+uuid.New().String()+".db")
-			sc.compactTables(toCompact, fw, bm)
-			for _, file := range toCompact {
-				os.Remove(file)
-			}
-			compacted = true
+// pickRange moves the whole level once one of its tables holds keys in the
+// range, since its tables share keys and the older ones may not stay behind
+func (sc *SSCompacterST) pickRange(level int, start, end string) *job {
+	compare := sc.cfg.Comparator().CompareStrings
+	tables := sc.manifest.Level(level)
+	for _, table := range tables {
+		if overlaps(table, start, end, compare) {
+			return &job{level: level, inputs: tables}
 		}
-		level++
 	}
-	return compacted
+	return nil
 }
 
-func (sc *SSCompacterST) compactTables(tables []string, fw *file_writer.FileWriter, bm *block_manager.BlockManager) {
-	counts := make([]int, len(tables)) // holds the number of items in each table
-	currKeys := make([]string, len(tables))
-	currValues := make([]string, len(tables))
-	pool := retriever.NewEntryRetrieverPool(bm, tables)
-	totalItems := 0                                    // total number of items across all tables
-	for i := range tables {
-		counts[i] = int(pool.GetMetadata(i).Getnum_of_items())
-		totalItems += counts[i]
-		currKeys[i], currValues[i], _, _ = pool.ReadNextVal(i) // Read the first key and value from each table
+func (sc *SSCompacterST) compact(j *job, bm *block_manager.BlockManager, pace func(bytes int)) (CompactionResult, error) {
+	toCompact := sc.manifest.PathsOf(j.inputs)
+	name := file_writer.TableName(j.level+1, sc.manifest.NextNumber())
+	fw := file_writer.NewStagedFileWriter(bm, sc.cfg.BlockSize, sc.cfg.SSTableDir(), name)
+	written, dropped, err := sc.compactTables(toCompact, fw, bm, pace)
+	result := CompactionResult{Level: j.level, Inputs: len(toCompact), TombstonesDropped: dropped}
+	if err != nil {
+		// the inputs stay, nothing of the output is kept
+		if err := fw.Discard(); err != nil {
+			fmt.Printf("Error discarding compacted table: %v\n", err)
+		}
+		return result, err
 	}
-	// For Index
-	keys := []string{}
-	blockOffsets := []int{}
-	currBlockOffset := -1
 
-	bloom := bloom_filter.NewBloomFilterWithParams(totalItems, 0.01) // 1% false positive rate
-	merkle := merkle_tree.InitializeMerkleTree(totalItems)
+	edit := manifest.VersionEdit{Removed: toCompact}
+	if written == 0 {
+		// everything in the inputs expired, there is no table to publish
+		if err := fw.Discard(); err != nil {
+			fmt.Printf("Error discarding empty compacted table: %v\n", err)
+		}
+	} else {
+		meta, err := commitTable(fw, j.level+1, sc.manifest)
+		if err != nil {
+			return result, fmt.Errorf("failed to commit compacted table: %w", err)
+		}
+		edit.Added = append(edit.Added, meta)
+		result.Outputs = 1
+	}
 
-	for !areAllValuesZero(counts) {
-		minIndex := getMinValIndex(currKeys, currValues)
-		removeDuplicateKeys(currKeys, minIndex) // Remove duplicates for the current key
-		bloom.Add(currKeys[minIndex])
-		merkle.AddLeaf(string(currValues[minIndex])) // Add to Merkle tree
-		fullVal := append(ss_parser.SizeAndValueToBytes(currKeys[minIndex]), ss_parser.SizeAndValueToBytes(currValues[minIndex])...)
-		newBlockOffset := fw.Write(fullVal, false, nil)
-		if currBlockOffset != newBlockOffset {
-			currBlockOffset = newBlockOffset
-			keys = append(keys, currKeys[minIndex])
-			blockOffsets = append(blockOffsets, currBlockOffset)
+	sc.files_lock.Lock()
+	defer sc.files_lock.Unlock()
+	if err := sc.manifest.Apply(edit); err != nil {
+		return result, fmt.Errorf("failed to record compaction: %w", err)
+	}
+	for _, file := range toCompact {
+		if err := sc.pins.Remove(file); err != nil {
+			fmt.Printf("Error removing compacted table %s: %v\n", file, err)
 		}
-		currKeys[minIndex] = "" 
-		updateValsAndCounts(currKeys, currValues, counts, pool)
 	}
-	fw.Write(nil, true, nil) // Write end of file marker
-	summaryKeys, summaryOffsets := ss_parser.SerializeIndexGetOffsets(keys, blockOffsets, fw) // Write index offsets
-	initialSummaryOffset := fw.Write(nil, true, nil)
-	ss_parser.SerializeSummary(summaryKeys, summaryOffsets, fw)
-	prefixFilter := bloom_filter.NewPrefixBloomFilter()
+	return result, nil
+}
 
-	bt_pbf, _ := prefixFilter.SerializeToByteArray()
-	bt_bf, _ := bloom.SerializeToByteArray()          
-	ss_parser.SerializeMetaData(fw.Write(nil, true, nil), bt_bf, merkle.GetRootBytes(), totalItems, fw, initialSummaryOffset, bt_pbf) // Write metadata
+// compactTables merges tables into fw, keeping the newest version of each key,
+// and returns the number of entries written and of tombstones dropped
+func (sc *SSCompacterST) compactTables(tables []string, fw *file_writer.FileWriter, bm *block_manager.BlockManager, pace func(bytes int)) (int, int, error) {
+	merge, err := newTableMerge(tables, sc.manifest, sc.files_lock, bm, sc.cfg)
+	if err != nil {
+		return 0, 0, err
+	}
+	// duplicate keys are merged and expired ones dropped, so fewer items than merge.total may be written
+	builder := newTableBuilder(fw, merge.total, sc.cfg)
+	if err := merge.run(builder.add, pace); err != nil {
+		return 0, 0, err
+	}
+	return builder.finish(), merge.dropped, nil
 }
@@ -1,51 +1,61 @@
 package ss_compacter
 
 import (
+	"fmt"
+	"nosqlEngine/src/models/key_value"
 	"nosqlEngine/src/service/retriever"
+	"nosqlEngine/src/storage/manifest"
 )
 
-func updateValsAndCounts(keys []string, vals []string, counts []int, pool *retriever.EntryRetrieverPool) {
-	for i := 0; i < len(vals); i++ {
+// updateValsAndCounts reads the next entry of every table whose current one
+// was merged. A table is used up once its count reaches zero, keys are never
+// taken as a sign of that since the empty key is a valid one.
+func updateValsAndCounts(entries []key_value.KeyValue, counts []int, merged []bool, pool *retriever.EntryRetrieverPool) error {
+	for i := 0; i < len(entries); i++ {
+		if !merged[i] {
+			continue
+		}
+		merged[i] = false
+		counts[i]--
+		entries[i] = key_value.KeyValue{}
 		if counts[i] == 0 {
-			keys[i] = ""
-			vals[i] = ""
 			continue
 		}
-		if keys[i] == "" {
-			counts[i]--
-			if counts[i] != 0 {
-				keys[i], vals[i], _, _ = pool.ReadNextVal(i) // Read the next value
-			}
+		entry, err := pool.ReadNextVal(i)
+		if err != nil {
+			return fmt.Errorf("failed to read table %d of the merge: %w", i, err)
 		}
+		entries[i] = entry
 	}
+	return nil
 }
-func getMinValIndex(keys []string, values []string) int {
-	minVal := "\xFF\xFF\xFF\xFF" // Maximum possible string value
+
+// getMinValIndex returns the table holding the smallest current key, the one
+// with the newest write of it when several do
+func getMinValIndex(entries []key_value.KeyValue, counts []int, compare func(a, b string) int) int {
 	minIdx := -1
-	for i, key := range keys {
-		if key == "" {
+	for i, entry := range entries {
+		if counts[i] == 0 {
 			continue
 		}
-		if key < minVal {
-			minVal = key
+		if minIdx == -1 {
 			minIdx = i
+			continue
 		}
-	}
-	// Check if TOMBSTONE for same key exists
-	for i := 0; i < len(keys); i++ {
-		if keys[i] == keys[minIdx] && values[i] == CONFIG.Tombstone {
-			return i
+		order := compare(entry.GetKey(), entries[minIdx].GetKey())
+		if order < 0 || order == 0 && entry.IsNewerThan(entries[minIdx]) {
+			minIdx = i
 		}
 	}
 	return minIdx
 }
-func removeDuplicateKeys(keys []string, index int) {
-	for i := 0; i < len(keys); i++ {
-		if i == index || keys[i] == "" {
-			continue
-		}
-		if keys[index] == keys[i] {
-			keys[i] = ""
+
+// removeDuplicateKeys marks every table whose current key is the one at index
+// as merged, only the entry at index is kept
+func removeDuplicateKeys(entries []key_value.KeyValue, counts []int, merged []bool, index int) {
+	for i := 0; i < len(entries); i++ {
+		if counts[i] != 0 && entries[i].GetKey() == entries[index].GetKey() {
+			merged[i] = true
 		}
 	}
 }
@@ -58,3 +68,26 @@ func areAllValuesZero(values []int) bool {
 	}
 	return true
 }
+
+// outsideTables lists the live tables that are not part of a compaction and
+// may hold a version of the key of entry older than it, by their key range
+// and sequence numbers
+func outsideTables(m *manifest.Manifest, inputs []string, entry key_value.KeyValue, compare func(a, b string) int) []string {
+	compacted := make(map[string]bool, len(inputs))
+	for _, table := range inputs {
+		compacted[table] = true
+	}
+	outside := []manifest.TableMeta{}
+	for _, table := range m.Tables() {
+		if table.MinSeq <= entry.GetSeq() && overlaps(table, entry.GetKey(), entry.GetKey(), compare) {
+			outside = append(outside, table)
+		}
+	}
+	paths := []string{}
+	for _, table := range m.PathsOf(outside) {
+		if !compacted[table] {
+			paths = append(paths, table)
+		}
+	}
+	return paths
+}
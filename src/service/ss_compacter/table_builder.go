@@ -0,0 +1,194 @@
+package ss_compacter
+
+import (
+	"errors"
+	"fmt"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/models/bloom_filter"
+	"nosqlEngine/src/models/key_value"
+	"nosqlEngine/src/models/merkle_tree"
+	"nosqlEngine/src/service/block_manager"
+	"nosqlEngine/src/service/file_writer"
+	"nosqlEngine/src/service/retriever"
+	"nosqlEngine/src/service/ss_parser"
+	"nosqlEngine/src/storage/manifest"
+	"sync"
+	"time"
+)
+
+// tableMerge walks tables side by side, yielding the newest version of every
+// key in key order
+type tableMerge struct {
+	tables      []string
+	pool        *retriever.EntryRetrieverPool
+	counts      []int  // entries left in each table, the current one included
+	merged      []bool // per table, whether its current entry was merged
+	currEntries []key_value.KeyValue
+	total       int // entries across all tables, duplicates included
+	dropped     int // tombstones left out of the output
+	manifest    *manifest.Manifest
+	files_lock  *sync.RWMutex // held for reading while tables outside the merge are read
+	bm          *block_manager.BlockManager
+	cfg         config.Config
+}
+
+// newTableMerge opens tables and reads the first entry of each, m holds the
+// tables outside the merge
+func newTableMerge(tables []string, m *manifest.Manifest, filesLock *sync.RWMutex, bm *block_manager.BlockManager, cfg config.Config) (*tableMerge, error) {
+	merge := &tableMerge{
+		tables:      tables,
+		pool:        retriever.NewEntryRetrieverPool(bm, tables, cfg),
+		counts:      make([]int, len(tables)),
+		merged:      make([]bool, len(tables)),
+		currEntries: make([]key_value.KeyValue, len(tables)),
+		manifest:    m,
+		files_lock:  filesLock,
+		bm:          bm,
+		cfg:         cfg,
+	}
+	for i := range tables {
+		merge.counts[i] = int(merge.pool.GetMetadata(i).Getnum_of_items())
+		merge.total += merge.counts[i]
+		if merge.counts[i] == 0 {
+			continue
+		}
+		entry, err := merge.pool.ReadNextVal(i) // Read the first entry from each table
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", tables[i], err)
+		}
+		merge.currEntries[i] = entry
+	}
+	return merge, nil
+}
+
+// run passes the newest version of every key to emit. Tombstones and expired
+// entries are dropped once no table outside the merge may hold an older
+// version of the key, the output is then the bottommost level for it. Until
+// then an expired entry is turned into a tombstone that keeps the older
+// version hidden. pace, when set, is called with the size of every entry
+// before it is merged. A table that cannot be read fails the merge, whatever
+// was emitted so far must then be discarded.
+func (m *tableMerge) run(emit func(entry key_value.KeyValue), pace func(bytes int)) error {
+	now := time.Now()
+	compare := m.cfg.Comparator().CompareStrings
+	for !areAllValuesZero(m.counts) {
+		minIndex := getMinValIndex(m.currEntries, m.counts, compare)
+		removeDuplicateKeys(m.currEntries, m.counts, m.merged, minIndex) // Remove duplicates for the current key
+		entry, keep := m.currEntries[minIndex], true
+		if pace != nil {
+			pace(len(entry.GetKey()) + len(entry.GetValue()))
+		}
+		if entry.IsTombstone() || entry.IsExpired(now) {
+			shadows, err := m.shadows(entry)
+			if err != nil {
+				return err
+			}
+			if shadows {
+				// a tombstone without the value keeps the older version hidden
+				entry = key_value.NewTombstone(entry.GetKey()).WithSeq(entry.GetSeq())
+			} else {
+				if entry.IsTombstone() {
+					m.dropped++
+				}
+				keep = false
+			}
+		}
+		if keep {
+			emit(entry)
+		}
+		if err := updateValsAndCounts(m.currEntries, m.counts, m.merged, m.pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shadows reports whether a table outside the merge holds a version of the
+// key of entry older than it. Snapshots read the tables they pinned, never
+// the output, so they do not need the entry either way. The tables outside
+// are read as they are now, other compactions may replace them meanwhile.
+// Only a clean miss lets the entry go, a table that cannot be read is an error.
+func (m *tableMerge) shadows(entry key_value.KeyValue) (bool, error) {
+	m.files_lock.RLock()
+	defer m.files_lock.RUnlock()
+	outside := outsideTables(m.manifest, m.tables, entry, m.cfg.Comparator().CompareStrings)
+	if len(outside) == 0 {
+		return false, nil
+	}
+	_, found, err := retriever.NewEntryRetriever(m.bm, m.cfg).RetrieveEntryAt(entry.GetKey(), outside, entry.GetSeq())
+	if errors.Is(err, retriever.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look for older versions of %s: %w", entry.GetKey(), err)
+	}
+	return found, nil
+}
+
+// tableBuilder writes sorted entries to one SSTable, collecting its index,
+// bloom filter and Merkle tree on the way
+type tableBuilder struct {
+	fw              *file_writer.FileWriter
+	cfg             config.Config
+	keys            []string // first key of every data block, for the index
+	blockOffsets    []int
+	currBlockOffset int
+	bloom           *bloom_filter.BloomFilter
+	merkle          *merkle_tree.MerkleTree
+	expected        int
+	written         int
+}
+
+// newTableBuilder starts a table in fw sized for about expected entries
+func newTableBuilder(fw *file_writer.FileWriter, expected int, cfg config.Config) *tableBuilder {
+	return &tableBuilder{
+		fw:              fw,
+		cfg:             cfg,
+		currBlockOffset: -1,
+		bloom:           bloom_filter.NewBloomFilterWithParams(expected, cfg.BloomFilterFalsePositiveRate),
+		merkle:          merkle_tree.InitializeMerkleTree(expected),
+		expected:        expected,
+	}
+}
+
+func (tb *tableBuilder) add(entry key_value.KeyValue) {
+	tb.bloom.Add(entry.GetKey())
+	tb.merkle.AddLeaf(entry.GetValue())
+	newBlockOffset := tb.fw.Write(ss_parser.DataEntryToBytes(entry), false, nil)
+	tb.written++
+	if tb.currBlockOffset != newBlockOffset {
+		tb.currBlockOffset = newBlockOffset
+		tb.keys = append(tb.keys, entry.GetKey())
+		tb.blockOffsets = append(tb.blockOffsets, tb.currBlockOffset)
+	}
+}
+
+// dataSize is the number of bytes the data section has reached
+func (tb *tableBuilder) dataSize() int {
+	return (tb.currBlockOffset + 1) * tb.cfg.BlockSize
+}
+
+// finish writes the index, summary and metadata after the data and returns the
+// number of entries written
+func (tb *tableBuilder) finish() int {
+	tb.fw.Write(nil, true, nil) // Write end of file marker
+	summaryKeys, summaryOffsets := ss_parser.SerializeIndexGetOffsets(tb.keys, tb.blockOffsets, tb.fw, tb.cfg.SummaryStep)
+	initialSummaryOffset := tb.fw.Write(nil, true, nil)
+	ss_parser.SerializeSummary(summaryKeys, summaryOffsets, tb.fw)
+	prefixFilter := bloom_filter.NewPrefixBloomFilter(tb.expected, tb.cfg.BloomFilterFalsePositiveRate, tb.cfg.MinPrefixLength, tb.cfg.MaxPrefixLength)
+
+	bt_pbf, _ := prefixFilter.SerializeToByteArray()
+	bt_bf, _ := tb.bloom.SerializeToByteArray()
+	ss_parser.SerializeMetaData(tb.fw.Write(nil, true, nil), bt_bf, tb.merkle.GetRootBytes(), tb.written, tb.fw, initialSummaryOffset, bt_pbf)
+	return tb.written
+}
+
+// commitTable publishes a finished staged table of level and describes it for
+// the manifest. Until the manifest records it the table is not read, and a
+// restart deletes it.
+func commitTable(fw *file_writer.FileWriter, level int, m *manifest.Manifest) (manifest.TableMeta, error) {
+	if err := fw.Commit(); err != nil {
+		return manifest.TableMeta{}, err
+	}
+	return m.Describe(fw.GetLocation(), level)
+}
@@ -1,6 +1,7 @@
 package ss_parser
 
 import (
+	"nosqlEngine/src/config"
 	"nosqlEngine/src/models/bloom_filter"
 	"nosqlEngine/src/models/key_value"
 	"nosqlEngine/src/models/merkle_tree"
@@ -9,15 +10,19 @@ import (
 
 type SSParserImpl struct {
 	fileWriter file_writer.FileWriterInterface
+	cfg        config.Config
 }
 
-func NewSSParser(fileWriter file_writer.FileWriterInterface) *SSParserImpl {
-	return &SSParserImpl{fileWriter: fileWriter}
+func NewSSParser(fileWriter file_writer.FileWriterInterface, cfg config.Config) *SSParserImpl {
+	return &SSParserImpl{fileWriter: fileWriter, cfg: cfg}
 }
 
-func (ssParser *SSParserImpl) FlushMemtable(data []key_value.KeyValue) {
-	key_value.SortByKeys(&data)
-	filter := bloom_filter.NewBloomFilterWithParams(len(data), 0.01)
+func (ssParser *SSParserImpl) FlushMemtable(data []key_value.KeyValue) error {
+	compare := ssParser.cfg.Comparator().CompareStrings
+	if !key_value.IsSortedByKeys(data, compare) { // sorted memtables hand over data in order
+		key_value.SortByKeys(&data, compare)
+	}
+	filter := bloom_filter.NewBloomFilterWithParams(len(data), ssParser.cfg.BloomFilterFalsePositiveRate)
 	filter.AddMultiple(key_value.GetKeys(data))
 	merkleTree := merkle_tree.InitializeMerkleTree(len(data))
 	for _, kv := range data {
@@ -26,16 +31,18 @@ func (ssParser *SSParserImpl) FlushMemtable(data []key_value.KeyValue) {
 	keys, offsets := SerializeDataGetOffsets(ssParser.fileWriter, data)
 	ssParser.fileWriter.Write(nil, true, nil) // Write end of section marker
 
-	sumKeys, sumOffsets := SerializeIndexGetOffsets(keys, offsets, ssParser.fileWriter)
+	sumKeys, sumOffsets := SerializeIndexGetOffsets(keys, offsets, ssParser.fileWriter, ssParser.cfg.SummaryStep)
 	initialSummaryOffset := ssParser.fileWriter.Write(nil, true, nil)
 
 	SerializeSummary(sumKeys, sumOffsets, ssParser.fileWriter)
 	bt_bf, _ := filter.SerializeToByteArray()
-	prefixFilter := bloom_filter.NewPrefixBloomFilter()
+	prefixFilter := bloom_filter.NewPrefixBloomFilter(len(data), ssParser.cfg.BloomFilterFalsePositiveRate, ssParser.cfg.MinPrefixLength, ssParser.cfg.MaxPrefixLength)
 	prefixFilter.AddMultiple(key_value.GetKeys(data))
 	bt_pbf, _ := prefixFilter.SerializeToByteArray()
 	SerializeMetaData(ssParser.fileWriter.Write(nil, true, nil), bt_bf, merkleTree.GetRootBytes(), len(data), ssParser.fileWriter, initialSummaryOffset, bt_pbf)
 
-	// Reset the file writer for the next flush
+	// Publish the finished SSTable and reset the file writer for the next flush
+	err := ssParser.fileWriter.Commit()
 	ssParser.fileWriter.ResetFileWriter("")
+	return err
 }
@@ -5,5 +5,5 @@ import (
 )
 
 type SSParser interface {
-	FlushMemtable(keyValues []key_value.KeyValue)
+	FlushMemtable(keyValues []key_value.KeyValue) error
 }
\ No newline at end of file
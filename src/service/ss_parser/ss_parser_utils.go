@@ -2,32 +2,28 @@ package ss_parser
 
 import (
 	"encoding/binary"
-	"nosqlEngine/src/config"
 	"nosqlEngine/src/models/key_value"
 	"nosqlEngine/src/service/file_writer"
 )
 
-var CONFIG = config.GetConfig()
-
 func SerializeDataGetOffsets(fw file_writer.FileWriterInterface, keyValues []key_value.KeyValue) ([]string, []int) {
 	keys := make([]string, len(keyValues))
 	offsets := make([]int, len(keyValues))
 	for i := 0; i < len(keyValues); i++ {
-		value := append(SizeAndValueToBytes(keyValues[i].GetKey()), SizeAndValueToBytes(keyValues[i].GetValue())...)
-		blockIndex := fw.Write(value, false, nil)
+		blockIndex := fw.Write(DataEntryToBytes(keyValues[i]), false, nil)
 		keys[i] = keyValues[i].GetKey()
 		offsets[i] = blockIndex
 	}
 	return keys, offsets
 }
 
-func SerializeIndexGetOffsets(keys []string, offsets []int, fw file_writer.FileWriterInterface) ([]string, []int) {
+func SerializeIndexGetOffsets(keys []string, offsets []int, fw file_writer.FileWriterInterface, summaryStep int) ([]string, []int) {
 
-	elNum := len(keys) / CONFIG.SummaryStep
-	if len(keys)%CONFIG.SummaryStep != 0 {
+	elNum := len(keys) / summaryStep
+	if len(keys)%summaryStep != 0 {
 		elNum++
 	}
-	if len(keys) < CONFIG.SummaryStep {
+	if len(keys) < summaryStep {
 		elNum = len(keys)
 	}
 	sumKeys := make([]string, 0, elNum)
@@ -39,7 +35,7 @@ func SerializeIndexGetOffsets(keys []string, offsets []int, fw file_writer.FileW
 		value := append(SizeAndValueToBytes(key), IntToBytes(int64(offset))...)
 		currBlock := fw.Write(value, false, nil)
 
-		if i == 0 || i == len(keys)-1 || i%CONFIG.SummaryStep == 0 {
+		if i == 0 || i == len(keys)-1 || i%summaryStep == 0 {
 			sumKeys = append(sumKeys, key)
 			sumOffsets = append(sumOffsets, currBlock)
 		}
@@ -77,6 +73,33 @@ func IntToBytes(n int64) []byte {
 	return buf
 }
 
+// Data entry flags, stored in the byte that follows the value
+const (
+	FlagTombstone = 1 << 0
+	FlagExpires   = 1 << 1 // the sequence number is followed by the expiry, 8 bytes of unix nanoseconds
+)
+
+// DataEntryToBytes encodes a data section record: key size, key, value size,
+// value, a flags byte and the sequence number of the write as a uvarint, which
+// keeps small records within a single block. Expiring entries end with their
+// expiry.
+func DataEntryToBytes(kv key_value.KeyValue) []byte {
+	data := append(SizeAndValueToBytes(kv.GetKey()), SizeAndValueToBytes(kv.GetValue())...)
+	var flags byte
+	if kv.IsTombstone() {
+		flags |= FlagTombstone
+	}
+	if kv.GetExpiry() != 0 {
+		flags |= FlagExpires
+	}
+	data = append(data, flags)
+	data = binary.AppendUvarint(data, kv.GetSeq())
+	if kv.GetExpiry() != 0 {
+		data = append(data, IntToBytes(kv.GetExpiry())...)
+	}
+	return data
+}
+
 func SizeAndValueToBytes(value string) []byte {
 	valueBytes := []byte(value)
 	valueSizeBytes := IntToBytes(int64(len(valueBytes)))
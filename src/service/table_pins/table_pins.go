@@ -0,0 +1,101 @@
+package table_pins
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// RetiredSuffix is appended to a pinned SSTable that compaction replaced. The
+// file drops out of every directory listing of SSTables but stays readable by
+// the snapshots that pinned it.
+const RetiredSuffix = ".retired"
+
+// TablePins counts the snapshots holding each SSTable, so that compaction
+// does not delete a table a snapshot may still read.
+type TablePins struct {
+	lock    sync.Mutex
+	refs    map[string]int    // SSTable -> number of snapshots pinning it
+	retired map[string]string // pinned SSTable replaced by compaction -> where it was moved
+}
+
+func NewTablePins() *TablePins {
+	return &TablePins{refs: make(map[string]int), retired: make(map[string]string)}
+}
+
+// Pin marks tables as needed by one more snapshot
+func (p *TablePins) Pin(tables []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, table := range tables {
+		p.refs[table]++
+	}
+}
+
+// Unpin releases the tables of one snapshot. Retired tables that no snapshot
+// holds any more are deleted.
+func (p *TablePins) Unpin(tables []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, table := range tables {
+		p.refs[table]--
+		if p.refs[table] > 0 {
+			continue
+		}
+		delete(p.refs, table)
+		if moved, ok := p.retired[table]; ok {
+			if err := os.Remove(moved); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("Error removing retired table %s: %v\n", moved, err)
+			}
+			delete(p.retired, table)
+		}
+	}
+}
+
+// Remove deletes a table that compaction no longer needs. A pinned table is
+// moved out of the way instead and deleted once its last snapshot lets go.
+func (p *TablePins) Remove(table string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.refs[table] == 0 {
+		return os.Remove(table)
+	}
+	moved := table + RetiredSuffix
+	if err := os.Rename(table, moved); err != nil {
+		return err
+	}
+	p.retired[table] = moved
+	return nil
+}
+
+// Resolve returns where a pinned table can be read now
+func (p *TablePins) Resolve(table string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if moved, ok := p.retired[table]; ok {
+		return moved
+	}
+	return table
+}
+
+// RemoveRetired deletes the retired tables left in dirs by a previous run,
+// snapshots do not outlive the engine
+func RemoveRetired(dirs []string) error {
+	for _, dir := range dirs {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), RetiredSuffix) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -2,19 +2,18 @@ package token_bucket
 
 import (
 	"fmt"
-	"nosqlEngine/src/config"
 	"time"
 )
 
-var CONFIG = config.GetConfig()
-
 type TokenBucket struct {
 	currTokens     int
 	lastRefillTime int64
+	maxTokens      int
+	refillRate     float64
 }
 
-func GetNewTokenBucket() *TokenBucket{
-	return &TokenBucket{currTokens: CONFIG.MaxTokens, lastRefillTime: time.Now().Unix()}
+func GetNewTokenBucket(maxTokens int, refillRate float64) *TokenBucket{
+	return &TokenBucket{currTokens: maxTokens, lastRefillTime: time.Now().Unix(), maxTokens: maxTokens, refillRate: refillRate}
 }
 
 func (tb *TokenBucket) CheckTokens() (bool, error) {
@@ -23,8 +22,8 @@ func (tb *TokenBucket) CheckTokens() (bool, error) {
 	last_refill_time := tb.lastRefillTime
 	now := time.Now().Unix()
 	elapsed_time := float64(now - last_refill_time)
-	new_tokens := int(elapsed_time * CONFIG.TokenRefillRate) // floor to int
-	curr_tokens = min(new_tokens+curr_tokens, CONFIG.MaxTokens)
+	new_tokens := int(elapsed_time * tb.refillRate) // floor to int
+	curr_tokens = min(new_tokens+curr_tokens, tb.maxTokens)
 	
 	if curr_tokens < 1 {
 		return false, fmt.Errorf("insufficient tokens")
@@ -1,24 +1,30 @@
 package user_limiter
 
 import (
-	"nosqlEngine/src/config"
 	"nosqlEngine/src/service/token_bucket"
+	"sync"
 )
 
-var CONFIG = config.GetConfig()
-
+// UserLimiter is safe for concurrent use
 type UserLimiter struct {
-	data   map[string]*token_bucket.TokenBucket
+	data       map[string]*token_bucket.TokenBucket
+	maxTokens  int
+	refillRate float64
+	lock       sync.Mutex // guards data and the buckets in it
 }
-func NewUserLimiter() *UserLimiter {
+func NewUserLimiter(maxTokens int, refillRate float64) *UserLimiter {
 	return &UserLimiter{
-		data: make(map[string]*token_bucket.TokenBucket),
+		data:       make(map[string]*token_bucket.TokenBucket),
+		maxTokens:  maxTokens,
+		refillRate: refillRate,
 	}
 }
 
 func (ul *UserLimiter) CheckUserTokens(user string) (bool, error) {
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
 	if _, exists := ul.data[user]; !exists {
-		ul.data[user] = token_bucket.GetNewTokenBucket()
+		ul.data[user] = token_bucket.GetNewTokenBucket(ul.maxTokens, ul.refillRate)
 	}
 	return ul.data[user].CheckTokens()
 }
\ No newline at end of file
@@ -0,0 +1,444 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/service/block_manager"
+	"nosqlEngine/src/service/file_writer"
+	"nosqlEngine/src/service/retriever"
+	"nosqlEngine/src/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileName is the name of the manifest in the SSTable directory
+const FileName = "MANIFEST"
+
+// TableMeta describes one live SSTable
+type TableMeta struct {
+	Path     string // relative to the SSTable directory, e.g. lvl1/sstable_000012.db
+	Level    int
+	Number   uint64 // file number, the newer of two tables has the larger one
+	FirstKey string
+	LastKey  string
+	Entries  int
+	MinSeq   uint64
+	MaxSeq   uint64
+	Size     int64 // bytes on disk
+}
+
+// VersionEdit turns one set of live SSTables into the next. Tables are added
+// before the removed ones go, and an edit is applied whole or not at all.
+type VersionEdit struct {
+	Added   []TableMeta
+	Removed []string // paths as returned by Paths
+}
+
+// Manifest is the log of version edits that decides which SSTables hold the
+// data. A table only counts once the edit adding it is durable, files in the
+// level directories that no edit accounts for are left behind by a crash and
+// deleted on Open. It is safe for concurrent use.
+type Manifest struct {
+	lock        sync.RWMutex
+	dir         string // SSTable directory
+	file        *os.File
+	tables      map[string]TableMeta // current version, keyed by relative path
+	next_number uint64               // file number of the next table written
+	bm          *block_manager.BlockManager
+	cfg         config.Config
+}
+
+// Open replays the manifest of cfg's SSTable directory. A directory without
+// one, written before manifests existed, adopts the tables it holds. Files no
+// edit accounts for are deleted, and the log is rewritten as a single edit. A
+// log damaged anywhere but in its last record is refused.
+func Open(bm *block_manager.BlockManager, cfg config.Config) (*Manifest, error) {
+	m := &Manifest{dir: cfg.SSTableDir(), tables: map[string]TableMeta{}, bm: bm, cfg: cfg}
+	path := filepath.Join(m.dir, FileName)
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := m.adopt(); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	default:
+		if err := m.replay(data); err != nil {
+			return nil, err
+		}
+	}
+	for rel := range m.tables {
+		if _, err := os.Stat(filepath.Join(m.dir, rel)); err != nil {
+			return nil, fmt.Errorf("manifest lists missing SSTable %s: %w", rel, err)
+		}
+	}
+	if err := m.removeOrphans(); err != nil {
+		return nil, err
+	}
+	for _, meta := range m.tables {
+		m.next_number = max(m.next_number, meta.Number)
+	}
+	m.next_number++
+	if err := m.rewrite(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// replay applies the edits in data. Only the last record may be cut short, as
+// an interrupted append leaves it. Any other damage fails the replay, going on
+// without the later edits would delete the tables they added.
+func (m *Manifest) replay(data []byte) error {
+	for offset := 0; len(data) > 0; {
+		if len(data) < 8 {
+			return nil
+		}
+		size := binary.LittleEndian.Uint32(data[4:8])
+		if uint64(len(data)-8) < uint64(size) {
+			return nil
+		}
+		payload := data[8 : 8+size]
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[:4]) {
+			return fmt.Errorf("manifest record at offset %d is corrupted", offset)
+		}
+		edit, err := decodeEdit(payload)
+		if err != nil {
+			return fmt.Errorf("failed to decode manifest record at offset %d: %w", offset, err)
+		}
+		m.apply(edit)
+		data = data[8+size:]
+		offset += 8 + int(size)
+	}
+	return nil
+}
+
+// adopt builds the first version from the tables found in the level
+// directories. Tables named before file numbers existed are numbered after
+// the others, in the order of their newest entry.
+func (m *Manifest) adopt() error {
+	unnumbered := []TableMeta{}
+	next := uint64(0)
+	for level := 0; level <= m.cfg.LSMLevels; level++ {
+		for _, path := range utils.GetPaths(m.cfg.LevelDir(level), ".db") {
+			meta, err := m.Describe(path, level)
+			if err != nil {
+				return err
+			}
+			if meta.Number == 0 {
+				unnumbered = append(unnumbered, meta)
+				continue
+			}
+			m.tables[meta.Path] = meta
+			next = max(next, meta.Number)
+		}
+	}
+	sort.SliceStable(unnumbered, func(i, j int) bool { return unnumbered[i].MaxSeq < unnumbered[j].MaxSeq })
+	for _, meta := range unnumbered {
+		next++
+		meta.Number = next
+		m.tables[meta.Path] = meta
+	}
+	return nil
+}
+
+// removeOrphans deletes the SSTables and staged files in the level directories
+// that are not part of the current version
+func (m *Manifest) removeOrphans() error {
+	for level := 0; level <= m.cfg.LSMLevels; level++ {
+		files, err := os.ReadDir(m.cfg.LevelDir(level))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list lvl%d: %w", level, err)
+		}
+		for _, file := range files {
+			name := file.Name()
+			if !strings.HasSuffix(name, ".db") && !strings.HasSuffix(name, file_writer.TmpSuffix) {
+				continue
+			}
+			rel := filepath.Join(fmt.Sprintf("lvl%d", level), name)
+			if _, live := m.tables[rel]; live {
+				continue
+			}
+			if err := os.Remove(filepath.Join(m.dir, rel)); err != nil {
+				return fmt.Errorf("failed to remove orphaned SSTable %s: %w", rel, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rewrite replaces the log with one edit adding the current version, so it
+// does not grow across restarts
+func (m *Manifest) rewrite() error {
+	path := filepath.Join(m.dir, FileName)
+	edit := VersionEdit{}
+	for _, meta := range m.sorted() {
+		edit.Added = append(edit.Added, meta)
+	}
+	tmp := path + file_writer.TmpSuffix
+	if err := os.WriteFile(tmp, encodeRecord(edit), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := m.bm.Sync(tmp); err != nil {
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace manifest: %w", err)
+	}
+	if err := m.bm.Sync(m.dir); err != nil {
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest: %w", err)
+	}
+	m.file = file
+	return nil
+}
+
+// Apply makes edit durable and then the current version. Readers listing
+// tables meanwhile see the version before or after the edit, never a part.
+func (m *Manifest) Apply(edit VersionEdit) error {
+	removed := make([]string, len(edit.Removed))
+	for i, path := range edit.Removed {
+		rel, err := m.relative(path)
+		if err != nil {
+			return err
+		}
+		removed[i] = rel
+	}
+	edit.Removed = removed
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, err := m.file.Write(encodeRecord(edit)); err != nil {
+		return fmt.Errorf("failed to append to manifest: %w", err)
+	}
+	if err := m.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	m.apply(edit)
+	return nil
+}
+
+func (m *Manifest) apply(edit VersionEdit) {
+	for _, meta := range edit.Added {
+		m.tables[meta.Path] = meta
+	}
+	for _, rel := range edit.Removed {
+		delete(m.tables, rel)
+	}
+}
+
+// NextNumber reserves the file number of a new table
+func (m *Manifest) NextNumber() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	number := m.next_number
+	m.next_number++
+	return number
+}
+
+// Describe reads the metadata of the SSTable at path, which belongs to level
+// and takes its file number from its name
+func (m *Manifest) Describe(path string, level int) (TableMeta, error) {
+	rel, err := m.relative(path)
+	if err != nil {
+		return TableMeta{}, err
+	}
+	meta := TableMeta{Path: rel, Level: level, Number: file_writer.TableNumber(path)}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return TableMeta{}, fmt.Errorf("failed to read size of %s: %w", path, err)
+	}
+	meta.Size = stat.Size()
+	it, err := retriever.NewTableIterator(m.bm, m.cfg, path, nil)
+	if err != nil {
+		return TableMeta{}, err
+	}
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		entry := it.Entry()
+		if meta.Entries == 0 {
+			meta.FirstKey, meta.MinSeq = entry.GetKey(), entry.GetSeq()
+		}
+		meta.LastKey = entry.GetKey()
+		meta.MinSeq = min(meta.MinSeq, entry.GetSeq())
+		meta.MaxSeq = max(meta.MaxSeq, entry.GetSeq())
+		meta.Entries++
+	}
+	if err := it.Err(); err != nil {
+		return TableMeta{}, fmt.Errorf("failed to describe %s: %w", path, err)
+	}
+	return meta, nil
+}
+
+// relative turns a path under the SSTable directory into the key of its table
+func (m *Manifest) relative(path string) (string, error) {
+	rel, err := filepath.Rel(m.dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("SSTable %s is outside %s", path, m.dir)
+	}
+	return rel, nil
+}
+
+// sorted returns the current version ordered by level, oldest first within one
+func (m *Manifest) sorted() []TableMeta {
+	tables := make([]TableMeta, 0, len(m.tables))
+	for _, meta := range m.tables {
+		tables = append(tables, meta)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].Level != tables[j].Level {
+			return tables[i].Level < tables[j].Level
+		}
+		if tables[i].Number != tables[j].Number {
+			return tables[i].Number < tables[j].Number
+		}
+		return tables[i].Path < tables[j].Path
+	})
+	return tables
+}
+
+// SearchOrder returns the tables whose key range holds key in the order a
+// lookup reads them, the ReadOrder
+func SearchOrder(tables []TableMeta, key string, compare func(a, b string) int) []TableMeta {
+	order := []TableMeta{}
+	for _, meta := range tables {
+		if meta.Entries > 0 && compare(key, meta.FirstKey) >= 0 && compare(key, meta.LastKey) <= 0 {
+			order = append(order, meta)
+		}
+	}
+	return ReadOrder(order)
+}
+
+// ReadOrder sorts tables newest first: lvl0 newest first, then the levels
+// below one by one. Tables of one level may share keys under size-tiered
+// compaction, they are ordered newest first as well, so the first version of
+// a key met is the newest.
+func ReadOrder(tables []TableMeta) []TableMeta {
+	sort.SliceStable(tables, func(i, j int) bool {
+		if tables[i].Level != tables[j].Level {
+			return tables[i].Level < tables[j].Level
+		}
+		return tables[i].Number > tables[j].Number
+	})
+	return tables
+}
+
+// Tables returns the metadata of every live SSTable, ordered by level and
+// oldest first within one
+func (m *Manifest) Tables() []TableMeta {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.sorted()
+}
+
+// Level returns the metadata of the live SSTables of one level, oldest first
+func (m *Manifest) Level(level int) []TableMeta {
+	tables := []TableMeta{}
+	for _, meta := range m.Tables() {
+		if meta.Level == level {
+			tables = append(tables, meta)
+		}
+	}
+	return tables
+}
+
+// Paths returns where every live SSTable is, ordered by level
+func (m *Manifest) Paths() []string {
+	return m.PathsOf(m.Tables())
+}
+
+// PathsOf returns where the given tables are
+func (m *Manifest) PathsOf(tables []TableMeta) []string {
+	paths := make([]string, len(tables))
+	for i, meta := range tables {
+		paths[i] = filepath.Join(m.dir, meta.Path)
+	}
+	return paths
+}
+
+// Close closes the log, the manifest must not be changed afterwards
+func (m *Manifest) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.file.Close()
+}
+
+// encodeRecord frames an edit as its CRC (4 bytes), its length (4 bytes) and
+// the edit itself
+func encodeRecord(edit VersionEdit) []byte {
+	payload := encodeEdit(edit)
+	record := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(record[:4], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(payload)))
+	return append(record, payload...)
+}
+
+func encodeEdit(edit VersionEdit) []byte {
+	var buf bytes.Buffer
+	putUvarint := func(n uint64) { buf.Write(binary.AppendUvarint(nil, n)) }
+	putString := func(s string) {
+		putUvarint(uint64(len(s)))
+		buf.WriteString(s)
+	}
+	putUvarint(uint64(len(edit.Added)))
+	for _, meta := range edit.Added {
+		putString(meta.Path)
+		putUvarint(uint64(meta.Level))
+		putUvarint(meta.Number)
+		putString(meta.FirstKey)
+		putString(meta.LastKey)
+		putUvarint(uint64(meta.Entries))
+		putUvarint(meta.MinSeq)
+		putUvarint(meta.MaxSeq)
+		putUvarint(uint64(meta.Size))
+	}
+	putUvarint(uint64(len(edit.Removed)))
+	for _, rel := range edit.Removed {
+		putString(rel)
+	}
+	return buf.Bytes()
+}
+
+func decodeEdit(data []byte) (VersionEdit, error) {
+	var err error
+	uvarint := func() uint64 {
+		n, size := binary.Uvarint(data)
+		if size <= 0 {
+			err = fmt.Errorf("malformed manifest record")
+			return 0
+		}
+		data = data[size:]
+		return n
+	}
+	str := func() string {
+		n := uvarint()
+		if err != nil || uint64(len(data)) < n {
+			err = fmt.Errorf("malformed manifest record")
+			return ""
+		}
+		s := string(data[:n])
+		data = data[n:]
+		return s
+	}
+	edit := VersionEdit{}
+	for added := uvarint(); err == nil && added > 0; added-- {
+		meta := TableMeta{Path: str(), Level: int(uvarint()), Number: uvarint(), FirstKey: str(), LastKey: str()}
+		meta.Entries = int(uvarint())
+		meta.MinSeq, meta.MaxSeq, meta.Size = uvarint(), uvarint(), int64(uvarint())
+		edit.Added = append(edit.Added, meta)
+	}
+	for removed := uvarint(); err == nil && removed > 0; removed-- {
+		edit.Removed = append(edit.Removed, str())
+	}
+	return edit, err
+}
@@ -0,0 +1,131 @@
+package memtable
+
+import (
+	"nosqlEngine/src/models/key_value"
+	"sort"
+)
+
+// Iterator walks the entries of a memtable in key order, either way,
+// tombstones included. A sorted memtable is walked forward with ScanFrom, one
+// seek per step. Other memtables, and walks backward, read the memtable in key
+// order once and keep that copy. Writes must not run while the iterator
+// moves, and entries written after the copy was made are not seen.
+type Iterator struct {
+	mem      Memtable
+	compare  func(a, b string) int
+	entries  []key_value.KeyValue // the memtable in key order, read when first needed
+	loaded   bool
+	pos      int
+	scanning bool               // the iterator walks with ScanFrom instead of entries
+	entry    key_value.KeyValue // current entry of a walk with ScanFrom
+	found    bool               // the walk with ScanFrom is at an entry
+}
+
+// NewIterator returns an iterator over mem ordered by compare, which must be
+// the order sorted memtables were built with. It is not positioned until
+// Seek is called.
+func NewIterator(mem Memtable, compare func(a, b string) int) *Iterator {
+	return &Iterator{mem: mem, compare: compare}
+}
+
+// load reads the memtable in key order, unless it was read already
+func (it *Iterator) load() {
+	it.scanning = false
+	if it.loaded {
+		return
+	}
+	it.entries, it.loaded = it.mem.ToRaw(), true
+	if _, sorted := it.mem.(SortedMemtable); !sorted {
+		key_value.SortByKeys(&it.entries, it.compare)
+	}
+}
+
+// scanFrom moves to the first entry with a key >= start, or > start when
+// after is set
+func (it *Iterator) scanFrom(start string, after bool) {
+	it.scanning, it.found = true, false
+	it.mem.(SortedMemtable).ScanFrom(start, func(entry key_value.KeyValue) bool {
+		if after && it.compare(entry.GetKey(), start) == 0 {
+			return true
+		}
+		it.entry, it.found = entry, true
+		return false
+	})
+}
+
+// search returns the index of the first entry of the copy for which past holds
+func (it *Iterator) search(past func(cmp int) bool, key string) int {
+	return sort.Search(len(it.entries), func(i int) bool {
+		return past(it.compare(it.entries[i].GetKey(), key))
+	})
+}
+
+// Seek positions the iterator at the first entry with a key >= key
+func (it *Iterator) Seek(key string) {
+	if _, sorted := it.mem.(SortedMemtable); sorted {
+		it.scanFrom(key, false)
+		return
+	}
+	it.load()
+	it.pos = it.search(func(cmp int) bool { return cmp >= 0 }, key)
+}
+
+// SeekForPrev positions the iterator at the last entry with a key <= key
+func (it *Iterator) SeekForPrev(key string) {
+	it.load()
+	it.pos = it.search(func(cmp int) bool { return cmp > 0 }, key) - 1
+}
+
+// SeekToLast positions the iterator at the last entry
+func (it *Iterator) SeekToLast() {
+	it.load()
+	it.pos = len(it.entries) - 1
+}
+
+// Valid reports whether the iterator is positioned at an entry
+func (it *Iterator) Valid() bool {
+	if it.scanning {
+		return it.found
+	}
+	return it.pos >= 0 && it.pos < len(it.entries)
+}
+
+// Entry returns the current entry
+func (it *Iterator) Entry() key_value.KeyValue {
+	if it.scanning {
+		return it.entry
+	}
+	return it.entries[it.pos]
+}
+
+// Next moves to the following entry
+func (it *Iterator) Next() {
+	switch {
+	case it.scanning && it.found:
+		it.scanFrom(it.entry.GetKey(), true)
+	case !it.scanning && it.Valid():
+		it.pos++
+	}
+}
+
+// Prev moves to the preceding entry
+func (it *Iterator) Prev() {
+	if it.scanning {
+		if !it.found {
+			return
+		}
+		// ScanFrom only walks forward, continue on the copy
+		key := it.entry.GetKey()
+		it.load()
+		it.pos = it.search(func(cmp int) bool { return cmp >= 0 }, key) - 1
+		return
+	}
+	if it.Valid() {
+		it.pos--
+	}
+}
+
+// Err always returns nil, memtables are read from memory
+func (it *Iterator) Err() error {
+	return nil
+}
@@ -2,20 +2,20 @@ package memtable
 
 import (
 	"nosqlEngine/src/config"
+	"nosqlEngine/src/models/b_tree"
 	"nosqlEngine/src/models/hash_map"
+	skiplist "nosqlEngine/src/models/skip_list"
 )
 
-var CONFIG = config.GetConfig()
-
-func NewMemtable() Memtable {
+func NewMemtable(cfg config.Config) Memtable {
 	var memtable Memtable
-	switch CONFIG.MemtableType {
-	case "hashmap":
-		memtable = hash_map.NewHashMap()
+	switch cfg.MemtableType {
 	case "skiplist":
-		memtable = hash_map.NewHashMap() //skiplist
+		memtable = skiplist.NewSkipList(cfg.SkipListLevels, cfg.Comparator().CompareStrings)
+	case "btree":
+		memtable = b_tree.NewBTree(cfg.BTreeDegree, cfg.Comparator().CompareStrings)
 	default:
-		memtable = hash_map.NewHashMap() // b-tree
+		memtable = hash_map.NewHashMap()
 	}
 	return memtable
-}
\ No newline at end of file
+}
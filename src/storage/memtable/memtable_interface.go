@@ -2,11 +2,19 @@ package memtable
 
 import "nosqlEngine/src/models/key_value"
 
+// Memtable holds the latest entry per key. Deletes are stored as tombstone
+// entries so they shadow older values in the SSTables.
 type Memtable interface {
-	Add(key string, value string) bool
-	Get(key string) (string, bool)
-	ToRaw() []key_value.KeyValue // keys, values
+	Add(entry key_value.KeyValue) bool
+	Get(key string) (key_value.KeyValue, bool)
+	ToRaw() []key_value.KeyValue // keys, values, tombstones
 	GetSize() int
 	Clear() bool
 }
 
+// SortedMemtable is a Memtable that keeps its keys ordered, so ToRaw is
+// already sorted and scans can seek to a start key.
+type SortedMemtable interface {
+	Memtable
+	ScanFrom(start string, visit func(entry key_value.KeyValue) bool)
+}
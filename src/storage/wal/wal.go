@@ -5,27 +5,41 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
-	"io"
 	"nosqlEngine/src/config"
 	"nosqlEngine/src/service/block_manager"
-	"nosqlEngine/src/service/file_reader"
 	"nosqlEngine/src/service/file_writer"
 	"nosqlEngine/src/utils"
 	"os"
-	"path/filepath"
-	"runtime"
+	"sync"
 	"time"
 )
 
-var CONFIG = config.GetConfig()
-
 // WALEntry represents a single log entry in the WAL
-// Operation: "PUT" or "DELETE"
+// Operation: "PUT", "DELETE" or "BATCH"
 type WALEntry struct {
 	Operation string
 	Key       string
-	Value     string // empty for DELETE
-	Timestamp int64  // seconds since epoch
+	Value     string     // empty for DELETE
+	Timestamp int64      // seconds since epoch
+	Seq       uint64     // position in the log, assigned by Append
+	Batch     []WALEntry // operations of a BATCH, numbered from Seq on
+	ExpiresAt int64      // unix nanoseconds after which a PUT is gone, 0 if never
+}
+
+// Operation codes stored in the type byte of a record
+const (
+	opPut         = 0
+	opDelete      = 1
+	opBatch       = 2 // the value holds the encoded operations, covered by the record CRC
+	opExpiringPut = 3 // the value starts with ExpiresAt, 8 bytes
+)
+
+// lastSeq returns the sequence number of the last operation in the entry
+func (entry WALEntry) lastSeq() uint64 {
+	if entry.Operation == "BATCH" {
+		return entry.Seq + uint64(len(entry.Batch)) - 1
+	}
+	return entry.Seq
 }
 
 // WAL handles writing to the write-ahead log file with a buffer pool and supports rotation/archiving
@@ -34,6 +48,9 @@ type WALEntry struct {
 //	wal.Rotate("data/wal/wal-20250625.log")
 //	wal.Archive("data/wal/wal-20250625.log", "data/wal/archive/wal-20250625.log")
 //	wal.Delete("data/wal/wal-20250625.log")
+//
+// A WAL is safe for concurrent use. Entries are appended to an in-memory
+// buffer and committed (written and fsynced) in groups, see wal_sync.go.
 type WAL struct {
 	//file        *os.File
 	buffer      []WALEntry              // changed from []string to []WALEntry
@@ -41,38 +58,75 @@ type WAL struct {
 	segmentSize int                     // size of each segment in bytes
 	writer      *file_writer.FileWriter // add FileWriter for block writing
 	bm          *block_manager.BlockManager
+	cfg         config.Config
+
+	lock         sync.Mutex        // guards the buffer and the commit state below
+	committed    *sync.Cond        // signalled on lock when a commit finishes or a group is ready
+	appended     uint64            // sequence number of the last appended entry
+	durable      uint64            // entries up to this sequence number reached the configured durability
+	committing   bool              // a goroutine is writing a batch
+	firstPending time.Time         // when the oldest buffered entry was appended
+	err          error             // first commit failure, the WAL refuses writes afterwards
+	io_lock      sync.Mutex        // guards writer and the segment files
+	segment_last map[string]uint64 // last sequence number in each segment, guarded by io_lock
+	checkpoint   uint64            // last persisted checkpoint, guarded by io_lock
+	stop         chan struct{}
+	stopped      chan struct{}
 }
 
 // NewWAL creates or opens a WAL file for appending, with a buffer pool of given size
-func NewWAL(block_manager *block_manager.BlockManager) (*WAL, error) {
+func NewWAL(block_manager *block_manager.BlockManager, cfg config.Config) (*WAL, error) {
 	// f, err := os.OpenFile("data/wal/current-wal.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	// if err != nil {
 	// 	return nil, err
 	// }
-	bufferSize := CONFIG.WALBufferSize                                                             // default buffer size
-	segmentSize := CONFIG.WALSegmentSize                                                           // default segment size in bytes
-	writer := file_writer.NewFileWriter(block_manager, CONFIG.BlockSize, generateWALSegmentName()) // Create a new FileWriter with the segment size
-	return &WAL{buffer: make([]WALEntry, 0, bufferSize), bufferSize: bufferSize, segmentSize: segmentSize, writer: writer, bm: block_manager}, nil
+	bufferSize := cfg.WALBufferSize                                                                           // default buffer size
+	segmentSize := cfg.WALSegmentSize                                                                         // default segment size in bytes
+	writer := file_writer.NewFileWriter(block_manager, cfg.BlockSize, cfg.WALDir(), generateWALSegmentName()) // Create a new FileWriter with the segment size
+	checkpoint, err := ReadCheckpoint(cfg.WALDir())
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{buffer: make([]WALEntry, 0, bufferSize), bufferSize: bufferSize, segmentSize: segmentSize, writer: writer, bm: block_manager, cfg: cfg}
+	// numbering continues after everything already covered by SSTables
+	w.appended, w.durable = checkpoint, checkpoint
+	w.checkpoint = checkpoint
+	w.segment_last = make(map[string]uint64)
+	w.committed = sync.NewCond(&w.lock)
+	w.stop = make(chan struct{})
+	w.stopped = make(chan struct{})
+	if cfg.WALSyncMode == SyncPeriodic {
+		go w.runPeriodicSync()
+	} else {
+		close(w.stopped)
+	}
+	return w, nil
 }
 
 // encodeWALEntry encodes a WALEntry into the binary WAL format
 func encodeWALEntry(entry WALEntry) ([]byte, error) {
 	keyBytes := []byte(entry.Key)
-	valueBytes := []byte(entry.Value)
+	var tombstone byte = opBatch
+	var valueBytes []byte
+	if entry.Operation == "BATCH" {
+		keyBytes, valueBytes = nil, encodeBatchOps(entry.Batch)
+	} else {
+		tombstone, valueBytes = encodeOp(entry)
+	}
 	keySize := uint64(len(keyBytes))
 	valueSize := uint64(len(valueBytes))
-	var tombstone byte = 0
-	if entry.Operation == "DELETE" {
-		tombstone = 1
-	}
 	buf := new(bytes.Buffer)
 	// Reserve space for CRC (4 bytes)
 	buf.Write(make([]byte, 4))
+	// Sequence number (8 bytes)
+	seq := make([]byte, 8)
+	binary.LittleEndian.PutUint64(seq, entry.Seq)
+	buf.Write(seq)
 	// Timestamp (8 bytes, use int64 seconds)
 	ts := make([]byte, 8)
 	binary.LittleEndian.PutUint64(ts, uint64(entry.Timestamp))
 	buf.Write(ts)
-	// Tombstone (1 byte)
+	// Tombstone, or operation code (1 byte)
 	buf.WriteByte(tombstone)
 	// Key Size (8 bytes)
 	ks := make([]byte, 8)
@@ -92,65 +146,154 @@ func encodeWALEntry(entry WALEntry) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// WritePut logs a PUT operation to the WAL buffer
-func (w *WAL) WritePut(key, value string) error {
-	entry := WALEntry{
+// NewPutEntry builds the WAL entry of a PUT operation
+func NewPutEntry(key, value string) WALEntry {
+	return WALEntry{
 		Operation: "PUT",
 		Key:       key,
 		Value:     value,
 		Timestamp: time.Now().Unix(),
 	}
-	w.buffer = append(w.buffer, entry)
+}
 
-	if len(w.buffer) >= w.bufferSize {
-		return w.Flush()
+// encodeOp returns the operation code and the stored value of a PUT or DELETE
+func encodeOp(op WALEntry) (byte, []byte) {
+	switch {
+	case op.Operation == "DELETE":
+		return opDelete, []byte(op.Value)
+	case op.ExpiresAt != 0:
+		value := binary.LittleEndian.AppendUint64(make([]byte, 0, 8+len(op.Value)), uint64(op.ExpiresAt))
+		return opExpiringPut, append(value, op.Value...)
 	}
-	return nil
+	return opPut, []byte(op.Value)
 }
 
-// WriteDelete logs a DELETE operation to the WAL buffer
-func (w *WAL) WriteDelete(key string) error {
-	entry := WALEntry{
+// decodeOp builds the entry of a PUT or DELETE from its operation code and
+// stored value
+func decodeOp(code byte, key, value []byte, seq uint64, ts int64) (WALEntry, error) {
+	op := WALEntry{Operation: "PUT", Key: string(key), Timestamp: ts, Seq: seq}
+	switch code {
+	case opDelete:
+		op.Operation = "DELETE"
+	case opExpiringPut:
+		if len(value) < 8 {
+			return WALEntry{}, fmt.Errorf("invalid WAL expiring put of %d bytes", len(value))
+		}
+		op.ExpiresAt = int64(binary.LittleEndian.Uint64(value[:8]))
+		value = value[8:]
+	}
+	op.Value = string(value)
+	return op, nil
+}
+
+// encodeBatchOps encodes the operations of a batch one after another as
+// operation code (1) + key size (8) + value size (8) + key + value
+func encodeBatchOps(ops []WALEntry) []byte {
+	buf := new(bytes.Buffer)
+	size := make([]byte, 8)
+	for _, op := range ops {
+		code, value := encodeOp(op)
+		buf.WriteByte(code)
+		binary.LittleEndian.PutUint64(size, uint64(len(op.Key)))
+		buf.Write(size)
+		binary.LittleEndian.PutUint64(size, uint64(len(value)))
+		buf.Write(size)
+		buf.WriteString(op.Key)
+		buf.Write(value)
+	}
+	return buf.Bytes()
+}
+
+// decodeBatchOps parses the operations of a batch whose first one is numbered seq
+func decodeBatchOps(data []byte, seq uint64, ts int64) ([]WALEntry, error) {
+	var ops []WALEntry
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < 17 {
+			return nil, fmt.Errorf("invalid WAL batch operation at byte %d", pos)
+		}
+		code := data[pos]
+		keySize := binary.LittleEndian.Uint64(data[pos+1 : pos+9])
+		valueSize := binary.LittleEndian.Uint64(data[pos+9 : pos+17])
+		pos += 17
+		if uint64(len(data)-pos) < keySize || uint64(len(data)-pos)-keySize < valueSize {
+			return nil, fmt.Errorf("invalid WAL batch operation at byte %d", pos)
+		}
+		key := data[pos : pos+int(keySize)]
+		pos += int(keySize)
+		op, err := decodeOp(code, key, data[pos:pos+int(valueSize)], seq+uint64(len(ops)), ts)
+		if err != nil {
+			return nil, err
+		}
+		pos += int(valueSize)
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("empty WAL batch")
+	}
+	return ops, nil
+}
+
+// NewBatchEntry builds the WAL entry of a batch of PUT and DELETE entries, which
+// is written as one record and so replayed completely or not at all
+func NewBatchEntry(ops []WALEntry) WALEntry {
+	return WALEntry{
+		Operation: "BATCH",
+		Batch:     ops,
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// NewDeleteEntry builds the WAL entry of a DELETE operation
+func NewDeleteEntry(key string) WALEntry {
+	return WALEntry{
 		Operation: "DELETE",
 		Key:       key,
 		Value:     "",
 		Timestamp: time.Now().Unix(),
 	}
-	// data, err := encodeWALEntry(entry)
-	// if err != nil {
-	// 	return err
-	// }
-	w.buffer = append(w.buffer, entry)
-	if len(w.buffer) >= w.bufferSize {
-		return w.Flush()
-	}
-	return nil
 }
 
-func (w *WAL) Flush() error {
-	if len(w.buffer) == 0 {
+// WritePut logs a PUT operation and waits until it is durable
+func (w *WAL) WritePut(key, value string) error {
+	return w.WaitDurable(w.Append(NewPutEntry(key, value)))
+}
+
+// WriteDelete logs a DELETE operation and waits until it is durable
+func (w *WAL) WriteDelete(key string) error {
+	return w.WaitDurable(w.Append(NewDeleteEntry(key)))
+}
+
+// writeBatch writes entries to the current segment, pads out the last block so
+// that it reaches the file, fsyncs the segment and rotates it once it is full
+func (w *WAL) writeBatch(batch []WALEntry) error {
+	if len(batch) == 0 {
 		return nil
 	}
-	for _, entry := range w.buffer {
+	w.io_lock.Lock()
+	defer w.io_lock.Unlock()
+	for _, entry := range batch {
 		data, err := encodeWALEntry(entry)
 		if err != nil {
 			return err
 		}
-		if w.writer != nil {
-			w.writer.Write(data, false, nil)
-			// If the segment size is reached, archive the current WAL segment
-		} else {
-			return nil
-		}
+		w.writer.Write(data, false, nil)
+	}
+	w.segment_last[w.writer.GetLocation()] = batch[len(batch)-1].lastSeq()
+	// a block that did not reach the segment fails the commit, syncing the
+	// rest would acknowledge entries that are not there
+	if err := w.writer.FlushCurrentBlock(); err != nil {
+		return fmt.Errorf("failed to write WAL segment: %w", err)
+	}
+	if err := w.bm.Sync(w.writer.GetLocation()); err != nil {
+		return fmt.Errorf("failed to sync WAL segment: %w", err)
 	}
 	size, err := getFileSize(w.writer.GetLocation())
 	if err != nil {
 		return err
 	}
 	if size >= int64(w.segmentSize) {
-		w.Rotate()
+		w.rotate()
 	}
-	w.buffer = w.buffer[:0]
 	return nil
 }
 
@@ -170,113 +313,70 @@ func getFileSize(path string) (int64, error) {
 // 	return w.writer.Close()
 // }
 
+// Rotate starts a new segment, buffered entries are committed to the new one
 func (w *WAL) Rotate() error {
-	// w.writer.SetLocation(generateWALSegmentName())
-	w.writer = file_writer.NewFileWriter(w.bm, CONFIG.BlockSize, generateWALSegmentName())
-	w.buffer = make([]WALEntry, 0, w.bufferSize)
+	w.io_lock.Lock()
+	defer w.io_lock.Unlock()
+	w.rotate()
 	return nil
 }
 
+// rotate is Rotate for callers that hold io_lock
+func (w *WAL) rotate() {
+	// w.writer.SetLocation(generateWALSegmentName())
+	w.writer = file_writer.NewFileWriter(w.bm, w.cfg.BlockSize, w.cfg.WALDir(), generateWALSegmentName())
+}
+
 // Helper to generate a rotated WAL filename with timestampc
 
 func generateWALSegmentName() string {
-	return fmt.Sprintf("wal/wal-%s.log", time.Now().Format("20060102-150405.000000000"))
+	return fmt.Sprintf("wal-%s.log", time.Now().Format("20060102-150405.000000000"))
 }
 
-// Helper to read and parse a single WAL entry from the file
-func readWALEntry(reader *file_reader.FileReader, blockNum int) (*WALEntry, uint32, []byte, int, error) {
-	content, blocksUsed, _ := reader.ReadEntry(blockNum)
-	if len(content) == 0 {
-		return nil, 0, nil, 0, io.EOF // No more entries
-	}
+// walHeaderSize is CRC (4) + sequence number (8) + timestamp (8) + tombstone (1)
+// + key size (8) + value size (8)
+const walHeaderSize = 37
 
-	crc := binary.LittleEndian.Uint32(content[0:4])
-	ts := int64(binary.LittleEndian.Uint64(content[4:12]))
-	tombstone := content[12]
-	keySize := binary.LittleEndian.Uint64(content[13:21])
-	valueSize := binary.LittleEndian.Uint64(content[21:29])
-	if len(content) < 29+int(keySize)+int(valueSize) {
-		return nil, 0, nil, 0, fmt.Errorf("invalid WAL entry size: %d bytes", len(content))
-	}
-	key := content[29 : 29+keySize]
-	value := content[29+keySize : 29+keySize+valueSize]
-	payload := content[4:]
-	op := "PUT"
-	if tombstone == 1 {
-		op = "DELETE"
+// decodeWALEntry parses the record at the start of content, a block or jumbo
+// sequence may hold several records one after another. A batch record yields
+// all of its operations.
+func decodeWALEntry(content []byte) ([]WALEntry, int, error) {
+	if len(content) < walHeaderSize {
+		return nil, 0, fmt.Errorf("invalid WAL entry size: %d bytes", len(content))
 	}
-	entry := &WALEntry{
-		Operation: op,
-		Key:       string(key),
-		Value:     string(value),
-		Timestamp: ts,
+	crc := binary.LittleEndian.Uint32(content[0:4])
+	seq := binary.LittleEndian.Uint64(content[4:12])
+	ts := int64(binary.LittleEndian.Uint64(content[12:20]))
+	tombstone := content[20]
+	keySize := binary.LittleEndian.Uint64(content[21:29])
+	valueSize := binary.LittleEndian.Uint64(content[29:37])
+	if uint64(len(content)-walHeaderSize) < keySize || uint64(len(content)-walHeaderSize)-keySize < valueSize {
+		return nil, 0, fmt.Errorf("invalid WAL entry size: %d bytes", len(content))
 	}
-	return entry, crc, payload, blocksUsed, nil
-}
-
-func GetWALSegmentPaths() ([]string, error) {
-	segmentPaths := utils.GetPaths("data/wal", ".log")
-	return segmentPaths, nil
-}
-func getProjectRoot() string {
-	_, filename, _, _ := runtime.Caller(0)
-	// Go up from src/service/file_writer/writer.go to project root
-	projectRoot := filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(filename))))
-	return projectRoot
-}
-
-// ReplayWAL reads all the WAL segment files and returns all entries (for recovery)
-func ReplayWAL(block_manager *block_manager.BlockManager) ([]WALEntry, error) {
-	var allEntries []WALEntry
-	reader := file_reader.NewFileReader("", CONFIG.BlockSize, *block_manager)
-	// Get the list of WAL segment files
-	segmentPaths, err := GetWALSegmentPaths()
-	if err != nil {
-		return nil, err
+	size := walHeaderSize + int(keySize) + int(valueSize)
+	// Validate CRC
+	if crc32.ChecksumIEEE(content[4:size]) != crc {
+		return nil, 0, fmt.Errorf("WAL entry CRC mismatch")
 	}
-	// Replay each segment
-	for _, segment := range segmentPaths {
-		reader.SetLocation(segment)
-		entries, err := replayWALSegment(reader)
+	key := content[walHeaderSize : walHeaderSize+keySize]
+	value := content[walHeaderSize+keySize : size]
+	if tombstone == opBatch {
+		ops, err := decodeBatchOps(value, seq, ts)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
-		allEntries = append(allEntries, entries...)
+		return ops, size, nil
 	}
-	return allEntries, nil
-}
-
-func replayWALSegment(reader *file_reader.FileReader) ([]WALEntry, error) {
-	var entries []WALEntry
-	var blockIdx int
-	for {
-		entry, crc, payload, blocksUsed, err := readWALEntry(reader, blockIdx)
-		if err == io.EOF {
-			break // End of segment
-		}
-		blockIdx += blocksUsed
-		// Validate CRC
-		if crc32.ChecksumIEEE(payload) != crc {
-			return nil, fmt.Errorf("WAL entry CRC mismatch")
-		}
-		entries = append(entries, *entry)
+	entry, err := decodeOp(tombstone, key, value, seq, ts)
+	if err != nil {
+		return nil, 0, err
 	}
-	return entries, nil
+	return []WALEntry{entry}, size, nil
 }
 
-// WAL deletes the WAL folder, to be used when all memtables are flushed
-func (wal *WAL) DeleteWALSegments() error {
-	segmentPaths, err := GetWALSegmentPaths()
-	if err != nil {
-		return nil
-	}
-	for _, segment := range segmentPaths {
-		if err := os.Remove(segment); err != nil {
-			return fmt.Errorf("failed to delete WAL segment %s: %w", segment, err)
-		}
-	}
-	wal.Rotate() // Reset the WAL to a new segment
-	return nil
+func GetWALSegmentPaths(dir string) ([]string, error) {
+	segmentPaths := utils.GetPaths(dir, ".log")
+	return segmentPaths, nil
 }
 
 func (w *WAL) SetBufferSize(size int) {
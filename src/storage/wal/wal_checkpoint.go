@@ -0,0 +1,107 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// CheckpointFile sits next to the segments and holds the highest sequence
+// number whose data is in durable SSTables. Replay skips everything up to it.
+const CheckpointFile = "CHECKPOINT"
+
+// ReadCheckpoint returns the persisted checkpoint of the WAL in dir, 0 if none
+func ReadCheckpoint(dir string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, CheckpointFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read WAL checkpoint: %w", err)
+	}
+	// sequence number (8) + CRC (4)
+	if len(data) != 12 || crc32.ChecksumIEEE(data[:8]) != binary.LittleEndian.Uint32(data[8:]) {
+		return 0, fmt.Errorf("corrupted WAL checkpoint in %s", dir)
+	}
+	return binary.LittleEndian.Uint64(data[:8]), nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint file
+func writeCheckpoint(dir string, seq uint64) error {
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint64(data[:8], seq)
+	binary.LittleEndian.PutUint32(data[8:], crc32.ChecksumIEEE(data[:8]))
+
+	path := filepath.Join(dir, CheckpointFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Checkpoint records that every entry up to seq is in durable SSTables and
+// deletes the closed segments holding nothing newer. The segment being written
+// is kept even when it is covered.
+func (w *WAL) Checkpoint(seq uint64) error {
+	w.io_lock.Lock()
+	defer w.io_lock.Unlock()
+	if seq <= w.checkpoint {
+		return nil
+	}
+	if err := writeCheckpoint(w.cfg.WALDir(), seq); err != nil {
+		return fmt.Errorf("failed to write WAL checkpoint: %w", err)
+	}
+	w.checkpoint = seq
+	for segment, last := range w.segment_last {
+		if last > seq || segment == w.writer.GetLocation() {
+			continue
+		}
+		if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete WAL segment %s: %w", segment, err)
+		}
+		delete(w.segment_last, segment)
+	}
+	return nil
+}
+
+// Resume continues numbering after the entries found by ReplayWAL and lets
+// later checkpoints delete the replayed segments
+func (w *WAL) Resume(report RecoveryReport) {
+	w.io_lock.Lock()
+	for segment, last := range report.Segments {
+		w.segment_last[segment] = last
+	}
+	w.io_lock.Unlock()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if report.LastSeq > w.appended {
+		w.appended, w.durable = report.LastSeq, report.LastSeq
+	}
+}
@@ -0,0 +1,160 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/service/block_manager"
+	"nosqlEngine/src/service/file_reader"
+	"os"
+)
+
+// Recovery modes, selected by WAL_RECOVERY_MODE
+const (
+	// RecoverTruncate treats the first corruption in the newest segment as a torn
+	// write: the rest of that segment is dropped and cut off the file. Corruption
+	// in an older segment fails the recovery.
+	RecoverTruncate = "truncate"
+	// RecoverSkip drops corrupted records wherever they are and replays the rest
+	RecoverSkip = "skip"
+	// RecoverStrict fails the recovery on any corruption
+	RecoverStrict = "strict"
+)
+
+// DroppedRecords describes records of a segment that recovery left out
+type DroppedRecords struct {
+	Segment string // path of the segment
+	Offset  int64  // byte offset of the block holding the first dropped record
+	Count   int    // number of dropped records, an unreadable stretch counts as one
+	Reason  error
+}
+
+// RecoveryReport sums up a WAL replay
+type RecoveryReport struct {
+	Replayed   int
+	Dropped    []DroppedRecords
+	Checkpoint uint64            // entries up to here were already in SSTables and are not replayed
+	LastSeq    uint64            // highest sequence number found
+	Segments   map[string]uint64 // last sequence number in each replayed segment
+}
+
+// DroppedCount is the total number of records recovery left out
+func (report RecoveryReport) DroppedCount() int {
+	count := 0
+	for _, dropped := range report.Dropped {
+		count += dropped.Count
+	}
+	return count
+}
+
+// ReplayWAL reads all the WAL segment files and returns the entries newer than
+// the checkpoint (for recovery), handling corrupted records as
+// WAL_RECOVERY_MODE says
+func ReplayWAL(block_manager *block_manager.BlockManager, cfg config.Config) ([]WALEntry, RecoveryReport, error) {
+	var allEntries []WALEntry
+	report := RecoveryReport{Segments: make(map[string]uint64)}
+	checkpoint, err := ReadCheckpoint(cfg.WALDir())
+	if err != nil {
+		return nil, report, err
+	}
+	report.Checkpoint, report.LastSeq = checkpoint, checkpoint
+	reader := file_reader.NewFileReader("", cfg.BlockSize, *block_manager)
+	// Get the list of WAL segment files, their names sort oldest first
+	segmentPaths, err := GetWALSegmentPaths(cfg.WALDir())
+	if err != nil {
+		return nil, report, err
+	}
+	// Replay each segment
+	for i, segment := range segmentPaths {
+		reader.SetLocation(segment)
+		isLast := i == len(segmentPaths)-1
+		entries, dropped, truncateAt, err := replayWALSegment(reader, cfg.BlockSize, cfg.WALRecoveryMode, isLast)
+		if err != nil {
+			return nil, report, err
+		}
+		if truncateAt >= 0 {
+			// cut the torn tail off, so the segment reads cleanly once it is no longer the newest
+			if err := os.Truncate(segment, truncateAt); err != nil {
+				return nil, report, fmt.Errorf("failed to truncate WAL segment %s: %w", segment, err)
+			}
+		}
+		report.Segments[segment] = 0
+		for _, entry := range entries {
+			report.Segments[segment] = max(report.Segments[segment], entry.Seq)
+			report.LastSeq = max(report.LastSeq, entry.Seq)
+			if entry.Seq > checkpoint {
+				allEntries = append(allEntries, entry)
+			}
+		}
+		report.Dropped = append(report.Dropped, dropped...)
+	}
+	report.Replayed = len(allEntries)
+	return allEntries, report, nil
+}
+
+// replayWALSegment reads the entries of one segment block by block. It also
+// returns the offset the segment should be truncated at, or -1.
+func replayWALSegment(reader *file_reader.FileReader, blockSize int, mode string, isLast bool) ([]WALEntry, []DroppedRecords, int64, error) {
+	segment := reader.GetLocation()
+	fileSize := reader.GetFileSize()
+	var entries []WALEntry
+	var dropped []DroppedRecords
+	truncateAt := int64(-1) // past a torn write, records are only counted
+	for blockIdx := 0; int64(blockIdx)*int64(blockSize) < fileSize; {
+		offset := int64(blockIdx) * int64(blockSize)
+		blockEntries, blocksUsed, err := readWALBlock(reader, blockIdx)
+		if blocksUsed == 0 {
+			blocksUsed = 1 // an unreadable block is skipped on its own
+		}
+		blockIdx += blocksUsed
+
+		if truncateAt >= 0 {
+			dropped[len(dropped)-1].Count += len(blockEntries)
+			if err != nil {
+				dropped[len(dropped)-1].Count++
+			}
+			continue
+		}
+		if err == nil {
+			entries = append(entries, blockEntries...)
+			continue
+		}
+
+		err = fmt.Errorf("corrupted WAL record in %s at offset %d: %w", segment, offset, err)
+		switch {
+		case mode == RecoverSkip:
+			// the records ahead of the corruption in this block are intact
+			entries = append(entries, blockEntries...)
+			dropped = append(dropped, DroppedRecords{Segment: segment, Offset: offset, Count: 1, Reason: err})
+		case mode == RecoverTruncate && isLast:
+			// the whole torn commit goes, including its intact records
+			dropped = append(dropped, DroppedRecords{Segment: segment, Offset: offset, Count: len(blockEntries) + 1, Reason: err})
+			truncateAt = offset
+		default:
+			return nil, nil, -1, err
+		}
+	}
+	return entries, dropped, truncateAt, nil
+}
+
+// readWALBlock decodes the entries of the block (or jumbo sequence) starting at
+// blockIdx. On corruption it returns the entries in front of it with the error.
+func readWALBlock(reader *file_reader.FileReader, blockIdx int) ([]WALEntry, int, error) {
+	content, blocksUsed, err := reader.ReadEntry(blockIdx)
+	if err != nil {
+		return nil, blocksUsed, err
+	}
+	if len(content) == 0 {
+		return nil, blocksUsed, errors.New("empty WAL block")
+	}
+	var entries []WALEntry
+	for pos := 0; pos < len(content); {
+		decoded, size, err := decodeWALEntry(content[pos:])
+		if err != nil {
+			return entries, blocksUsed, err
+		}
+		entries = append(entries, decoded...)
+		pos += size
+	}
+	return entries, blocksUsed, nil
+}
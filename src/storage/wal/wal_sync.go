@@ -0,0 +1,158 @@
+package wal
+
+import (
+	"fmt"
+	"time"
+)
+
+// Durability modes, selected by WAL_SYNC_MODE
+const (
+	// SyncEveryWrite commits right away, a write returns once it is fsynced.
+	// Writers that arrive while a commit is running share the next fsync.
+	SyncEveryWrite = "sync"
+	// SyncGroup holds a commit until WAL_BUFFER_SIZE entries are buffered or the
+	// oldest one waited WAL_GROUP_COMMIT_LATENCY_MS, a write returns once fsynced
+	SyncGroup = "group"
+	// SyncPeriodic returns right away and commits every WAL_SYNC_INTERVAL_MS,
+	// a crash loses at most the last interval of writes
+	SyncPeriodic = "periodic"
+)
+
+// Append numbers entry, buffers it and returns its sequence number, which is
+// also the ticket for WaitDurable. Entries are committed in the order they were
+// appended. The operations of a batch entry, which must not be empty, get one
+// number each and the last one is returned.
+func (w *WAL) Append(entry WALEntry) uint64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	entry.Seq = w.appended + 1
+	if entry.Operation == "BATCH" {
+		ops := make([]WALEntry, len(entry.Batch))
+		for i, op := range entry.Batch {
+			op.Seq = entry.Seq + uint64(i)
+			ops[i] = op
+		}
+		entry.Batch = ops
+	}
+	w.appended = entry.lastSeq()
+	w.buffer = append(w.buffer, entry)
+	if len(w.buffer) == 1 {
+		w.firstPending = time.Now()
+		if w.cfg.WALSyncMode == SyncGroup {
+			time.AfterFunc(w.groupLatency(), w.wake)
+		}
+	}
+	if len(w.buffer) >= w.bufferSize {
+		w.committed.Broadcast() // the group is full
+	}
+	return w.appended
+}
+
+// LastSeq returns the sequence number of the newest appended entry
+func (w *WAL) LastSeq() uint64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.appended
+}
+
+// WaitDurable blocks until the entry with the given ticket meets the
+// configured durability, or fails once its commit failed. In periodic mode it
+// returns right away.
+func (w *WAL) WaitDurable(ticket uint64) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.cfg.WALSyncMode == SyncPeriodic {
+		return w.err
+	}
+	for w.durable < ticket {
+		if w.err != nil {
+			return w.err
+		}
+		if w.committing || (w.cfg.WALSyncMode == SyncGroup && !w.groupReady()) {
+			w.committed.Wait()
+			continue
+		}
+		w.commitLocked()
+	}
+	// committed before a later commit failed
+	return nil
+}
+
+// Flush commits every appended entry, whatever the durability mode
+func (w *WAL) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	target := w.appended
+	for w.durable < target && w.err == nil {
+		if w.committing {
+			w.committed.Wait()
+			continue
+		}
+		w.commitLocked()
+	}
+	return w.err
+}
+
+// Close stops the periodic syncer and commits what is left in the buffer
+func (w *WAL) Close() error {
+	select {
+	case <-w.stopped:
+	default:
+		close(w.stop)
+		<-w.stopped
+	}
+	return w.Flush()
+}
+
+// commitLocked writes and fsyncs the buffered entries. The caller holds lock,
+// which is released during the I/O so that writers can keep appending.
+func (w *WAL) commitLocked() {
+	batch, target := w.buffer, w.appended
+	w.buffer = make([]WALEntry, 0, w.bufferSize)
+	w.committing = true
+	w.lock.Unlock()
+	err := w.writeBatch(batch)
+	w.lock.Lock()
+	w.committing = false
+	if err != nil {
+		w.err = fmt.Errorf("failed to commit WAL entries: %w", err)
+	} else {
+		w.durable = target
+	}
+	w.committed.Broadcast()
+}
+
+// groupReady reports whether the buffered group may be committed. The caller
+// holds lock.
+func (w *WAL) groupReady() bool {
+	return len(w.buffer) >= w.bufferSize || time.Since(w.firstPending) >= w.groupLatency()
+}
+
+func (w *WAL) groupLatency() time.Duration {
+	return time.Duration(w.cfg.WALGroupCommitLatency) * time.Millisecond
+}
+
+// wake lets waiting writers re-check whether their group is ready
+func (w *WAL) wake() {
+	w.lock.Lock()
+	w.committed.Broadcast()
+	w.lock.Unlock()
+}
+
+func (w *WAL) runPeriodicSync() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(time.Duration(w.cfg.WALSyncInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.lock.Lock()
+			if len(w.buffer) > 0 && !w.committing && w.err == nil {
+				w.commitLocked()
+			}
+			w.lock.Unlock()
+		}
+	}
+}
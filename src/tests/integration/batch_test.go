@@ -0,0 +1,123 @@
+package integration
+
+import (
+	"fmt"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/engine"
+	b "nosqlEngine/src/service/block_manager"
+	wal "nosqlEngine/src/storage/wal"
+	"os"
+	"testing"
+)
+
+func TestBatchIsAppliedAndRecovered(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	eng.Write("user", "gone", "value", false)
+
+	batch := engine.NewBatch()
+	for i := 0; i < 5; i++ {
+		batch.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	batch.Delete("gone")
+	batch.Put("key0", "last") // later operations on a key win
+	if err := eng.Apply("user", batch); err != nil {
+		t.Fatalf("Failed to apply batch: %v", err)
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	if _, err := eng.Start(); err != nil {
+		t.Fatalf("Failed to start engine: %v", err)
+	}
+	for key, want := range map[string]string{"key0": "last", "key1": "value1", "key4": "value4"} {
+		if value, found, err := eng.Read("user", key); !found || value != want {
+			t.Errorf("%s: got %q found=%v err=%v", key, value, found, err)
+		}
+	}
+	if _, found, _ := eng.Read("user", "gone"); found {
+		t.Errorf("Expected the key deleted in the batch to be gone")
+	}
+	eng.Shut()
+}
+
+// writeBatchSegment commits two single entries and then one batch, each in its own block
+func writeBatchSegment(t *testing.T, cfg config.Config, bm *b.BlockManager) string {
+	log, err := wal.NewWAL(bm, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	log.WritePut("before1", "value")
+	log.WritePut("before2", "value")
+	ops := []wal.WALEntry{}
+	for i := 0; i < 5; i++ {
+		ops = append(ops, wal.NewPutEntry(fmt.Sprintf("batch%d", i), "value"))
+	}
+	ops = append(ops, wal.NewDeleteEntry("before1"))
+	if err := log.WaitDurable(log.Append(wal.NewBatchEntry(ops))); err != nil {
+		t.Fatalf("Failed to write batch to WAL: %v", err)
+	}
+	log.Close()
+	segments, _ := wal.GetWALSegmentPaths(cfg.WALDir())
+	return segments[0]
+}
+
+func TestWALBatchIsReplayedWhole(t *testing.T) {
+	cfg := walRecoveryConfig(t, wal.RecoverStrict)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	writeBatchSegment(t, cfg, bm)
+
+	entries, report, err := wal.ReplayWAL(bm, cfg)
+	if err != nil {
+		t.Fatalf("Failed to replay WAL: %v", err)
+	}
+	if len(entries) != 8 || report.LastSeq != 8 {
+		t.Fatalf("Expected 8 entries up to seq 8, got %d up to %d", len(entries), report.LastSeq)
+	}
+	for i, entry := range entries {
+		if entry.Seq != uint64(i+1) {
+			t.Errorf("Entry %d (%s) has seq %d", i, entry.Key, entry.Seq)
+		}
+	}
+	if entries[7].Operation != "DELETE" || entries[7].Key != "before1" {
+		t.Errorf("Expected the batch delete last, got %+v", entries[7])
+	}
+}
+
+func TestWALTornBatchIsDroppedWhole(t *testing.T) {
+	for _, mode := range []string{wal.RecoverTruncate, wal.RecoverSkip} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := walRecoveryConfig(t, mode)
+			bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+			segment := writeBatchSegment(t, cfg, bm)
+			if mode == wal.RecoverTruncate {
+				// a crash halfway through writing the batch
+				os.Truncate(segment, int64(2*cfg.BlockSize+100))
+			} else {
+				// flip a byte inside the operations of the batch
+				data, _ := os.ReadFile(segment)
+				data[2*cfg.BlockSize+100] ^= 0xFF
+				os.WriteFile(segment, data, 0644)
+			}
+
+			entries, report, err := wal.ReplayWAL(bm, cfg)
+			if err != nil {
+				t.Fatalf("Failed to replay WAL: %v", err)
+			}
+			if len(entries) != 2 || entries[0].Key != "before1" || entries[1].Key != "before2" {
+				t.Errorf("Expected only the entries before the batch, got %+v", entries)
+			}
+			if report.DroppedCount() != 1 {
+				t.Errorf("Expected the batch to be dropped as one record: %+v", report)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/engine"
+	"nosqlEngine/src/models/comparator"
+	"strings"
+	"testing"
+)
+
+// reverseComparator orders keys from the largest to the smallest
+const reverseComparator = "test-reverse"
+
+func init() {
+	comparator.Register(reverseComparator, func(a, b []byte) int {
+		return bytes.Compare(b, a)
+	})
+}
+
+func TestBinaryKeysAndValues(t *testing.T) {
+	for _, memtableType := range []string{"hashmap", "skiplist", "btree"} {
+		t.Run(memtableType, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.MemtableType = memtableType
+			eng, err := engine.NewEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			want := map[string][]byte{}
+			for i := uint64(0); i < 20; i++ {
+				// composite keys of a zero separated tenant and a big endian id
+				key := binary.BigEndian.AppendUint64([]byte("t\x00"), i<<56|i)
+				value := []byte{0x00, byte(i), 0xFF, '<', '!', '>', 0x00}
+				if err := eng.WriteBytes("user", key, value); err != nil {
+					t.Fatalf("Failed to write %q: %v", key, err)
+				}
+				want[string(key)] = value
+			}
+			deleted := binary.BigEndian.AppendUint64([]byte("t\x00"), 3<<56|3)
+			eng.DeleteBytes("user", deleted)
+			delete(want, string(deleted))
+			if err := eng.Shut(); err != nil {
+				t.Fatalf("Failed to shut engine: %v", err)
+			}
+
+			eng, err = engine.NewEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to reopen engine: %v", err)
+			}
+			if _, err := eng.Start(); err != nil {
+				t.Fatalf("Failed to start engine: %v", err)
+			}
+			defer eng.Shut()
+			for key, value := range want {
+				if got, found, err := eng.ReadBytes("user", []byte(key)); !found || !bytes.Equal(got, value) {
+					t.Errorf("%q: got %q found=%v err=%v", key, got, found, err)
+				}
+			}
+			if got, found, _ := eng.ReadBytes("user", deleted); found {
+				t.Errorf("Expected %q to be deleted, got %q", deleted, got)
+			}
+
+			// the scans take and return bytes as well, in big endian id order
+			page := eng.PrefixScanBytes("user", []byte("t\x00"), 1, 100)
+			if len(page) != len(want) {
+				t.Fatalf("Expected %d entries under the tenant, got %d", len(want), len(page))
+			}
+			for i, entry := range page {
+				if !bytes.Equal(want[string(entry[0])], entry[1]) || (i > 0 && bytes.Compare(page[i-1][0], entry[0]) >= 0) {
+					t.Errorf("Unexpected entry %d: %q=%q", i, entry[0], entry[1])
+				}
+			}
+			if ranged := eng.RangeScanBytes("user", page[0][0], page[4][0], 1, 100); len(ranged) != 5 {
+				t.Errorf("Expected 5 entries in the range, got %d", len(ranged))
+			}
+			it, err := eng.RangeIterateBytes("user", page[2][0], page[len(page)-1][0])
+			if err != nil {
+				t.Fatalf("Failed to iterate: %v", err)
+			}
+			defer it.Stop()
+			it.SeekForPrevBytes(page[5][0])
+			if key, value, _ := it.NextBytes(); !bytes.Equal(key, page[5][0]) || !bytes.Equal(value, page[5][1]) {
+				t.Errorf("Expected %q after SeekForPrevBytes, got %q=%q", page[5][0], key, value)
+			}
+			if key, _, _ := it.PrevBytes(); !bytes.Equal(key, page[5][0]) {
+				t.Errorf("Expected PrevBytes to return %q again, got %q", page[5][0], key)
+			}
+		})
+	}
+}
+
+func TestKeyComparatorOrdersKeys(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableType = "skiplist"
+	cfg.MemtableSize = 1000
+	cfg.KeyComparator = reverseComparator
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		eng.Write("user", fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i), false)
+	}
+	// in reverse order the range runs from the larger key down to the smaller
+	page := eng.RangeScan("user", "key7", "key2", 1, 10)
+	keys := []string{}
+	for _, record := range page {
+		keys = append(keys, record[0])
+	}
+	if got := strings.Join(keys, ","); got != "key7,key6,key5,key4,key3,key2" {
+		t.Errorf("Expected the range in reverse order, got %s", got)
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	// the flushed SSTable is sorted in reverse, point reads have to search it so
+	cfg.MemtableSize = 20
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	if _, err := eng.Start(); err != nil {
+		t.Fatalf("Failed to start engine: %v", err)
+	}
+	for i := 0; i < 30; i++ {
+		eng.Write("user", fmt.Sprintf("more%02d", i), "value", false)
+	}
+	for _, key := range []string{"key0", "key5", "key9", "more00", "more17"} {
+		if _, found, err := eng.Read("user", key); !found {
+			t.Errorf("Expected %s to be found: %v", key, err)
+		}
+	}
+	eng.Shut()
+}
+
+func TestKeyComparatorMustMatchDataDir(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	eng.Shut()
+
+	cfg.KeyComparator = reverseComparator
+	if _, err := engine.NewEngine(cfg); err == nil {
+		t.Errorf("Expected a data directory created bytewise to refuse another comparator")
+	}
+	cfg.KeyComparator = "unknown"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected an unregistered comparator to be rejected")
+	}
+	if err := config.DefaultConfig().Validate(); err != nil {
+		t.Errorf("Expected the default comparator to be valid: %v", err)
+	}
+}
+
+func TestEmptyKeySurvivesCompaction(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableSize = 1
+	cfg.CompactionThreshold = 2
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	// every write is its own table, the empty key ends up in several of them
+	for i := 0; i < 4; i++ {
+		if err := eng.WriteBytes("user", []byte{}, []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatalf("Failed to write the empty key: %v", err)
+		}
+		eng.WriteBytes("user", []byte{byte('a' + i)}, []byte("other"))
+	}
+	if err := eng.CompactRange("", "z"); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if got, found, err := eng.ReadBytes("user", []byte{}); !found || string(got) != "v3" {
+		t.Errorf("Expected the newest value of the empty key, got %q found=%v err=%v", got, found, err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, found, _ := eng.ReadBytes("user", []byte{byte('a' + i)}); !found {
+			t.Errorf("Expected %q to survive compaction", byte('a'+i))
+		}
+	}
+	eng.Shut()
+}
@@ -0,0 +1,363 @@
+package integration
+
+import (
+	"fmt"
+	"math/rand"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/engine"
+	"nosqlEngine/src/models/key_value"
+	b "nosqlEngine/src/service/block_manager"
+	fw "nosqlEngine/src/service/file_writer"
+	"nosqlEngine/src/service/io_limiter"
+	"nosqlEngine/src/service/ss_compacter"
+	"nosqlEngine/src/service/table_pins"
+	"nosqlEngine/src/storage/manifest"
+	"nosqlEngine/src/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func leveledConfig(t *testing.T) config.Config {
+	cfg := testConfig(t)
+	cfg.CompactionStrategy = "leveled"
+	cfg.LSMLevels = 3
+	cfg.CompactionThreshold = 4
+	cfg.LevelBaseSize = 3000
+	cfg.LevelSizeMultiplier = 2
+	cfg.SSTableTargetSize = 400
+	return cfg
+}
+
+// checkLevelsApart fails when two tables of a level below lvl0 share keys,
+// or a level above the last holds more than its target, as mf records them
+func checkLevelsApart(t *testing.T, cfg config.Config, mf *manifest.Manifest) {
+	t.Helper()
+	target := int64(cfg.LevelBaseSize)
+	for level := 1; level <= cfg.LSMLevels; level++ {
+		type keyRange struct{ first, last string }
+		ranges := []keyRange{}
+		size := int64(0)
+		for _, table := range mf.Level(level) {
+			ranges = append(ranges, keyRange{table.FirstKey, table.LastKey})
+			size += table.Size
+		}
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].first < ranges[j].first })
+		for i := 1; i < len(ranges); i++ {
+			if ranges[i].first <= ranges[i-1].last {
+				t.Errorf("lvl%d tables overlap: %v and %v", level, ranges[i-1], ranges[i])
+			}
+		}
+		if level < cfg.LSMLevels && size > target {
+			t.Errorf("lvl%d holds %d bytes, more than its target of %d", level, size, target)
+		}
+		target *= int64(cfg.LevelSizeMultiplier)
+	}
+}
+
+func TestLeveledCompactionKeepsLevelsApart(t *testing.T) {
+	cfg := leveledConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	rng := rand.New(rand.NewSource(21))
+	model := map[string]string{}
+	for i := 0; i < 600; i++ {
+		key := fmt.Sprintf("key%03d", rng.Intn(150))
+		if rng.Intn(5) == 0 {
+			eng.Delete("user", key)
+			delete(model, key)
+			continue
+		}
+		value := fmt.Sprintf("v%d", i)
+		eng.Write("user", key, value, false)
+		model[key] = value
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	checkLevelsApart(t, cfg, openTestManifest(t, cfg, bm))
+	if tables := utils.GetPaths(cfg.LevelDir(cfg.LSMLevels), ".db"); len(tables) == 0 {
+		t.Errorf("Expected tables to reach lvl%d", cfg.LSMLevels)
+	}
+
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer eng.Shut()
+	if _, err := eng.Start(); err != nil {
+		t.Fatalf("Failed to replay WAL: %v", err)
+	}
+	expected := expectedScan(model, "key", "key999")
+	if got := eng.RangeScan("user", "key", "key999", 1, 1000); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected compaction to keep the newest version of every key\n got %v\nwant %v", got, expected)
+	}
+}
+
+func TestLeveledCompactionOnlyRewritesOverlappingTables(t *testing.T) {
+	cfg := leveledConfig(t)
+	cfg.CompactionThreshold = 2
+	cfg.LevelBaseSize = 1 << 20 // keep everything in lvl1
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	flushTestTable(t, cfg, bm, "a%02d", 10)
+	flushTestTable(t, cfg, bm, "z%02d", 10)
+	mf := openTestManifest(t, cfg, bm)
+	compacter := ss_compacter.NewCompacter(cfg, &sync.RWMutex{}, table_pins.NewTablePins(), mf)
+	if !compacter.CheckCompactionConditions(bm) {
+		t.Fatalf("Expected lvl0 to be compacted")
+	}
+	before := map[string]bool{}
+	for _, table := range mf.Level(1) {
+		before[table.Path] = true
+	}
+
+	for _, table := range []string{flushTestTable(t, cfg, bm, "a%02d", 5), flushTestTable(t, cfg, bm, "b%02d", 5)} {
+		meta, err := mf.Describe(table, 0)
+		if err != nil {
+			t.Fatalf("Failed to describe %s: %v", table, err)
+		}
+		if err := mf.Apply(manifest.VersionEdit{Added: []manifest.TableMeta{meta}}); err != nil {
+			t.Fatalf("Failed to record %s: %v", table, err)
+		}
+	}
+	if !compacter.CheckCompactionConditions(bm) {
+		t.Fatalf("Expected lvl0 to be compacted")
+	}
+	kept := 0
+	for _, table := range mf.Level(1) {
+		if !before[table.Path] {
+			continue
+		}
+		kept++
+		if table.FirstKey < "z" {
+			t.Errorf("Expected the tables holding a keys to be rewritten, %s starts at %s", table.Path, table.FirstKey)
+		}
+	}
+	if kept == 0 {
+		t.Errorf("Expected the tables holding only z keys to stay untouched")
+	}
+	if tables := utils.GetPaths(cfg.LevelDir(0), ".db"); len(tables) != 0 {
+		t.Errorf("Expected lvl0 to be empty, got %v", tables)
+	}
+	checkLevelsApart(t, cfg, mf)
+}
+
+func TestCompactRangeMovesTablesToLastLevel(t *testing.T) {
+	for _, strategy := range []string{"size_tiered", "leveled"} {
+		t.Run(strategy, func(t *testing.T) {
+			cfg := leveledConfig(t)
+			cfg.CompactionStrategy = strategy
+			cfg.CompactionThreshold = 100 // nothing is compacted on its own
+			eng, err := engine.NewEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer eng.Shut()
+			model := map[string]string{}
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("key%02d", i%70)
+				value := fmt.Sprintf("v%d", i)
+				eng.Write("user", key, value, false)
+				model[key] = value
+			}
+
+			if err := eng.CompactRange("key50", "key40"); err == nil {
+				t.Errorf("Expected an error for a range ending before it starts")
+			}
+			if err := eng.CompactRange("key00", "key99"); err != nil {
+				t.Fatalf("Failed to compact range: %v", err)
+			}
+			for level := 0; level < cfg.LSMLevels; level++ {
+				if tables := utils.GetPaths(cfg.LevelDir(level), ".db"); len(tables) != 0 {
+					t.Errorf("Expected lvl%d to be empty, got %v", level, tables)
+				}
+			}
+			if tables := utils.GetPaths(cfg.LevelDir(cfg.LSMLevels), ".db"); len(tables) == 0 {
+				t.Errorf("Expected the tables to reach lvl%d", cfg.LSMLevels)
+			}
+			expected := expectedScan(model, "key", "key999")
+			if got := eng.RangeScan("user", "key", "key999", 1, 1000); fmt.Sprint(got) != fmt.Sprint(expected) {
+				t.Errorf("Expected compaction to keep the newest version of every key\n got %v\nwant %v", got, expected)
+			}
+		})
+	}
+}
+
+func TestShutWaitsForThrottledCompactions(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CompactionWorkers = 3
+	cfg.CompactionRateLimit = 20000
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	for i := 0; i < 150; i++ {
+		eng.Write("user", fmt.Sprintf("key%03d", i), fmt.Sprintf("value%d", i), false)
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	// nothing that was due is left behind, and nothing half written
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	mf := openTestManifest(t, cfg, bm)
+	for level := 0; level < cfg.LSMLevels; level++ {
+		if tables := mf.Level(level); len(tables) >= cfg.CompactionThreshold {
+			t.Errorf("Expected lvl%d to be compacted before shutting down, got %d tables", level, len(tables))
+		}
+	}
+	for level := 0; level <= cfg.LSMLevels; level++ {
+		if staged := utils.GetPaths(cfg.LevelDir(level), fw.TmpSuffix); len(staged) != 0 {
+			t.Errorf("Expected no staged tables in lvl%d, got %v", level, staged)
+		}
+	}
+	mf.Close()
+
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer eng.Shut()
+	if _, err := eng.Start(); err != nil {
+		t.Fatalf("Failed to replay WAL: %v", err)
+	}
+	for i := 0; i < 150; i++ {
+		if value, found, err := eng.Read("user", fmt.Sprintf("key%03d", i)); !found || value != fmt.Sprintf("value%d", i) {
+			t.Errorf("key%03d: got %q found=%v err=%v", i, value, found, err)
+		}
+	}
+}
+
+func TestIOLimiterPacesBytes(t *testing.T) {
+	limiter := io_limiter.NewIOLimiter(1000)
+	begin := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2; j++ {
+				limiter.Wait(50)
+			}
+		}()
+	}
+	wg.Wait()
+	// 400 bytes at 1000 bytes per second, the first 50 go through at once
+	if elapsed := time.Since(begin); elapsed < 300*time.Millisecond {
+		t.Errorf("Expected the limiter to spread 400 bytes over about 350ms, took %v", elapsed)
+	}
+	unlimited := io_limiter.NewIOLimiter(0)
+	begin = time.Now()
+	unlimited.Wait(1 << 30)
+	if elapsed := time.Since(begin); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected no limit to never wait, took %v", elapsed)
+	}
+}
+
+func TestBottomCompactionDropsTombstones(t *testing.T) {
+	for _, strategy := range []string{"size_tiered", "leveled"} {
+		t.Run(strategy, func(t *testing.T) {
+			cfg := leveledConfig(t)
+			cfg.CompactionStrategy = strategy
+			cfg.CompactionThreshold = 100 // nothing is compacted on its own
+			eng, err := engine.NewEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer eng.Shut()
+			for i := 0; i < 60; i++ {
+				eng.Write("user", fmt.Sprintf("key%02d", i), "old", false)
+			}
+			snap := eng.Snapshot()
+			defer snap.Release()
+			for i := 0; i < 30; i++ {
+				eng.Delete("user", fmt.Sprintf("key%02d", i))
+			}
+			// push the deletes out of the active memtable
+			for i := 0; i < 40; i++ {
+				eng.Write("user", fmt.Sprintf("pad%02d", i), "pad", false)
+			}
+
+			if err := eng.CompactRange("key00", "pad99"); err != nil {
+				t.Fatalf("Failed to compact range: %v", err)
+			}
+			stats := eng.CompactionStats()
+			if stats.TombstonesDropped != 30 {
+				t.Errorf("Expected the 30 tombstones to be dropped, got %+v", stats)
+			}
+			for i := 0; i < 60; i++ {
+				key := fmt.Sprintf("key%02d", i)
+				value, found, _ := eng.Read("user", key)
+				if i < 30 && found {
+					t.Errorf("%s: expected it to stay deleted, got %q", key, value)
+				}
+				if i >= 30 && (!found || value != "old") {
+					t.Errorf("%s: got %q found=%v", key, value, found)
+				}
+			}
+			// the snapshot reads the tables it pinned, the dropped keys are still there
+			opts := engine.ReadOptions{Snapshot: snap}
+			for i := 0; i < 30; i++ {
+				key := fmt.Sprintf("key%02d", i)
+				if value, found, err := eng.ReadWithOptions("user", key, opts); !found || value != "old" {
+					t.Errorf("snapshot %s: got %q found=%v err=%v", key, value, found, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTombstoneKeptWhileOlderVersionIsOutside(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CompactionThreshold = 3
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	// the old value of k sits in lvl1, the compaction of lvl0 does not read it
+	flushEntries(cfg, bm, fw.TableName(1, 1), key_value.NewKeyValue("k", "old").WithSeq(1))
+	flushEntries(cfg, bm, fw.TableName(0, 2), key_value.NewKeyValue("y", "old").WithSeq(2))
+	flushEntries(cfg, bm, fw.TableName(0, 3), key_value.NewTombstone("k").WithSeq(3))
+	flushEntries(cfg, bm, fw.TableName(0, 4), key_value.NewTombstone("y").WithSeq(4))
+
+	mf := openTestManifest(t, cfg, bm)
+	if !ss_compacter.NewSSCompacterST(cfg, &sync.RWMutex{}, table_pins.NewTablePins(), mf).CheckCompactionConditions(bm) {
+		t.Fatalf("Compaction conditions not met")
+	}
+	if entry, found, _ := lookupKey(cfg, bm, mf, "k"); !found || !entry.IsTombstone() {
+		t.Errorf("Expected the tombstone of k to keep hiding the old value, got %q tombstone=%v", entry.GetValue(), entry.IsTombstone())
+	}
+	if entry, found, _ := lookupKey(cfg, bm, mf, "y"); found {
+		t.Errorf("Expected y to be dropped along with its tombstone, got %q tombstone=%v", entry.GetValue(), entry.IsTombstone())
+	}
+}
+
+func TestTombstoneKeptWhenOutsideTableIsUnreadable(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CompactionThreshold = 3
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	flushEntries(cfg, bm, fw.TableName(1, 1), key_value.NewKeyValue("k", "old").WithSeq(1))
+	flushEntries(cfg, bm, fw.TableName(0, 2), key_value.NewKeyValue("y", "old").WithSeq(2))
+	flushEntries(cfg, bm, fw.TableName(0, 3), key_value.NewTombstone("k").WithSeq(3))
+	flushEntries(cfg, bm, fw.TableName(0, 4), key_value.NewTombstone("y").WithSeq(4))
+	mf := openTestManifest(t, cfg, bm)
+
+	// the table holding the old value of k can no longer be read
+	if err := os.Truncate(filepath.Join(cfg.SSTableDir(), fw.TableName(1, 1)), 0); err != nil {
+		t.Fatalf("Failed to truncate lvl1 table: %v", err)
+	}
+	bm = b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	if ss_compacter.NewSSCompacterST(cfg, &sync.RWMutex{}, table_pins.NewTablePins(), mf).CheckCompactionConditions(bm) {
+		t.Errorf("Expected the compaction to fail on the unreadable table")
+	}
+	if tables := mf.Level(0); len(tables) != 3 {
+		t.Errorf("Expected the lvl0 tables to stay, got %d", len(tables))
+	}
+	if entry, found, err := lookupKey(cfg, bm, mf, "k"); !found || !entry.IsTombstone() {
+		t.Errorf("Expected the tombstone of k to be kept, got %q found=%v err=%v", entry.GetValue(), found, err)
+	}
+}
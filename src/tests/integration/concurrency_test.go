@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"fmt"
+	"nosqlEngine/src/engine"
+	"sync"
+	"testing"
+)
+
+// Run with `go test -race ./src/tests/integration/` to check the engine's
+// concurrency model, the tests also check results without the detector.
+
+func TestConcurrentReadersAndWriters(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableCount = 2
+	cfg.MaxTokens = 1000000
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	const writers, keysPerWriter, readers = 4, 25, 4
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				if err := eng.Write("user", fmt.Sprintf("w%d-key%02d", w, i), fmt.Sprintf("value%d-%d", w, i), false); err != nil {
+					t.Errorf("Failed to write: %v", err)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				// a key may not be written yet, but a value that is found must be the right one
+				value, found, _ := eng.Read("user", fmt.Sprintf("w%d-key%02d", r%writers, i))
+				if found && value != fmt.Sprintf("value%d-%d", r%writers, i) {
+					t.Errorf("w%d-key%02d: got %q", r%writers, i, value)
+				}
+			}
+			eng.RangeScan("user", "w0", "w9", 1, 10)
+		}(r)
+	}
+	wg.Wait()
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < keysPerWriter; i++ {
+			key := fmt.Sprintf("w%d-key%02d", w, i)
+			value, found, err := eng.Read("user", key)
+			if err != nil || !found || value != fmt.Sprintf("value%d-%d", w, i) {
+				t.Errorf("%s: got %q found=%v err=%v", key, value, found, err)
+			}
+		}
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package integration
+
+import (
+	"fmt"
+	"nosqlEngine/src/engine"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	expect := func(name string, ok bool, err error, want bool) {
+		t.Helper()
+		if err != nil || ok != want {
+			t.Errorf("%s: got %v err=%v, want %v", name, ok, err, want)
+		}
+	}
+
+	ok, err := eng.PutIfAbsent("user", "lease", "owner1")
+	expect("PutIfAbsent on a new key", ok, err, true)
+	ok, err = eng.PutIfAbsent("user", "lease", "owner2")
+	expect("PutIfAbsent on an existing key", ok, err, false)
+	ok, err = eng.CompareAndSwap("user", "lease", "owner2", "owner3")
+	expect("CompareAndSwap with a stale value", ok, err, false)
+	ok, err = eng.CompareAndSwap("user", "missing", "", "value")
+	expect("CompareAndSwap on a missing key", ok, err, false)
+
+	// push the key out of the memtables, the check must find it in an SSTable
+	for i := 0; i < cfg.MemtableSize*2; i++ {
+		eng.Write("user", fmt.Sprintf("filler%03d", i), "value", false)
+	}
+	ok, err = eng.CompareAndSwap("user", "lease", "owner1", "owner2")
+	expect("CompareAndSwap with the current value", ok, err, true)
+	ok, err = eng.DeleteIfEquals("user", "lease", "owner1")
+	expect("DeleteIfEquals with a stale value", ok, err, false)
+	ok, err = eng.DeleteIfEquals("user", "lease", "owner2")
+	expect("DeleteIfEquals with the current value", ok, err, true)
+	ok, err = eng.PutIfAbsent("user", "lease", "owner4")
+	expect("PutIfAbsent on a deleted key", ok, err, true)
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	// conditional writes are logged like any other
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	if _, err := eng.Start(); err != nil {
+		t.Fatalf("Failed to start engine: %v", err)
+	}
+	if value, found, err := eng.Read("user", "lease"); !found || value != "owner4" {
+		t.Errorf("Expected lease=owner4 after recovery, got %q found=%v err=%v", value, found, err)
+	}
+	eng.Shut()
+}
+
+func TestPutIfAbsentHasOneWinner(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MaxTokens = 1000000
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	const rounds, workers = 10, 8
+	for round := 0; round < rounds; round++ {
+		key := fmt.Sprintf("idempotency%d", round)
+		var wins atomic.Int32
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				ok, err := eng.PutIfAbsent("user", key, fmt.Sprintf("worker%d", w))
+				if err != nil {
+					t.Errorf("PutIfAbsent failed: %v", err)
+				}
+				if ok {
+					wins.Add(1)
+				}
+			}(w)
+		}
+		wg.Wait()
+		if wins.Load() != 1 {
+			t.Errorf("%s: expected exactly one winner, got %d", key, wins.Load())
+		}
+	}
+	eng.Shut()
+}
+
+// engineWithUnreadableTables flushes key to an SSTable and reopens the engine
+// after every SSTable was truncated, so any lookup reaching them fails
+func engineWithUnreadableTables(t *testing.T, key string) *engine.Engine {
+	cfg := testConfig(t)
+	cfg.MemtableSize = 2
+	cfg.CompactionThreshold = 100 // keep the flushed tables as they are
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	eng.Write("user", key, "value", false)
+	for i := 0; i < 4; i++ {
+		eng.Write("user", fmt.Sprintf("filler%d", i), "value", false)
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+	tables, _ := filepath.Glob(filepath.Join(cfg.LevelDir(0), "*.db"))
+	if len(tables) == 0 {
+		t.Fatalf("Expected %s to be flushed to an SSTable", key)
+	}
+	for _, table := range tables {
+		if err := os.Truncate(table, 0); err != nil {
+			t.Fatalf("Failed to truncate %s: %v", table, err)
+		}
+	}
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	t.Cleanup(func() { eng.Shut() })
+	return eng
+}
+
+func TestConditionalWritesRefuseUnreadableTables(t *testing.T) {
+	eng := engineWithUnreadableTables(t, "lease")
+	if ok, err := eng.PutIfAbsent("user", "lease", "owner"); ok || err == nil {
+		t.Errorf("Expected PutIfAbsent to fail on an unreadable table, got %v err=%v", ok, err)
+	}
+	if ok, err := eng.CompareAndSwap("user", "lease", "value", "owner"); ok || err == nil {
+		t.Errorf("Expected CompareAndSwap to fail on an unreadable table, got %v err=%v", ok, err)
+	}
+	if ok, err := eng.DeleteIfEquals("user", "lease", "value"); ok || err == nil {
+		t.Errorf("Expected DeleteIfEquals to fail on an unreadable table, got %v err=%v", ok, err)
+	}
+	if _, found, err := eng.Read("user", "lease"); found || err == nil {
+		t.Errorf("Expected the read to fail rather than miss, got found=%v err=%v", found, err)
+	}
+}
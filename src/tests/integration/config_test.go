@@ -0,0 +1,80 @@
+package integration
+
+import (
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/engine"
+	"nosqlEngine/src/models/comparator"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"MEMTABLE_SIZE": 500, "MEMTABLE_TYPE": "skiplist"}`), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("NOSQL_BLOCK_SIZE", "128")
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.MemtableSize != 500 || cfg.MemtableType != "skiplist" {
+		t.Errorf("File values not applied: MEMTABLE_SIZE=%d MEMTABLE_TYPE=%s", cfg.MemtableSize, cfg.MemtableType)
+	}
+	if cfg.BlockSize != 128 {
+		t.Errorf("Environment override not applied: BLOCK_SIZE=%d", cfg.BlockSize)
+	}
+	if cfg.LSMLevels != config.DefaultConfig().LSMLevels {
+		t.Errorf("Unset values should keep their defaults, got LSM_LEVELS=%d", cfg.LSMLevels)
+	}
+}
+
+func TestInvalidConfigRejected(t *testing.T) {
+	t.Setenv("NOSQL_MEMTABLE_TYPE", "linkedlist")
+	if _, err := config.LoadConfig(""); err == nil || !strings.Contains(err.Error(), "MEMTABLE_TYPE") {
+		t.Errorf("Expected MEMTABLE_TYPE error, got %v", err)
+	}
+
+	t.Setenv("NOSQL_MEMTABLE_TYPE", "hashmap")
+	t.Setenv("NOSQL_BLOCK_SIZE", "large")
+	if _, err := config.LoadConfig(""); err == nil || !strings.Contains(err.Error(), "NOSQL_BLOCK_SIZE") {
+		t.Errorf("Expected NOSQL_BLOCK_SIZE error, got %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.BlockSize = 0
+	if _, err := engine.NewEngine(cfg); err == nil {
+		t.Errorf("Expected NewEngine to reject BLOCK_SIZE=0")
+	}
+}
+
+func TestEmptyKeyComparatorIsBytewise(t *testing.T) {
+	t.Setenv("NOSQL_KEY_COMPARATOR", "")
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.KeyComparator != comparator.Bytewise {
+		t.Errorf("Expected an empty KEY_COMPARATOR to read as %q, got %q", comparator.Bytewise, cfg.KeyComparator)
+	}
+
+	// a data directory made without naming the comparator opens with its name
+	cfg = testConfig(t)
+	cfg.KeyComparator = ""
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+	cfg.KeyComparator = comparator.Bytewise
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Expected the bytewise comparator to open the data directory: %v", err)
+	}
+	eng.Shut()
+}
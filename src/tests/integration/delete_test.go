@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"nosqlEngine/src/engine"
+	"nosqlEngine/src/models/key_value"
+	b "nosqlEngine/src/service/block_manager"
+	fw "nosqlEngine/src/service/file_writer"
+	"nosqlEngine/src/service/ss_parser"
+	m "nosqlEngine/src/storage/memtable"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDeleteHidesKey(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableSize = 1000 // keep everything in the memtable
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	// a value that used to be the tombstone marker is ordinary data now
+	if err := eng.Write("user", "marker", "<KURTCOBAIN!>", false); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := eng.Write("user", "gone", "value", false); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := eng.Delete("user", "gone"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	if value, found, _ := eng.Read("user", "marker"); !found || value != "<KURTCOBAIN!>" {
+		t.Errorf("Expected marker value to be readable, got %q found=%v", value, found)
+	}
+	if _, found, _ := eng.Read("user", "gone"); found {
+		t.Errorf("Expected deleted key to be hidden")
+	}
+}
+
+func TestTombstoneSurvivesFlush(t *testing.T) {
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	mt := m.NewMemtable(cfg)
+	mt.Add(key_value.NewKeyValue("alive", "value"))
+	mt.Add(key_value.NewTombstone("dead"))
+	fileWriter := fw.NewFileWriter(bm, cfg.BlockSize, cfg.SSTableDir(), "lvl0/sstable_"+uuid.New().String()+".db")
+	ss_parser.NewSSParser(fileWriter, cfg).FlushMemtable(mt.ToRaw())
+
+	mf := openTestManifest(t, cfg, bm)
+	entry, found, err := lookupKey(cfg, bm, mf, "dead")
+	if err != nil || !found {
+		t.Fatalf("Failed to retrieve tombstone: found=%v err=%v", found, err)
+	}
+	if !entry.IsTombstone() {
+		t.Errorf("Expected a tombstone for deleted key, got value %q", entry.GetValue())
+	}
+	entry, found, err = lookupKey(cfg, bm, mf, "alive")
+	if err != nil || !found || entry.IsTombstone() || entry.GetValue() != "value" {
+		t.Errorf("Expected live value, got %q tombstone=%v err=%v", entry.GetValue(), entry.IsTombstone(), err)
+	}
+}
@@ -0,0 +1,100 @@
+package integration
+
+import (
+	"fmt"
+	"nosqlEngine/src/engine"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFullMemtablesAreFlushed(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableCount = 2
+	cfg.CompactionThreshold = 100 // keep the flushed tables on lvl0
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	// every memtable fills up after a couple of writes
+	for i := 0; i < 50; i++ {
+		if err := eng.Write("user", fmt.Sprintf("key%02d", i), fmt.Sprintf("value%d", i), false); err != nil {
+			t.Fatalf("Failed to write key%02d: %v", i, err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		value, found, err := eng.Read("user", fmt.Sprintf("key%02d", i))
+		if err != nil || !found || value != fmt.Sprintf("value%d", i) {
+			t.Errorf("key%02d: got %q found=%v err=%v", i, value, found, err)
+		}
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+}
+
+func TestFailedFlushStopsWrites(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableSize = 2
+	cfg.MemtableCount = 1
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	// lvl0 turns into a file, so no SSTable can be written
+	if err := os.RemoveAll(cfg.LevelDir(0)); err != nil {
+		t.Fatalf("Failed to remove lvl0: %v", err)
+	}
+	if err := os.WriteFile(cfg.LevelDir(0), nil, 0644); err != nil {
+		t.Fatalf("Failed to block lvl0: %v", err)
+	}
+
+	done := make(chan []string)
+	go func() {
+		written := []string{}
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key%02d", i)
+			if err := eng.Write("user", key, "value", false); err != nil {
+				break
+			}
+			written = append(written, key)
+		}
+		done <- written
+	}()
+	var written []string
+	select {
+	case written = <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("Writers blocked for good after the flush failed")
+	}
+	if len(written) == 20 {
+		t.Fatalf("Expected writes to be refused once the flush failed")
+	}
+	for _, key := range written {
+		if _, found, _ := eng.Read("user", key); !found {
+			t.Errorf("Expected %s to stay readable from its memtable", key)
+		}
+	}
+	if err := eng.Shut(); err == nil {
+		t.Errorf("Expected Shut to report the failed flush")
+	}
+
+	// the writes acknowledged so far come back from the WAL
+	os.Remove(cfg.LevelDir(0))
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	if _, err := eng.Start(); err != nil {
+		t.Fatalf("Failed to start engine: %v", err)
+	}
+	for _, key := range written {
+		if _, found, _ := eng.Read("user", key); !found {
+			t.Errorf("Expected %s to be recovered", key)
+		}
+	}
+	if err := eng.Shut(); err != nil {
+		t.Errorf("Failed to shut engine: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package integration
+
+import (
+	"fmt"
+	"nosqlEngine/src/engine"
+	b "nosqlEngine/src/service/block_manager"
+	fw "nosqlEngine/src/service/file_writer"
+	"nosqlEngine/src/storage/manifest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestRecordsTablesAcrossRestarts(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		eng.Write("user", fmt.Sprintf("key%02d", i%60), fmt.Sprintf("value%d", i), false)
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	mf, err := manifest.Open(bm, cfg)
+	if err != nil {
+		t.Fatalf("Failed to open manifest: %v", err)
+	}
+	tables := mf.Tables()
+	mf.Close()
+	if len(tables) == 0 {
+		t.Fatalf("Expected the manifest to record the flushed tables")
+	}
+	for _, table := range tables {
+		described, err := mf.Describe(filepath.Join(cfg.SSTableDir(), table.Path), table.Level)
+		if err != nil {
+			t.Fatalf("Failed to describe %s: %v", table.Path, err)
+		}
+		if described != table {
+			t.Errorf("Expected the recorded metadata to match the table\n got %+v\nwant %+v", table, described)
+		}
+		if table.Entries == 0 || table.MinSeq == 0 || table.MinSeq > table.MaxSeq || table.FirstKey > table.LastKey {
+			t.Errorf("Unexpected metadata %+v", table)
+		}
+		if table.Path != fw.TableName(table.Level, table.Number) {
+			t.Errorf("Expected %s to be named after its file number %d", table.Path, table.Number)
+		}
+	}
+
+	// tables written after a restart are numbered after the earlier ones
+	newest := uint64(0)
+	for _, table := range tables {
+		newest = max(newest, table.Number)
+	}
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		eng.Write("user", fmt.Sprintf("key%02d", i), "again", false)
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+	mf = openTestManifest(t, cfg, bm)
+	added := 0
+	for _, table := range mf.Tables() {
+		if table.Number > newest {
+			added++
+		}
+	}
+	if added == 0 {
+		t.Errorf("Expected the tables flushed after the restart to have larger file numbers than %d", newest)
+	}
+}
+
+func TestManifestDeletesOrphanedTables(t *testing.T) {
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	live := flushTestTable(t, cfg, bm, "live%02d", 10)
+	mf := openTestManifest(t, cfg, bm)
+	mf.Close()
+
+	// a flush that was not recorded, and one that did not finish
+	orphan := flushTestTable(t, cfg, bm, "orphan%02d", 10)
+	staged := filepath.Join(cfg.LevelDir(0), "sstable_staged.db"+fw.TmpSuffix)
+	if err := os.WriteFile(staged, []byte("partial"), 0644); err != nil {
+		t.Fatalf("Failed to write staged table: %v", err)
+	}
+
+	mf = openTestManifest(t, cfg, bm)
+	if paths := mf.Paths(); len(paths) != 1 || paths[0] != live {
+		t.Errorf("Expected only %s to be live, got %v", live, paths)
+	}
+	for _, path := range []string{orphan, staged} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted, got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(live); err != nil {
+		t.Errorf("Expected %s to stay: %v", live, err)
+	}
+}
+
+func TestManifestIgnoresTornTail(t *testing.T) {
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	first := flushTestTable(t, cfg, bm, "a%02d", 10)
+	mf := openTestManifest(t, cfg, bm)
+	second := flushTestTable(t, cfg, bm, "b%02d", 10)
+	meta, err := mf.Describe(second, 0)
+	if err != nil {
+		t.Fatalf("Failed to describe %s: %v", second, err)
+	}
+	if err := mf.Apply(manifest.VersionEdit{Added: []manifest.TableMeta{meta}, Removed: []string{first}}); err != nil {
+		t.Fatalf("Failed to apply edit: %v", err)
+	}
+	mf.Close()
+
+	// cut the last edit short, as a crash during the append would
+	path := filepath.Join(cfg.SSTableDir(), manifest.FileName)
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat manifest: %v", err)
+	}
+	if err := os.Truncate(path, stat.Size()-3); err != nil {
+		t.Fatalf("Failed to truncate manifest: %v", err)
+	}
+
+	mf = openTestManifest(t, cfg, bm)
+	if paths := mf.Paths(); len(paths) != 1 || paths[0] != first {
+		t.Errorf("Expected the version before the torn edit, got %v", paths)
+	}
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Errorf("Expected the table of the torn edit to be deleted, got %v", err)
+	}
+}
+
+func TestManifestRefusesCorruptionBeforeTail(t *testing.T) {
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	first := flushTestTable(t, cfg, bm, "a%02d", 10)
+	mf := openTestManifest(t, cfg, bm)
+	second := flushTestTable(t, cfg, bm, "b%02d", 10)
+	meta, err := mf.Describe(second, 0)
+	if err != nil {
+		t.Fatalf("Failed to describe %s: %v", second, err)
+	}
+	if err := mf.Apply(manifest.VersionEdit{Added: []manifest.TableMeta{meta}}); err != nil {
+		t.Fatalf("Failed to apply edit: %v", err)
+	}
+	mf.Close()
+
+	// damage the first record, the edit after it is intact
+	path := filepath.Join(cfg.SSTableDir(), manifest.FileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	data[10] ^= 0xFF
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	if _, err := manifest.Open(bm, cfg); err == nil {
+		t.Fatalf("Expected a manifest corrupted before its last record to be refused")
+	}
+	for _, table := range []string{first, second} {
+		if _, err := os.Stat(table); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", table, err)
+		}
+	}
+}
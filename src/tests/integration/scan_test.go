@@ -0,0 +1,518 @@
+package integration
+
+import (
+	"fmt"
+	"math/rand"
+	"nosqlEngine/src/engine"
+	"nosqlEngine/src/models/key_value"
+	b "nosqlEngine/src/service/block_manager"
+	fw "nosqlEngine/src/service/file_writer"
+	"nosqlEngine/src/service/ss_parser"
+	m "nosqlEngine/src/storage/memtable"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// expectedScan lists the model entries with lo <= key <= hi in key order
+func expectedScan(model map[string]string, lo string, hi string) [][]string {
+	expected := [][]string{}
+	for key, value := range model {
+		if key >= lo && key <= hi {
+			expected = append(expected, []string{key, value})
+		}
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i][0] < expected[j][0] })
+	return expected
+}
+
+func TestScansMergeMemtablesAndSSTables(t *testing.T) {
+	for _, memtableType := range []string{"hashmap", "skiplist", "btree"} {
+		t.Run(memtableType, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.MemtableType = memtableType
+			eng, err := engine.NewEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer eng.Shut()
+
+			// overwrites and deletes spread versions of a key over memtables and SSTables
+			rng := rand.New(rand.NewSource(17))
+			model := map[string]string{}
+			for i := 0; i < 300; i++ {
+				key := fmt.Sprintf("k%02d:%02d", rng.Intn(4), rng.Intn(25))
+				if rng.Intn(4) == 0 {
+					eng.Delete("user", key)
+					delete(model, key)
+					continue
+				}
+				value := fmt.Sprintf("v%d", i)
+				eng.Write("user", key, value, false)
+				model[key] = value
+			}
+
+			for _, prefix := range []string{"k00:", "k01:1", "k03:", "k9"} {
+				expected := expectedScan(model, prefix, prefix+"\xff")
+				if got := eng.PrefixScan("user", prefix, 1, 1000); fmt.Sprint(got) != fmt.Sprint(expected) {
+					t.Errorf("PrefixScan(%s):\n got %v\nwant %v", prefix, got, expected)
+				}
+			}
+			expected := expectedScan(model, "k01:10", "k02:05")
+			if got := eng.RangeScan("user", "k01:10", "k02:05", 1, 1000); fmt.Sprint(got) != fmt.Sprint(expected) {
+				t.Errorf("RangeScan:\n got %v\nwant %v", got, expected)
+			}
+			if got := eng.RangeScan("user", "k01:10", "k02:05", 2, 3); len(expected) > 5 && fmt.Sprint(got) != fmt.Sprint(expected[3:6]) {
+				t.Errorf("Expected the second page to be %v, got %v", expected[3:6], got)
+			}
+
+			it, err := eng.RangeIterate("user", "k01:10", "k02:05")
+			if err != nil {
+				t.Fatalf("Failed to iterate: %v", err)
+			}
+			got := [][]string{}
+			for it.HasNext() {
+				key, value, _ := it.Next()
+				got = append(got, []string{key, value})
+			}
+			it.Stop()
+			if fmt.Sprint(got) != fmt.Sprint(expected) || it.Err() != nil {
+				t.Errorf("RangeIterate:\n got %v\nwant %v (err %v)", got, expected, it.Err())
+			}
+
+			reversed := make([][]string, 0, len(expected))
+			for i := len(expected) - 1; i >= 0; i-- {
+				reversed = append(reversed, expected[i])
+			}
+			reverse := engine.ReadOptions{Reverse: true}
+			if got := eng.RangeScanWithOptions("user", "k01:10", "k02:05", 1, 1000, reverse); fmt.Sprint(got) != fmt.Sprint(reversed) {
+				t.Errorf("Reverse RangeScan:\n got %v\nwant %v", got, reversed)
+			}
+			for _, prefix := range []string{"k01:", "k03:2"} {
+				expected := expectedScan(model, prefix, prefix+"\xff")
+				got := eng.PrefixScanWithOptions("user", prefix, 1, 1000, reverse)
+				for i := range got {
+					if fmt.Sprint(got[i]) != fmt.Sprint(expected[len(expected)-1-i]) {
+						t.Errorf("Reverse PrefixScan(%s):\n got %v\nwant %v reversed", prefix, got, expected)
+						break
+					}
+				}
+				if len(got) != len(expected) {
+					t.Errorf("Reverse PrefixScan(%s) returned %d entries, want %d", prefix, len(got), len(expected))
+				}
+			}
+		})
+	}
+}
+
+func TestIteratorKeepsItsViewWhileWritesContinue(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer eng.Shut()
+	for i := 0; i < 20; i++ {
+		eng.Write("user", fmt.Sprintf("row%02d", i), "old", false)
+	}
+
+	it, err := eng.PrefixIterate("user", "row")
+	if err != nil {
+		t.Fatalf("Failed to iterate: %v", err)
+	}
+	defer it.Stop()
+	first, _, _ := it.Next()
+	// flushes and compactions run underneath the open iterator
+	for i := 0; i < 20; i++ {
+		eng.Write("user", fmt.Sprintf("row%02d", i), "new", false)
+		eng.Write("user", fmt.Sprintf("row%02da", i), "new", false)
+	}
+	keys := []string{first}
+	for it.HasNext() {
+		key, value, _ := it.Next()
+		if value != "old" {
+			t.Errorf("Expected %s as of the start of the scan, got %s", key, value)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) != 20 || it.Err() != nil {
+		t.Errorf("Expected the 20 rows written before the scan, got %d: %s (err %v)", len(keys), strings.Join(keys, ","), it.Err())
+	}
+
+	it.Reset()
+	count := 0
+	for it.HasNext() {
+		it.Next()
+		count++
+	}
+	if count != 40 {
+		t.Errorf("Expected Reset to read the 40 rows written since, got %d", count)
+	}
+}
+
+func TestScanPagesResumeFromToken(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer eng.Shut()
+	for i := 0; i < 30; i += 2 {
+		eng.Write("user", fmt.Sprintf("item%02d", i), "value", false)
+	}
+
+	keys := []string{}
+	token := ""
+	for pages := 0; ; pages++ {
+		page, err := eng.PrefixScanPage("user", "item", token, 4)
+		if err != nil {
+			t.Fatalf("Failed to read page %d: %v", pages, err)
+		}
+		for _, record := range page.Entries {
+			keys = append(keys, record[0])
+		}
+		if pages == 0 {
+			// writes between pages neither shift nor repeat what was returned
+			eng.Write("user", "item00a", "value", false)
+			eng.Write("user", "item29", "value", false)
+			eng.Delete("user", "item02")
+		}
+		if page.Token == "" {
+			break
+		}
+		token = page.Token
+	}
+	want := "item00,item02,item04,item06,item08,item10,item12,item14,item16,item18,item20,item22,item24,item26,item28,item29"
+	if got := strings.Join(keys, ","); got != want {
+		t.Errorf("Expected the pages to continue after the last key\n got %s\nwant %s", got, want)
+	}
+
+	if _, err := eng.RangeScanPage("user", "item", "itemz", "not a token", 4); err == nil {
+		t.Errorf("Expected an invalid token to be rejected")
+	}
+}
+
+func TestScanPagesKeepTheirSnapshot(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer eng.Shut()
+	for i := 0; i < 10; i++ {
+		eng.Write("user", fmt.Sprintf("row%d", i), "old", false)
+	}
+	snap := eng.Snapshot()
+	defer snap.Release()
+	opts := engine.ReadOptions{Snapshot: snap}
+
+	page, err := eng.RangeScanPageWithOptions("user", "row0", "row9", "", 5, opts)
+	if err != nil || len(page.Entries) != 5 || page.Token == "" {
+		t.Fatalf("Expected a first page of 5 with a token, got %v err=%v", page, err)
+	}
+	for i := 0; i < 10; i++ {
+		eng.Write("user", fmt.Sprintf("row%d", i), "new", false)
+	}
+	if _, err := eng.RangeScanPage("user", "row0", "row9", page.Token, 5); err == nil {
+		t.Errorf("Expected a snapshot token to be refused without its snapshot")
+	}
+	page, err = eng.RangeScanPageWithOptions("user", "row0", "row9", page.Token, 5, opts)
+	if err != nil || len(page.Entries) != 5 || page.Token != "" {
+		t.Fatalf("Expected the last page of 5, got %v err=%v", page, err)
+	}
+	for _, record := range page.Entries {
+		if record[1] != "old" {
+			t.Errorf("Expected %s as of the snapshot, got %s", record[0], record[1])
+		}
+	}
+}
+
+func TestIteratorMovesBothWays(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer eng.Shut()
+	for i := 0; i < 30; i++ {
+		eng.Write("user", fmt.Sprintf("row%02d", i), fmt.Sprintf("v%d", i), false)
+	}
+	eng.Delete("user", "row10")
+
+	it, err := eng.RangeIterate("user", "row05", "row20")
+	if err != nil {
+		t.Fatalf("Failed to iterate: %v", err)
+	}
+	defer it.Stop()
+	if key, _, _ := it.Prev(); key != "" {
+		t.Errorf("Expected nothing before the first Next, got %s", key)
+	}
+	count := 0
+	for it.HasNext() {
+		it.Next()
+		count++
+	}
+	if count != 15 {
+		t.Errorf("Expected 15 live keys in the range, got %d", count)
+	}
+	// an iterator at the end still moves back
+	if key, _, hasPrev := it.Prev(); key != "row20" || !hasPrev {
+		t.Errorf("Expected Prev at the end to return row20, got %q %v", key, hasPrev)
+	}
+	if key, _, hasNext := it.Next(); key != "row20" || hasNext {
+		t.Errorf("Expected Next to return row20 again as the last key, got %q %v", key, hasNext)
+	}
+
+	it.Seek("row08")
+	for _, want := range []string{"row08", "row09", "row11"} {
+		if key, _, _ := it.Next(); key != want {
+			t.Errorf("Expected Next to return %s, got %s", want, key)
+		}
+	}
+	for _, want := range []string{"row11", "row09", "row08", "row07"} {
+		if key, _, _ := it.Prev(); key != want {
+			t.Errorf("Expected Prev to return %s, got %s", want, key)
+		}
+	}
+	if key, _, _ := it.Next(); key != "row07" {
+		t.Errorf("Expected Next after Prev to return the same key, got %s", key)
+	}
+
+	it.SeekForPrev("row10")
+	if key, value, _ := it.Next(); key != "row09" || value != "v9" {
+		t.Errorf("Expected SeekForPrev past a deleted key to land on row09, got %s=%s", key, value)
+	}
+	it.Seek("row00")
+	if key, _, hasPrev := it.Prev(); key != "" || hasPrev {
+		t.Errorf("Expected nothing before the start of the range, got %q", key)
+	}
+	if key, _, _ := it.Next(); key != "row05" {
+		t.Errorf("Expected a seek before the range to clamp to row05, got %s", key)
+	}
+}
+
+func TestIteratorWalksSortedMemtables(t *testing.T) {
+	for _, memtableType := range []string{"skiplist", "btree"} {
+		t.Run(memtableType, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.MemtableType = memtableType
+			cfg.MemtableSize = 1000 // keep everything in the active memtable
+			eng, err := engine.NewEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer eng.Shut()
+			for i := 0; i < 20; i += 2 {
+				eng.Write("user", fmt.Sprintf("row%02d", i), "old", false)
+			}
+
+			it, err := eng.RangeIterate("user", "row03", "row15")
+			if err != nil {
+				t.Fatalf("Failed to iterate: %v", err)
+			}
+			defer it.Stop()
+			// writes landing between the keys are not seen by the iterator
+			for i := 0; i < 20; i++ {
+				eng.Write("user", fmt.Sprintf("row%02d", i), "new", false)
+			}
+			for _, want := range []string{"row04", "row06", "row08"} {
+				if key, value, _ := it.Next(); key != want || value != "old" {
+					t.Errorf("Expected Next to return %s=old, got %s=%s", want, key, value)
+				}
+			}
+			for _, want := range []string{"row08", "row06"} {
+				if key, value, _ := it.Prev(); key != want || value != "old" {
+					t.Errorf("Expected Prev to return %s=old, got %s=%s", want, key, value)
+				}
+			}
+			it.Seek("row11")
+			for _, want := range []string{"row12", "row14"} {
+				if key, value, _ := it.Next(); key != want || value != "old" {
+					t.Errorf("Expected Next after Seek to return %s=old, got %s=%s", want, key, value)
+				}
+			}
+			if it.HasNext() {
+				t.Errorf("Expected the scan to end at row14")
+			}
+		})
+	}
+}
+
+func TestReverseScansReturnLatestFirst(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer eng.Shut()
+	// time-ordered keys spread over many SSTables
+	for i := 0; i < 60; i++ {
+		eng.Write("user", fmt.Sprintf("event:%04d", i), fmt.Sprintf("e%d", i), false)
+	}
+	eng.Write("user", "eventz", "outside", false)
+	reverse := engine.ReadOptions{Reverse: true}
+
+	it, err := eng.PrefixIterateWithOptions("user", "event:", reverse)
+	if err != nil {
+		t.Fatalf("Failed to iterate: %v", err)
+	}
+	latest := []string{}
+	for len(latest) < 5 && it.HasNext() {
+		key, _, _ := it.Next()
+		latest = append(latest, key)
+	}
+	it.Stop()
+	if got := strings.Join(latest, ","); got != "event:0059,event:0058,event:0057,event:0056,event:0055" {
+		t.Errorf("Expected the latest 5 events newest first, got %s", got)
+	}
+
+	it, err = eng.PrefixIterateWithOptions("user", "event:", reverse)
+	if err != nil {
+		t.Fatalf("Failed to iterate: %v", err)
+	}
+	it.Seek("event:0030")
+	if key, _, _ := it.Next(); key != "event:0030" {
+		t.Errorf("Expected a reverse scan to continue down from event:0030, got %s", key)
+	}
+	if key, _, _ := it.Next(); key != "event:0029" {
+		t.Errorf("Expected event:0029 after event:0030 in reverse, got %s", key)
+	}
+	it.Stop()
+
+	keys := []string{}
+	token := ""
+	for {
+		page, err := eng.RangeScanPageWithOptions("user", "event:0010", "event:0050", token, 7, reverse)
+		if err != nil {
+			t.Fatalf("Failed to read page: %v", err)
+		}
+		for _, record := range page.Entries {
+			keys = append(keys, record[0])
+		}
+		if page.Token == "" {
+			break
+		}
+		token = page.Token
+	}
+	if len(keys) != 41 || keys[0] != "event:0050" || keys[40] != "event:0010" || !sort.SliceIsSorted(keys, func(i, j int) bool { return keys[i] > keys[j] }) {
+		t.Errorf("Expected reverse pages from event:0050 down to event:0010, got %v", keys)
+	}
+}
+
+func TestScanOptionsBoundAndLimitScans(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer eng.Shut()
+	for i := 0; i < 20; i++ {
+		eng.Write("user", fmt.Sprintf("key%02d", i), "vv", false)
+	}
+	eng.Write("user", "key", "vv", false)
+	eng.Write("user", "zzz", "last", false)
+	keysOf := func(entries [][]string) string {
+		keys := []string{}
+		for _, entry := range entries {
+			keys = append(keys, entry[0])
+		}
+		return strings.Join(keys, ",")
+	}
+	scan := func(scan engine.ScanOptions) string {
+		return keysOf(eng.RangeScanWithOptions("user", "key05", "key08", 1, 100, engine.ReadOptions{Scan: scan}))
+	}
+
+	if got := scan(engine.ScanOptions{}); got != "key05,key06,key07,key08" {
+		t.Errorf("Expected both bounds included by default, got %s", got)
+	}
+	if got := scan(engine.ScanOptions{ExcludeStart: true, ExcludeEnd: true}); got != "key06,key07" {
+		t.Errorf("Expected both bounds excluded, got %s", got)
+	}
+	if got := scan(engine.ScanOptions{ExcludeStart: true, OpenEnd: true, MaxItems: 100}); !strings.HasPrefix(got, "key06,") || !strings.HasSuffix(got, ",key19,zzz") {
+		t.Errorf("Expected an open end to run to the last key, got %s", got)
+	}
+	if got := keysOf(eng.RangeScanWithOptions("user", "key05", "key08", 1, 100, engine.ReadOptions{Reverse: true, Scan: engine.ScanOptions{ExcludeEnd: true}})); got != "key07,key06,key05" {
+		t.Errorf("Expected a reverse scan to start below an excluded end, got %s", got)
+	}
+
+	// every entry but key is 7 bytes
+	limited := engine.ReadOptions{Scan: engine.ScanOptions{MaxItems: 3}}
+	if got := keysOf(eng.PrefixScanWithOptions("user", "key", 1, 100, limited)); got != "key,key00,key01" {
+		t.Errorf("Expected PrefixScan to stop after 3 items, got %s", got)
+	}
+	it, err := eng.PrefixIterateWithOptions("user", "key", engine.ReadOptions{Scan: engine.ScanOptions{ExcludeStart: true, MaxBytes: 20}})
+	if err != nil {
+		t.Fatalf("Failed to iterate: %v", err)
+	}
+	defer it.Stop()
+	got := []string{}
+	for it.HasNext() {
+		key, _, _ := it.Next()
+		got = append(got, key)
+	}
+	if strings.Join(got, ",") != "key00,key01" {
+		t.Errorf("Expected PrefixIterate to stop within 20 bytes, got %v", got)
+	}
+	it.Reset()
+	if key, _, _ := it.Next(); key != "key00" {
+		t.Errorf("Expected Reset to restart the scan after the excluded prefix, got %q", key)
+	}
+
+	rit, err := eng.RangeIterateWithOptions("user", "key", "key99", engine.ReadOptions{Scan: engine.ScanOptions{MaxItems: 2, ExcludeEnd: true}})
+	if err != nil {
+		t.Fatalf("Failed to iterate: %v", err)
+	}
+	defer rit.Stop()
+	if _, _, hasNext := rit.Next(); !hasNext {
+		t.Errorf("Expected a second entry within the limit")
+	}
+	if _, _, hasNext := rit.Next(); hasNext {
+		t.Errorf("Expected RangeIterate to stop at its limit")
+	}
+
+	page, err := eng.RangeScanPageWithOptions("user", "key", "key99", "", 100, engine.ReadOptions{Scan: engine.ScanOptions{MaxBytes: 30}})
+	if err != nil || len(page.Entries) != 4 || page.Token == "" {
+		t.Fatalf("Expected a page of 4 entries within 30 bytes and a token, got %v err=%v", page.Entries, err)
+	}
+	if _, err := eng.RangeScanPageWithOptions("user", "key", "key99", "", 1, engine.ReadOptions{Scan: engine.ScanOptions{MaxItems: -1}}); err == nil {
+		t.Errorf("Expected a negative limit to be rejected")
+	}
+}
+
+func TestScansPreferNewerTablesOnEqualSequence(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CompactionThreshold = 100 // keep both tables on lvl0
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	// tables written before sequence numbers existed carry 0 on every entry
+	for _, value := range []string{"old", "new"} {
+		mt := m.NewMemtable(cfg)
+		for i := 0; i < 5; i++ {
+			mt.Add(key_value.NewKeyValue(fmt.Sprintf("key%d", i), value))
+		}
+		fileWriter := fw.NewFileWriter(bm, cfg.BlockSize, cfg.SSTableDir(), fw.TableName(0, tableNumber.Add(1)))
+		if err := ss_parser.NewSSParser(fileWriter, cfg).FlushMemtable(mt.ToRaw()); err != nil {
+			t.Fatalf("Failed to flush %s table: %v", value, err)
+		}
+	}
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer eng.Shut()
+
+	for _, reverse := range []bool{false, true} {
+		opts := engine.ReadOptions{Reverse: reverse}
+		page := eng.RangeScanWithOptions("user", "key0", "key4", 1, 10, opts)
+		if len(page) != 5 {
+			t.Fatalf("Expected 5 keys, got %v", page)
+		}
+		for _, entry := range page {
+			if entry[1] != "new" {
+				t.Errorf("reverse=%v: expected %s from the newer table, got %s", reverse, entry[0], entry[1])
+			}
+		}
+	}
+	if value, _, _ := eng.Read("user", "key2"); value != "new" {
+		t.Errorf("Expected a point read of the newer table, got %s", value)
+	}
+}
@@ -0,0 +1,166 @@
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/engine"
+	"nosqlEngine/src/models/key_value"
+	b "nosqlEngine/src/service/block_manager"
+	fw "nosqlEngine/src/service/file_writer"
+	r "nosqlEngine/src/service/retriever"
+	"nosqlEngine/src/service/ss_compacter"
+	"nosqlEngine/src/service/ss_parser"
+	"nosqlEngine/src/service/table_pins"
+	"nosqlEngine/src/storage/manifest"
+	m "nosqlEngine/src/storage/memtable"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// flushEntries writes entries into a new SSTable called name, which is
+// relative to the SSTable directory like the names of fw.TableName
+func flushEntries(cfg config.Config, bm *b.BlockManager, name string, entries ...key_value.KeyValue) {
+	mt := m.NewMemtable(cfg)
+	for _, entry := range entries {
+		mt.Add(entry)
+	}
+	fileWriter := fw.NewFileWriter(bm, cfg.BlockSize, cfg.SSTableDir(), name)
+	ss_parser.NewSSParser(fileWriter, cfg).FlushMemtable(mt.ToRaw())
+}
+
+func TestNewestVersionWinsAcrossSSTables(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CompactionThreshold = 2
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	// the second table is newer, its versions hide those of the first
+	flushEntries(cfg, bm, fw.TableName(0, 1),
+		key_value.NewKeyValue("k", "old").WithSeq(1),
+		key_value.NewKeyValue("gone", "old").WithSeq(2),
+		key_value.NewTombstone("back").WithSeq(3))
+	flushEntries(cfg, bm, fw.TableName(0, 2),
+		key_value.NewKeyValue("k", "new").WithSeq(4),
+		key_value.NewTombstone("gone").WithSeq(5),
+		key_value.NewKeyValue("back", "new").WithSeq(6))
+
+	mf := openTestManifest(t, cfg, bm)
+	check := func(stage string) {
+		for key, want := range map[string]string{"k": "new", "back": "new"} {
+			entry, found, err := lookupKey(cfg, bm, mf, key)
+			if err != nil || !found || entry.IsTombstone() || entry.GetValue() != want {
+				t.Errorf("%s: %s got %q tombstone=%v err=%v", stage, key, entry.GetValue(), entry.IsTombstone(), err)
+			}
+		}
+		entry, found, _ := lookupKey(cfg, bm, mf, "gone")
+		if stage == "after compaction" {
+			// no older table is left, the tombstone went along with the value it hid
+			if found {
+				t.Errorf("%s: expected gone to be dropped, got %q tombstone=%v", stage, entry.GetValue(), entry.IsTombstone())
+			}
+		} else if !found || !entry.IsTombstone() || entry.GetSeq() != 5 {
+			t.Errorf("%s: expected the newer tombstone for gone, got %q seq=%d", stage, entry.GetValue(), entry.GetSeq())
+		}
+	}
+	check("before compaction")
+
+	if !ss_compacter.NewSSCompacterST(cfg, &sync.RWMutex{}, table_pins.NewTablePins(), mf).CheckCompactionConditions(bm) {
+		t.Fatalf("Compaction conditions not met")
+	}
+	check("after compaction")
+}
+
+func TestOverwriteAcrossFlushes(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableCount = 2
+	cfg.CompactionThreshold = 100 // keep the flushed tables on lvl0
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	// every round ends up in its own SSTables
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 10; i++ {
+			if err := eng.Write("user", fmt.Sprintf("key%d", i), fmt.Sprintf("v%d-%d", round, i), false); err != nil {
+				t.Fatalf("Failed to write: %v", err)
+			}
+		}
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	if _, err := eng.Start(); err != nil {
+		t.Fatalf("Failed to start engine: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		value, found, err := eng.Read("user", fmt.Sprintf("key%d", i))
+		if err != nil || !found || value != fmt.Sprintf("v2-%d", i) {
+			t.Errorf("key%d: got %q found=%v err=%v", i, value, found, err)
+		}
+	}
+	eng.Shut()
+}
+
+func TestLookupStopsAtNewestTable(t *testing.T) {
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	// versions of k from the oldest down in lvl2 to the newest in lvl0
+	flushEntries(cfg, bm, fw.TableName(2, 1), key_value.NewKeyValue("k", "oldest").WithSeq(1), key_value.NewKeyValue("j", "oldest").WithSeq(2))
+	flushEntries(cfg, bm, fw.TableName(1, 2), key_value.NewKeyValue("k", "older").WithSeq(3))
+	flushEntries(cfg, bm, fw.TableName(0, 3), key_value.NewKeyValue("k", "old").WithSeq(4))
+	flushEntries(cfg, bm, fw.TableName(0, 4), key_value.NewTombstone("k").WithSeq(5))
+
+	mf := openTestManifest(t, cfg, bm)
+	order := manifest.SearchOrder(mf.Tables(), "k", cfg.Comparator().CompareStrings)
+	numbers := []uint64{}
+	for _, table := range order {
+		numbers = append(numbers, table.Number)
+	}
+	if fmt.Sprint(numbers) != "[4 3 2 1]" {
+		t.Errorf("Expected lvl0 newest first and then the levels below, got %v", numbers)
+	}
+	if order := manifest.SearchOrder(mf.Tables(), "j", cfg.Comparator().CompareStrings); len(order) != 1 || order[0].Number != 1 {
+		t.Errorf("Expected only the table holding j to be searched, got %v", order)
+	}
+
+	retriever := r.NewEntryRetriever(bm, cfg)
+	if entry, found, _ := retriever.RetrieveEntryAt("k", mf.PathsOf(order), math.MaxUint64); !found || !entry.IsTombstone() {
+		t.Errorf("Expected the tombstone of the newest table, got %q tombstone=%v", entry.GetValue(), entry.IsTombstone())
+	}
+	if entry, found, _ := retriever.RetrieveEntryAt("k", mf.PathsOf(order), 3); !found || entry.GetValue() != "older" {
+		t.Errorf("Expected the newest version up to seq 3, got %q", entry.GetValue())
+	}
+	if entry, found, _ := lookupKey(cfg, bm, mf, "k"); !found || !entry.IsTombstone() {
+		t.Errorf("Expected the lookup to find the tombstone first, got %q", entry.GetValue())
+	}
+}
+
+func TestLookupFailsOnUnreadableNewerTable(t *testing.T) {
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	flushEntries(cfg, bm, fw.TableName(0, 1), key_value.NewKeyValue("k", "old").WithSeq(1))
+	flushEntries(cfg, bm, fw.TableName(0, 2), key_value.NewTombstone("k").WithSeq(2))
+	newer := filepath.Join(cfg.SSTableDir(), fw.TableName(0, 2))
+	mf := openTestManifest(t, cfg, bm)
+
+	// a miss moves on to the older table
+	if _, found, err := lookupKey(cfg, bm, mf, "missing"); found || !errors.Is(err, r.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing key, got found=%v err=%v", found, err)
+	}
+	// the newer table can no longer be read, its tombstone must not be skipped
+	if err := os.Truncate(newer, 0); err != nil {
+		t.Fatalf("Failed to truncate %s: %v", newer, err)
+	}
+	bm = b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	entry, found, err := lookupKey(cfg, bm, mf, "k")
+	if err == nil || errors.Is(err, r.ErrNotFound) || found {
+		t.Errorf("Expected the unreadable table to fail the lookup, got %q found=%v err=%v", entry.GetValue(), found, err)
+	}
+}
@@ -0,0 +1,142 @@
+package integration
+
+import (
+	"fmt"
+	"nosqlEngine/src/engine"
+	"nosqlEngine/src/service/table_pins"
+	"nosqlEngine/src/utils"
+	"testing"
+)
+
+func TestSnapshotSeesPointInTime(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableSize = 1000 // keep everything in the memtable
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	eng.Write("user", "k", "v1", false)
+	eng.Write("user", "d", "alive", false)
+	snap := eng.Snapshot()
+	eng.Write("user", "k", "v2", false)
+	eng.Write("user", "new", "value", false)
+	eng.Delete("user", "d")
+
+	opts := engine.ReadOptions{Snapshot: snap}
+	for key, want := range map[string]string{"k": "v1", "d": "alive"} {
+		if value, found, err := eng.ReadWithOptions("user", key, opts); !found || value != want {
+			t.Errorf("snapshot %s: got %q found=%v err=%v", key, value, found, err)
+		}
+	}
+	if _, found, _ := eng.ReadWithOptions("user", "new", opts); found {
+		t.Errorf("Expected key written after the snapshot to be hidden")
+	}
+	if value, _, _ := eng.Read("user", "k"); value != "v2" {
+		t.Errorf("Expected latest read to see v2, got %q", value)
+	}
+
+	snap.Release()
+	if _, _, err := eng.ReadWithOptions("user", "k", opts); err == nil {
+		t.Errorf("Expected a read with a released snapshot to fail")
+	}
+	eng.Shut()
+}
+
+func TestSnapshotsShareTheActiveMemtable(t *testing.T) {
+	for _, memtableType := range []string{"hashmap", "skiplist", "btree"} {
+		t.Run(memtableType, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.MemtableType = memtableType
+			cfg.MemtableSize = 1000 // keep everything in the active memtable
+			eng, err := engine.NewEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer eng.Shut()
+			eng.Write("user", "a", "a1", false)
+			eng.Write("user", "k", "v1", false)
+			first := eng.Snapshot()
+			defer first.Release()
+			eng.Write("user", "k", "v2", false)
+			eng.Write("user", "b", "b2", false)
+			second := eng.Snapshot()
+			defer second.Release()
+			eng.Write("user", "k", "v3", false)
+			eng.Delete("user", "a")
+			eng.Write("user", "k", "v4", false)
+
+			for _, c := range []struct {
+				snap *engine.Snapshot
+				want [][]string
+			}{
+				{first, [][]string{{"a", "a1"}, {"k", "v1"}}},
+				{second, [][]string{{"a", "a1"}, {"b", "b2"}, {"k", "v2"}}},
+				{nil, [][]string{{"b", "b2"}, {"k", "v4"}}},
+			} {
+				opts := engine.ReadOptions{Snapshot: c.snap}
+				if got := eng.RangeScanWithOptions("user", "a", "z", 1, 10, opts); fmt.Sprint(got) != fmt.Sprint(c.want) {
+					t.Errorf("Expected scan %v, got %v", c.want, got)
+				}
+				want := c.want[len(c.want)-1][1]
+				if value, found, err := eng.ReadWithOptions("user", "k", opts); !found || value != want {
+					t.Errorf("k: expected %q, got %q found=%v err=%v", want, value, found, err)
+				}
+			}
+		})
+	}
+}
+
+func retiredTables(dirs []string) int {
+	count := 0
+	for _, dir := range dirs {
+		count += len(utils.GetPaths(dir, table_pins.RetiredSuffix))
+	}
+	return count
+}
+
+func TestSnapshotKeepsCompactedTables(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableCount = 2
+	cfg.MaxTokens = 1000000
+	cfg.LSMLevels = 6 // bottom level tables are never compacted, keep the pinned ones above it
+	dirs := []string{}
+	for level := 0; level <= cfg.LSMLevels; level++ {
+		dirs = append(dirs, cfg.LevelDir(level))
+	}
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		eng.Write("user", fmt.Sprintf("key%02d", i), "old", false)
+	}
+	snap := eng.Snapshot()
+	// overwrite everything, the flushes compact the tables the snapshot reads
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 20; i++ {
+			eng.Write("user", fmt.Sprintf("key%02d", i), fmt.Sprintf("new%d", round), false)
+		}
+	}
+
+	opts := engine.ReadOptions{Snapshot: snap}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		if value, found, err := eng.ReadWithOptions("user", key, opts); !found || value != "old" {
+			t.Errorf("snapshot %s: got %q found=%v err=%v", key, value, found, err)
+		}
+		if value, found, err := eng.Read("user", key); !found || value != "new2" {
+			t.Errorf("latest %s: got %q found=%v err=%v", key, value, found, err)
+		}
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	if retiredTables(dirs) == 0 {
+		t.Errorf("Expected compacted tables pinned by the snapshot to be kept")
+	}
+	snap.Release()
+	if n := retiredTables(dirs); n != 0 {
+		t.Errorf("Expected released tables to be deleted, %d left", n)
+	}
+}
@@ -0,0 +1,142 @@
+package integration
+
+import (
+	"fmt"
+	"nosqlEngine/src/engine"
+	"nosqlEngine/src/models/key_value"
+	b "nosqlEngine/src/service/block_manager"
+	fw "nosqlEngine/src/service/file_writer"
+	"nosqlEngine/src/service/ss_compacter"
+	"nosqlEngine/src/service/table_pins"
+	"nosqlEngine/src/utils"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTTLKeyExpires(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	eng.Write("user", "session:flushed", "old", false)
+	eng.PutWithTTL("user", "session:flushed", "new", 300*time.Millisecond)
+	// push it into an SSTable, where expiry has to be read back from disk
+	for i := 0; i < cfg.MemtableSize*2; i++ {
+		eng.Write("user", fmt.Sprintf("filler%03d", i), "value", false)
+	}
+	eng.Write("user", "session:memtable", "old", false)
+	eng.PutWithTTL("user", "session:memtable", "new", 300*time.Millisecond)
+	eng.PutWithTTL("user", "session:long", "value", time.Hour)
+	if err := eng.PutWithTTL("user", "session:bad", "value", 0); err == nil {
+		t.Errorf("Expected a non-positive TTL to be rejected")
+	}
+
+	for _, key := range []string{"session:flushed", "session:memtable"} {
+		if value, found, _ := eng.Read("user", key); !found || value != "new" {
+			t.Errorf("Expected %s=new before expiry, got %q", key, value)
+		}
+	}
+	time.Sleep(400 * time.Millisecond)
+
+	// the expired version is the newest, the older value must not come back
+	for _, key := range []string{"session:flushed", "session:memtable"} {
+		if value, found, _ := eng.Read("user", key); found {
+			t.Errorf("Expected %s to have expired, got %q", key, value)
+		}
+	}
+	if value, found, _ := eng.Read("user", "session:long"); !found || value != "value" {
+		t.Errorf("Expected session:long to be live, got %q", value)
+	}
+	if page := eng.PrefixScan("user", "session:", 1, 10); len(page) != 1 || page[0][0] != "session:long" {
+		t.Errorf("Expected only session:long in the prefix scan, got %v", page)
+	}
+	if page := eng.RangeScan("user", "session:a", "session:z", 1, 10); len(page) != 1 || page[0][0] != "session:long" {
+		t.Errorf("Expected only session:long in the range scan, got %v", page)
+	}
+	if ok, err := eng.PutIfAbsent("user", "session:memtable", "again"); !ok || err != nil {
+		t.Errorf("Expected PutIfAbsent to treat an expired key as absent, got %v err=%v", ok, err)
+	}
+	eng.Shut()
+}
+
+func TestTTLIsRecoveredFromWAL(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	eng.PutWithTTL("user", "short", "value", 200*time.Millisecond)
+	eng.PutWithTTL("user", "long", "value", time.Hour)
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	if _, err := eng.Start(); err != nil {
+		t.Fatalf("Failed to start engine: %v", err)
+	}
+	if _, found, _ := eng.Read("user", "short"); found {
+		t.Errorf("Expected short to stay expired after recovery")
+	}
+	if value, found, _ := eng.Read("user", "long"); !found || value != "value" {
+		t.Errorf("Expected long to be recovered, got %q", value)
+	}
+	eng.Shut()
+}
+
+func TestCompactionDropsExpiredEntries(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CompactionThreshold = 2
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	past := time.Now().Add(-time.Minute)
+	// the lvl0 tables are compacted together, the lvl2 one keeps an older
+	// version of k outside
+	flushEntries(cfg, bm, fw.TableName(0, 2),
+		key_value.NewExpiringKeyValue("expired", "value", past).WithSeq(3),
+		key_value.NewExpiringKeyValue("k", "new", past).WithSeq(4))
+	flushEntries(cfg, bm, fw.TableName(0, 3),
+		key_value.NewKeyValue("live", "value").WithSeq(5),
+		key_value.NewExpiringKeyValue("later", "value", time.Now().Add(time.Hour)).WithSeq(6))
+	flushEntries(cfg, bm, fw.TableName(2, 1), key_value.NewKeyValue("k", "old").WithSeq(1))
+
+	mf := openTestManifest(t, cfg, bm)
+	if !ss_compacter.NewSSCompacterST(cfg, &sync.RWMutex{}, table_pins.NewTablePins(), mf).CheckCompactionConditions(bm) {
+		t.Fatalf("Compaction conditions not met")
+	}
+	if entry, found, _ := lookupKey(cfg, bm, mf, "expired"); found {
+		t.Errorf("Expected the expired entry to be dropped, got %q", entry.GetValue())
+	}
+	if entry, found, _ := lookupKey(cfg, bm, mf, "k"); !found || !entry.IsTombstone() || entry.GetSeq() != 4 {
+		t.Errorf("Expected a tombstone hiding the older k, got %q tombstone=%v seq=%d", entry.GetValue(), entry.IsTombstone(), entry.GetSeq())
+	}
+	if entry, found, _ := lookupKey(cfg, bm, mf, "live"); !found || entry.GetValue() != "value" {
+		t.Errorf("Expected live to survive compaction, got %q", entry.GetValue())
+	}
+	if entry, found, _ := lookupKey(cfg, bm, mf, "later"); !found || entry.GetExpiry() == 0 {
+		t.Errorf("Expected later to keep its expiry, got expiry %d", entry.GetExpiry())
+	}
+}
+
+func TestCompactionOfOnlyExpiredEntriesLeavesNoTable(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CompactionThreshold = 2
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	past := time.Now().Add(-time.Minute)
+	flushEntries(cfg, bm, fw.TableName(0, 1), key_value.NewExpiringKeyValue("x", "value", past).WithSeq(1))
+	flushEntries(cfg, bm, fw.TableName(0, 2), key_value.NewExpiringKeyValue("y", "value", past).WithSeq(2))
+
+	mf := openTestManifest(t, cfg, bm)
+	ss_compacter.NewSSCompacterST(cfg, &sync.RWMutex{}, table_pins.NewTablePins(), mf).CheckCompactionConditions(bm)
+	if tables := mf.Tables(); len(tables) != 0 {
+		t.Errorf("Expected no tables after compacting only expired entries, got %v", tables)
+	}
+	if leftovers := utils.GetPaths(cfg.LevelDir(1), ""); len(leftovers) != 0 {
+		t.Errorf("Expected the empty output to be discarded, got %v", leftovers)
+	}
+}
@@ -0,0 +1,156 @@
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"nosqlEngine/src/engine"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestTxnReadsOwnWritesAndCommits(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	eng.Write("user", "a", "1", false)
+	eng.Write("user", "gone", "value", false)
+
+	txn := eng.BeginTxn("user")
+	value, found, err := txn.Get("a")
+	if err != nil || !found || value != "1" {
+		t.Fatalf("Expected a=1, got %q found=%v err=%v", value, found, err)
+	}
+	txn.Put("b", "2")
+	txn.Delete("gone")
+	if value, found, _ := txn.Get("b"); !found || value != "2" {
+		t.Errorf("Expected the transaction to see its own write, got %q", value)
+	}
+	if _, found, _ := txn.Get("gone"); found {
+		t.Errorf("Expected the transaction to see its own delete")
+	}
+	if _, found, _ := eng.Read("user", "b"); found {
+		t.Errorf("Expected buffered writes to stay invisible before commit")
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if value, found, _ := eng.Read("user", "b"); !found || value != "2" {
+		t.Errorf("Expected b=2 after commit, got %q", value)
+	}
+	if _, found, _ := eng.Read("user", "gone"); found {
+		t.Errorf("Expected gone to be deleted after commit")
+	}
+	eng.Shut()
+}
+
+func TestTxnConflictIsRetryable(t *testing.T) {
+	cfg := testConfig(t)
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	eng.Write("user", "k", "v1", false)
+
+	txn := eng.BeginTxn("user")
+	txn.Get("k")
+	txn.Get("missing")
+	eng.Write("user", "k", "v2", false) // someone else wins the race
+	txn.Put("k", "from txn")
+	if err := txn.Commit(); !errors.Is(err, engine.ErrTxnConflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+	if value, _, _ := eng.Read("user", "k"); value != "v2" {
+		t.Errorf("Expected the conflicting transaction to write nothing, got %q", value)
+	}
+
+	// a key that did not exist counts as read too
+	txn = eng.BeginTxn("user")
+	txn.Get("missing")
+	eng.Write("user", "missing", "now here", false)
+	txn.Put("other", "value")
+	if err := txn.Commit(); !errors.Is(err, engine.ErrTxnConflict) {
+		t.Errorf("Expected a conflict on a created key, got %v", err)
+	}
+
+	txn = eng.BeginTxn("user")
+	txn.Get("k")
+	txn.Put("k", "from retry")
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Expected the retry to commit, got %v", err)
+	}
+	if value, _, _ := eng.Read("user", "k"); value != "from retry" {
+		t.Errorf("Expected the retry's write, got %q", value)
+	}
+	eng.Shut()
+}
+
+func TestTxnConcurrentIncrements(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableCount = 2
+	cfg.MaxTokens = 1000000
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	eng.Write("user", "counter", "0", false)
+
+	const workers, increments = 4, 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				for {
+					txn := eng.BeginTxn("user")
+					value, _, err := txn.Get("counter")
+					if err != nil {
+						txn.Rollback()
+						t.Errorf("Failed to read counter: %v", err)
+						return
+					}
+					n, _ := strconv.Atoi(value)
+					txn.Put("counter", strconv.Itoa(n+1))
+					txn.Put(fmt.Sprintf("w%d-i%d", w, i), value)
+					err = txn.Commit()
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, engine.ErrTxnConflict) {
+						t.Errorf("Unexpected commit error: %v", err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if value, _, err := eng.Read("user", "counter"); value != strconv.Itoa(workers*increments) {
+		t.Errorf("Expected counter %d, got %q err=%v", workers*increments, value, err)
+	}
+	eng.Shut()
+}
+
+func TestTxnCommitFailsOnUnreadableTable(t *testing.T) {
+	eng := engineWithUnreadableTables(t, "a")
+	txn := eng.BeginTxn("user")
+	if _, _, err := txn.Get("a"); err == nil {
+		t.Errorf("Expected the read of an unreadable table to fail")
+	}
+	txn.Put("a", "mine")
+	err := txn.Commit()
+	if err == nil || errors.Is(err, engine.ErrTxnConflict) {
+		t.Errorf("Expected the commit to fail on the unreadable table, got %v", err)
+	}
+	// nothing was written, and mem_lock was released for keys outside the tables
+	if ok, err := eng.PutIfAbsent("user", "0", "value"); !ok || err != nil {
+		t.Errorf("Expected the engine to keep taking writes, got %v err=%v", ok, err)
+	}
+	if _, found, _ := eng.Read("user", "a"); found {
+		t.Errorf("Expected the transaction to write nothing")
+	}
+}
@@ -0,0 +1,99 @@
+package integration
+
+import (
+	"fmt"
+	"nosqlEngine/src/engine"
+	wal "nosqlEngine/src/storage/wal"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWALSegmentsAreKeptUntilFlushed(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableCount = 2
+	cfg.CompactionThreshold = 100 // keep the flushed tables on lvl0
+	cfg.WALSegmentSize = 1        // one segment per commit
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	const count = 51
+	for i := 0; i < count; i++ {
+		if err := eng.Write("user", fmt.Sprintf("key%02d", i), fmt.Sprintf("value%d", i), false); err != nil {
+			t.Fatalf("Failed to write key%02d: %v", i, err)
+		}
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	checkpoint, err := wal.ReadCheckpoint(cfg.WALDir())
+	if err != nil || checkpoint == 0 {
+		t.Fatalf("Expected a WAL checkpoint after flushing, got %d err=%v", checkpoint, err)
+	}
+	segments, _ := wal.GetWALSegmentPaths(cfg.WALDir())
+	if len(segments) >= count {
+		t.Errorf("Expected flushed segments to be deleted, %d are left", len(segments))
+	}
+
+	// only the writes still in the active memtable come back from the WAL
+	eng, err = engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	report, err := eng.Start()
+	if err != nil {
+		t.Fatalf("Failed to start engine: %v", err)
+	}
+	if report.Replayed == 0 || uint64(report.Replayed) > count-checkpoint {
+		t.Errorf("Expected the %d unflushed writes to be replayed, got %d", count-checkpoint, report.Replayed)
+	}
+	for i := 0; i < count; i++ {
+		value, found, err := eng.Read("user", fmt.Sprintf("key%02d", i))
+		if err != nil || !found || value != fmt.Sprintf("value%d", i) {
+			t.Errorf("key%02d: got %q found=%v err=%v", i, value, found, err)
+		}
+	}
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+}
+
+func TestCheckpointAdvancesAfterFailedFlush(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MemtableSize = 2
+	cfg.MemtableCount = 2
+	cfg.CompactionThreshold = 100 // keep the flushed tables on lvl0
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	// lvl0 is a file for a moment, the first flush fails and is retried
+	os.RemoveAll(cfg.LevelDir(0))
+	if err := os.WriteFile(cfg.LevelDir(0), nil, 0644); err != nil {
+		t.Fatalf("Failed to block lvl0: %v", err)
+	}
+	restored := make(chan struct{})
+	time.AfterFunc(20*time.Millisecond, func() {
+		os.Remove(cfg.LevelDir(0))
+		os.MkdirAll(cfg.LevelDir(0), 0755)
+		close(restored)
+	})
+	const count = 40
+	for i := 0; i < count; i++ {
+		if err := eng.Write("user", fmt.Sprintf("key%02d", i), "value", false); err != nil {
+			t.Fatalf("Failed to write key%02d: %v", i, err)
+		}
+	}
+	<-restored
+	if err := eng.Shut(); err != nil {
+		t.Fatalf("Failed to shut engine: %v", err)
+	}
+
+	// every frozen memtable was flushed, only the active one may be left out
+	checkpoint, err := wal.ReadCheckpoint(cfg.WALDir())
+	if err != nil || checkpoint < count-uint64(cfg.MemtableSize) {
+		t.Errorf("Expected the checkpoint to reach the last flushed write, got %d err=%v", checkpoint, err)
+	}
+}
@@ -0,0 +1,229 @@
+package integration
+
+import (
+	"fmt"
+	"nosqlEngine/src/config"
+	"nosqlEngine/src/engine"
+	b "nosqlEngine/src/service/block_manager"
+	fw "nosqlEngine/src/service/file_writer"
+	wal "nosqlEngine/src/storage/wal"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestWALDurabilityModes(t *testing.T) {
+	for _, mode := range []string{wal.SyncEveryWrite, wal.SyncGroup, wal.SyncPeriodic} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.WALSyncMode = mode
+			cfg.WALBufferSize = 4
+			cfg.WALSegmentSize = 100000
+			bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+			log, err := wal.NewWAL(bm, cfg)
+			if err != nil {
+				t.Fatalf("Failed to create WAL: %v", err)
+			}
+
+			const writers, perWriter = 4, 10
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWriter; i++ {
+						if err := log.WritePut(fmt.Sprintf("w%d-key%d", w, i), "value"); err != nil {
+							t.Errorf("Failed to write entry to WAL: %v", err)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			if mode == wal.SyncPeriodic {
+				// acknowledged writes only reach the disk on the next sync
+				if err := log.Close(); err != nil {
+					t.Fatalf("Failed to close WAL: %v", err)
+				}
+			}
+
+			// everything acknowledged must be on disk without any further flush
+			entries, _, err := wal.ReplayWAL(bm, cfg)
+			if err != nil {
+				t.Fatalf("Failed to replay WAL: %v", err)
+			}
+			if len(entries) != writers*perWriter {
+				t.Errorf("Expected %d durable entries, got %d", writers*perWriter, len(entries))
+			}
+			log.Close()
+		})
+	}
+}
+
+// writeTestSegment commits count entries, one block each, and returns the segment path
+func writeTestSegment(t *testing.T, cfg config.Config, bm *b.BlockManager, count int) string {
+	log, err := wal.NewWAL(bm, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	for i := 0; i < count; i++ {
+		if err := log.WritePut(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatalf("Failed to write entry to WAL: %v", err)
+		}
+	}
+	log.Close()
+	segments, _ := wal.GetWALSegmentPaths(cfg.WALDir())
+	if len(segments) != 1 {
+		t.Fatalf("Expected one WAL segment, got %d", len(segments))
+	}
+	return segments[0]
+}
+
+func walRecoveryConfig(t *testing.T, mode string) config.Config {
+	cfg := testConfig(t)
+	cfg.BlockSize = 256
+	cfg.WALSegmentSize = 100000
+	cfg.WALSyncMode = wal.SyncEveryWrite
+	cfg.WALRecoveryMode = mode
+	os.MkdirAll(cfg.WALDir(), 0755)
+	return cfg
+}
+
+func TestWALTornTailIsTruncated(t *testing.T) {
+	cfg := walRecoveryConfig(t, wal.RecoverTruncate)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	segment := writeTestSegment(t, cfg, bm, 10)
+	// a crash halfway through writing the last block
+	if err := os.Truncate(segment, int64(9*cfg.BlockSize+20)); err != nil {
+		t.Fatalf("Failed to tear segment: %v", err)
+	}
+
+	entries, report, err := wal.ReplayWAL(bm, cfg)
+	if err != nil {
+		t.Fatalf("Failed to replay WAL: %v", err)
+	}
+	if len(entries) != 9 || report.Replayed != 9 {
+		t.Errorf("Expected 9 replayed entries, got %d", len(entries))
+	}
+	if report.DroppedCount() != 1 || report.Dropped[0].Segment != segment || report.Dropped[0].Offset != int64(9*cfg.BlockSize) {
+		t.Errorf("Unexpected recovery report: %+v", report)
+	}
+
+	// the tail is cut off, so the segment reads cleanly from now on
+	cfg.WALRecoveryMode = wal.RecoverStrict
+	entries, _, err = wal.ReplayWAL(bm, cfg)
+	if err != nil || len(entries) != 9 {
+		t.Errorf("Expected a clean segment with 9 entries, got %d entries, err=%v", len(entries), err)
+	}
+}
+
+func TestWALCorruptRecordModes(t *testing.T) {
+	for _, mode := range []string{wal.RecoverSkip, wal.RecoverStrict} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := walRecoveryConfig(t, mode)
+			bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+			segment := writeTestSegment(t, cfg, bm, 10)
+			// flip a byte of the key stored in the fifth block
+			data, _ := os.ReadFile(segment)
+			data[4*cfg.BlockSize+30] ^= 0xFF
+			os.WriteFile(segment, data, 0644)
+
+			entries, report, err := wal.ReplayWAL(bm, cfg)
+			if mode == wal.RecoverStrict {
+				if err == nil {
+					t.Errorf("Expected strict recovery to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to replay WAL: %v", err)
+			}
+			if len(entries) != 9 || entries[4].Key != "key5" {
+				t.Errorf("Expected the 9 intact entries, got %d", len(entries))
+			}
+			if report.DroppedCount() != 1 || report.Dropped[0].Offset != int64(4*cfg.BlockSize) {
+				t.Errorf("Unexpected recovery report: %+v", report)
+			}
+		})
+	}
+}
+
+func TestWALCorruptionBeforeLastSegmentFailsTruncate(t *testing.T) {
+	cfg := walRecoveryConfig(t, wal.RecoverTruncate)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	segment := writeTestSegment(t, cfg, bm, 3)
+	os.Truncate(segment, int64(2*cfg.BlockSize+20))
+	// a newer segment means the damage is not a torn tail
+	log, _ := wal.NewWAL(bm, cfg)
+	log.WritePut("newer", "value")
+	log.Close()
+
+	if _, _, err := wal.ReplayWAL(bm, cfg); err == nil {
+		t.Errorf("Expected corruption in an older segment to fail recovery")
+	}
+}
+
+func TestFailedWALCommitIsNotApplied(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.WALSyncMode = wal.SyncEveryWrite
+	eng, err := engine.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := eng.Write("user", "k", "durable", false); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	// the WAL directory turns into a file, so no commit can reach the disk
+	if err := os.RemoveAll(cfg.WALDir()); err != nil {
+		t.Fatalf("Failed to remove WAL directory: %v", err)
+	}
+	if err := os.WriteFile(cfg.WALDir(), nil, 0644); err != nil {
+		t.Fatalf("Failed to block WAL directory: %v", err)
+	}
+
+	if err := eng.Write("user", "k", "lost", false); err == nil {
+		t.Fatalf("Expected the write to fail once the WAL cannot commit")
+	}
+	batch := engine.NewBatch()
+	batch.Put("b", "lost")
+	if err := eng.Apply("user", batch); err == nil {
+		t.Errorf("Expected the batch to fail once the WAL cannot commit")
+	}
+	if value, found, _ := eng.Read("user", "k"); !found || value != "durable" {
+		t.Errorf("Expected the failed write to stay invisible, got %q found=%v", value, found)
+	}
+	if _, found, _ := eng.Read("user", "b"); found {
+		t.Errorf("Expected the failed batch to stay invisible")
+	}
+	if swapped, err := eng.CompareAndSwap("user", "k", "lost", "next"); swapped || err != nil {
+		t.Errorf("Expected the failed write not to count for CompareAndSwap, got swapped=%v err=%v", swapped, err)
+	}
+	eng.Shut()
+}
+
+func TestFailedBlockWriteFailsWALCommit(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.WALSyncMode = wal.SyncEveryWrite
+	os.MkdirAll(cfg.WALDir(), 0755)
+	// the block manager refuses blocks of the configured size
+	bm := b.NewBlockManager(cfg.BlockSize/2, cfg.CacheCapacity)
+
+	fileWriter := fw.NewStagedFileWriter(bm, cfg.BlockSize, cfg.SSTableDir(), fw.TableName(0, 1))
+	fileWriter.Write([]byte("entry"), false, nil)
+	if err := fileWriter.FlushCurrentBlock(); err == nil {
+		t.Errorf("Expected the failed block write to be reported")
+	}
+	if err := fileWriter.Commit(); err == nil {
+		t.Errorf("Expected a file missing a block not to be committed")
+	}
+
+	log, err := wal.NewWAL(bm, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	if err := log.WritePut("k", "v"); err == nil {
+		t.Errorf("Expected the WAL commit to fail when its block is not written")
+	}
+	if err := log.WritePut("k2", "v"); err == nil {
+		t.Errorf("Expected the WAL to refuse writes after a failed commit")
+	}
+}
@@ -3,20 +3,64 @@ package integration
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"nosqlEngine/src/config"
+	"nosqlEngine/src/engine"
+	"nosqlEngine/src/models/key_value"
 	b "nosqlEngine/src/service/block_manager"
 	fw "nosqlEngine/src/service/file_writer"
 	r "nosqlEngine/src/service/retriever"
 	"nosqlEngine/src/service/ss_compacter"
 	"nosqlEngine/src/service/ss_parser"
+	"nosqlEngine/src/service/table_pins"
+	"nosqlEngine/src/storage/manifest"
 	m "nosqlEngine/src/storage/memtable"
 	wal "nosqlEngine/src/storage/wal"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/google/uuid"
 )
 
-var CONFIG = config.GetConfig()
+// testConfig points the default config at a fresh data directory for one test
+func testConfig(t *testing.T) config.Config {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	return cfg
+}
+
+// tableNumber hands out the file numbers of the tables tests flush themselves
+var tableNumber atomic.Uint64
+
+// flushTestTable writes count generated entries into a new lvl0 SSTable and returns its path
+func flushTestTable(t *testing.T, cfg config.Config, bm *b.BlockManager, keyFormat string, count int) string {
+	mt := m.NewMemtable(cfg)
+	for i := 0; i < count; i++ {
+		mt.Add(key_value.NewKeyValue(fmt.Sprintf(keyFormat, i+1), fmt.Sprintf("value%d", i+1)))
+	}
+	fileWriter := fw.NewFileWriter(bm, cfg.BlockSize, cfg.SSTableDir(), fw.TableName(0, tableNumber.Add(1)))
+	location := fileWriter.GetLocation()
+	ss_parser.NewSSParser(fileWriter, cfg).FlushMemtable(mt.ToRaw())
+	return location
+}
+
+// openTestManifest opens the manifest of cfg, adopting the tables flushed so far
+func openTestManifest(t *testing.T, cfg config.Config, bm *b.BlockManager) *manifest.Manifest {
+	mf, err := manifest.Open(bm, cfg)
+	if err != nil {
+		t.Fatalf("Failed to open manifest: %v", err)
+	}
+	t.Cleanup(func() { mf.Close() })
+	return mf
+}
+
+// lookupKey returns the newest version of key in the tables mf records, read
+// in the order the engine reads them
+func lookupKey(cfg config.Config, bm *b.BlockManager, mf *manifest.Manifest, key string) (key_value.KeyValue, bool, error) {
+	tables := mf.PathsOf(manifest.SearchOrder(mf.Tables(), key, cfg.Comparator().CompareStrings))
+	return r.NewEntryRetriever(bm, cfg).RetrieveEntryAt(key, tables, math.MaxUint64)
+}
 
 func bytesToInt(buf []byte) int64 {
 
@@ -24,24 +68,26 @@ func bytesToInt(buf []byte) int64 {
 }
 func TestWritePathIntegration(t *testing.T) {
 	// Setup block manager and file writer
-	bm := b.NewBlockManager()
-	blockSize := CONFIG.BlockSize
-	fileWriter := fw.NewFileWriter(bm, blockSize, "sstable/sstable_"+uuid.New().String()+".db")
-	ssParser := ss_parser.NewSSParser(fileWriter)
-	mt := m.NewMemtable()
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	blockSize := cfg.BlockSize
+	fileWriter := fw.NewFileWriter(bm, blockSize, cfg.SSTableDir(), "lvl0/sstable_"+uuid.New().String()+".db")
+	location := fileWriter.GetLocation() // the parser moves the writer to a new file after flushing
+	ssParser := ss_parser.NewSSParser(fileWriter, cfg)
+	mt := m.NewMemtable(cfg)
 
 	// Create a set of key-value pairs
 	for i := 0; i < 10; i++ {
 		key := fmt.Sprintf("key%d", i+1)
 		value := fmt.Sprintf("value%d", i+1)
-		mt.Add(key, value)
+		mt.Add(key_value.NewKeyValue(key, value))
 	}
 
 	// Write the memtable to disk via the parser and file writer
 	ssParser.FlushMemtable(mt.ToRaw())
 
 	// Read the file to verify the data
-	data, err := bm.ReadBlock(fileWriter.GetLocation(), 0, true)
+	data, err := bm.ReadBlock(location, 0, true)
 	if err != nil {
 		t.Fatalf("Failed to read block: %v", err)
 	}
@@ -62,20 +108,21 @@ func TestWritePathIntegration(t *testing.T) {
 }
 
 func TestWriteRead(t *testing.T) {
-	mt := m.NewMemtable()
-	bm := b.NewBlockManager()
-	blockSize := CONFIG.BlockSize
+	cfg := testConfig(t)
+	mt := m.NewMemtable(cfg)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	blockSize := cfg.BlockSize
 	uuidStr := uuid.New().String()
 
-	fileWriter := fw.NewFileWriter(bm, blockSize, "sstable/lvl0/sstable_"+uuidStr+".db")
-	ssParser := ss_parser.NewSSParser(fileWriter)
+	fileWriter := fw.NewFileWriter(bm, blockSize, cfg.SSTableDir(), "lvl0/sstable_"+uuidStr+".db")
+	ssParser := ss_parser.NewSSParser(fileWriter, cfg)
 
 	// Create a set of key-value pairs
 	for i := 0; i < 10; i++ {
 		key := fmt.Sprintf("keyyy%d", i+1)
 
 		value := fmt.Sprintf("valueee%d", i+1)
-		mt.Add(key, value)
+		mt.Add(key_value.NewKeyValue(key, value))
 
 	}
 
@@ -84,9 +131,7 @@ func TestWriteRead(t *testing.T) {
 	fmt.Print(
 		"File written successfully, now reading the data back...\n")
 
-	retriever := r.NewEntryRetriever(bm)
-
-	_, res, err := retriever.RetrieveEntry("keyyy1")
+	_, res, err := lookupKey(cfg, bm, openTestManifest(t, cfg, bm), "keyyy1")
 
 	if err != nil {
 		t.Fatalf("Failed to retrieve entry: %v for metadata: %v", err, res)
@@ -95,20 +140,21 @@ func TestWriteRead(t *testing.T) {
 }
 
 func TestPrefixScan(t *testing.T) {
-	mt := m.NewMemtable()
-	bm := b.NewBlockManager()
-	blockSize := CONFIG.BlockSize
+	cfg := testConfig(t)
+	mt := m.NewMemtable(cfg)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	blockSize := cfg.BlockSize
 	uuidStr := uuid.New().String()
 
-	fileWriter := fw.NewFileWriter(bm, blockSize,uuidStr+".db")
-	ssParser := ss_parser.NewSSParser(fileWriter)
+	fileWriter := fw.NewFileWriter(bm, blockSize, cfg.SSTableDir(), "lvl0/sstable_"+uuidStr+".db")
+	ssParser := ss_parser.NewSSParser(fileWriter, cfg)
 
 	// Create a set of key-value pairs
 	for i := 0; i < 1000; i++ {
 		key := fmt.Sprintf("key%d", i+1)
 
 		value := fmt.Sprintf("valueee%d", i+1)
-		mt.Add(key, value)
+		mt.Add(key_value.NewKeyValue(key, value))
 
 	}
 
@@ -117,18 +163,25 @@ func TestPrefixScan(t *testing.T) {
 	fmt.Print(
 		"File written successfully, now reading the data back...\n")
 
-	multiRetriever := r.NewMultiRetriever(bm)
-	results, err := multiRetriever.GetPrefixEntries("key1")
+	// the engine adopts the table, it predates the manifest
+	eng, err := engine.NewEngine(cfg)
 	if err != nil {
-		t.Fatalf("Failed to retrieve prefix entries: %v", err)
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer eng.Shut()
+	results := eng.PrefixScan("user", "key1", 1, 1000)
+	// key1, key10 to key19, key100 to key199 and key1000
+	if len(results) != 112 {
+		t.Errorf("Expected 112 entries with prefix 'key1', got %d", len(results))
 	}
 	fmt.Printf("Retrieved %d entries with prefix 'key1':\n", len(results))
 	fmt.Println("Entries:", results)
 }
 
 func TestWALWriteRead(t *testing.T) {
-	bm := b.NewBlockManager()
-	log, err := wal.NewWAL(bm)
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	log, err := wal.NewWAL(bm, cfg)
 	if err != nil {
 		t.Fatalf("Failed to create WAL: %v", err)
 	}
@@ -144,7 +197,7 @@ func TestWALWriteRead(t *testing.T) {
 
 	fmt.Println("WAL written successfully, now reading the data back...")
 
-	recoveredEntries, err := wal.ReplayWAL(bm)
+	recoveredEntries, _, err := wal.ReplayWAL(bm, cfg)
 	fmt.Println(recoveredEntries)
 	if err != nil {
 		t.Fatalf("Failed to replay WAL: %v", err)
@@ -156,8 +209,12 @@ func TestWALWriteRead(t *testing.T) {
 }
 
 func TestCompacter(t *testing.T) {
-	bm := b.NewBlockManager()
-	sc := ss_compacter.NewSSCompacterST()
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	for i := 0; i < cfg.CompactionThreshold; i++ {
+		flushTestTable(t, cfg, bm, fmt.Sprintf("table%d-key%%d", i), 10)
+	}
+	sc := ss_compacter.NewSSCompacterST(cfg, &sync.RWMutex{}, table_pins.NewTablePins(), openTestManifest(t, cfg, bm))
 
 	if !sc.CheckCompactionConditions(bm) {
 		t.Fatalf("Compaction conditions not met")
@@ -166,11 +223,12 @@ func TestCompacter(t *testing.T) {
 	fmt.Println("Compaction completed successfully")
 }
 func TestGas(t *testing.T) {
-	bm := b.NewBlockManager()
-	retriever := r.NewEntryRetriever(bm)
+	cfg := testConfig(t)
+	bm := b.NewBlockManager(cfg.BlockSize, cfg.CacheCapacity)
+	flushTestTable(t, cfg, bm, "keyyy%d", 10)
 
 	// Test retrieving a non-existent entry
-	_, _, err := retriever.RetrieveEntry("keyyy7")
+	_, _, err := lookupKey(cfg, bm, openTestManifest(t, cfg, bm), "keyyy7")
 	if err != nil {
 		t.Fatalf("Expected error for non-existent key, got nil")
 	}
@@ -3,27 +3,18 @@ package utils
 import (
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 )
 
-// getPaths returns the paths of all the files in the sstable folder
-func getProjectRoot() string {
-	_, filename, _, _ := runtime.Caller(0)
-	// Go up from src/service/file_writer/writer.go to project root
-	projectRoot := filepath.Dir(filepath.Dir(filepath.Dir(filename)))
-	return projectRoot
-}
-
-func GetPaths(relativePath string, ext string) []string {
-	folderPath := filepath.Join(getProjectRoot(), relativePath)
+// GetPaths returns the paths of all the files in dir with the given extension
+func GetPaths(dir string, ext string) []string {
 	var paths []string
-	files, _ := os.ReadDir(folderPath)
+	files, _ := os.ReadDir(dir)
 	for _, file := range files {
 		if !strings.HasSuffix(file.Name(), ext) {
 			continue
 		}
-		paths = append(paths, filepath.Join(folderPath, file.Name()))
+		paths = append(paths, filepath.Join(dir, file.Name()))
 	}
 	return paths
-}
+}
\ No newline at end of file